@@ -10,12 +10,23 @@ import (
 	"time"
 
 	"Avito-trainee/internal/auth"
+	"Avito-trainee/internal/balance"
+	"Avito-trainee/internal/cart"
 	"Avito-trainee/internal/coin"
 	"Avito-trainee/internal/config"
 	"Avito-trainee/internal/db"
+	"Avito-trainee/internal/events"
+	"Avito-trainee/internal/hold"
 	"Avito-trainee/internal/info"
+	"Avito-trainee/internal/invoice"
+	"Avito-trainee/internal/leaderboard"
 	"Avito-trainee/internal/merch"
 	middleware2 "Avito-trainee/internal/middleware"
+	"Avito-trainee/internal/models"
+	"Avito-trainee/internal/reconcile"
+	"Avito-trainee/internal/reversal"
+	"Avito-trainee/internal/schedule"
+	"Avito-trainee/internal/statement"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -40,26 +51,122 @@ func main() {
 		log.Fatalf("Migration failed: %v", err)
 	}
 
-	authService := auth.NewAuthService(dbConn, cfg.JWTSecret)
-	coinService := coin.NewCoinService(dbConn)
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		code := runReconcile(dbConn, os.Args[2:])
+		dbConn.Close()
+		os.Exit(code)
+	}
+
+	keys := auth.NewHMACKeySet(cfg.JWTSecret)
+	if cfg.JWTKeysDir != "" {
+		keys, err = auth.LoadKeySet(cfg.JWTKeysDir, cfg.JWTKeyID)
+		if err != nil {
+			log.Fatalf("Can't load JWT keys: %v\n", err)
+		}
+	}
+	jwtManager := auth.NewJWTManager(keys, auth.AccessTokenDuration)
+
+	authService := auth.NewAuthService(dbConn, jwtManager, auth.Options{AutoRegister: cfg.AutoRegister})
+	loginGuard := auth.NewLoginGuard(dbConn, auth.LockoutOptions{
+		MaxAttempts:   cfg.LoginMaxAttempts,
+		IPMaxAttempts: cfg.LoginIPMaxAttempts,
+		BaseDelay:     cfg.LoginLockoutBase,
+		MaxDelay:      cfg.LoginLockoutMax,
+		Window:        cfg.LoginAttemptWindow,
+	})
+	transferPolicy := coin.NewLimitsPolicy(coin.PolicyConfig{
+		MinAmount:           cfg.TransferMinAmount,
+		MaxAmount:           cfg.TransferMaxAmount,
+		DailyLimit:          cfg.TransferDailyLimit,
+		WeeklyLimit:         cfg.TransferWeeklyLimit,
+		RecipientDailyLimit: cfg.TransferRecipientDailyLimit,
+		BlockedPairs:        cfg.TransferBlockedPairs,
+	})
+	coinService := coin.NewCoinService(dbConn, transferPolicy)
 	merchService := merch.NewMerchService(dbConn)
 	infoService := info.NewInfoService(dbConn)
+	balanceService := balance.NewBalanceService(dbConn)
+	cartService := cart.NewCartService(dbConn)
+	invoiceService := invoice.NewInvoiceService(dbConn, coinService, cfg.InvoiceTTL)
+	scheduleService := schedule.NewScheduleService(dbConn, coinService)
+	holdService := hold.NewHoldService(dbConn, cfg.HoldTTL)
+	reversalService := reversal.NewReversalService(dbConn)
+	statementService := statement.NewStatementService(dbConn)
+	leaderboardService := leaderboard.NewLeaderboardService(dbConn)
+	eventsService := events.NewEventsService(dbConn)
+	eventsHub := events.NewHub()
 
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
+	r.Get("/.well-known/jwks.json", auth.MakeJWKSHandler(jwtManager))
+
 	r.Route("/api", func(r chi.Router) {
-		r.Post("/auth", auth.MakeAuthHandler(authService))
+		r.Post("/register", auth.MakeRegisterHandler(authService))
+		r.Post("/auth", auth.MakeAuthHandler(authService, loginGuard))
+		r.Post("/auth/refresh", auth.MakeRefreshHandler(authService))
+		r.Get("/merch", merch.MakeListHandler(merchService, false))
 	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(middleware2.JWTAuthMiddleware(dbConn, cfg.JWTSecret))
+		r.Use(middleware2.JWTAuthMiddleware(dbConn, jwtManager))
 
+		r.Post("/api/auth/logout", auth.MakeLogoutHandler(authService))
 		r.Get("/api/info", info.MakeInfoHandler(infoService))
-		r.Post("/api/sendCoin", coin.MakeSendCoinHandler(coinService))
-		r.Get("/api/buy/{item}", merch.MakeBuyHandler(merchService))
+		r.Get("/api/transactions", info.MakeTransactionsHandler(infoService))
+		r.Get("/api/statement", statement.MakeStatementHandler(statementService))
+		r.Get("/api/leaderboard", leaderboard.MakeGetHandler(leaderboardService))
+		r.Post("/api/leaderboard/opt-out", leaderboard.MakeOptOutHandler(leaderboardService, true))
+		r.Delete("/api/leaderboard/opt-out", leaderboard.MakeOptOutHandler(leaderboardService, false))
+		r.Get("/api/events", events.MakeEventsHandler(eventsHub, eventsService))
+		// Операции с деньгами принимают Idempotency-Key
+		idempotent := middleware2.Idempotency(dbConn, cfg.IdempotencyTTL)
+
+		r.With(idempotent).Post("/api/sendCoin", coin.MakeSendCoinHandler(coinService))
+		r.With(idempotent).Post("/api/sendCoin/batch", coin.MakeSendCoinBatchHandler(coinService))
+		r.With(idempotent).Get("/api/buy/{item}", merch.MakeBuyHandler(merchService))
+		r.Get("/api/cart", cart.MakeGetCartHandler(cartService))
+		r.Post("/api/cart/items", cart.MakeAddItemHandler(cartService))
+		r.Delete("/api/cart/items/{item}", cart.MakeRemoveItemHandler(cartService))
+		r.With(idempotent).Post("/api/checkout", cart.MakeCheckoutHandler(cartService))
+		r.Get("/api/invoices", invoice.MakeListHandler(invoiceService))
+		r.Post("/api/invoices", invoice.MakeCreateHandler(invoiceService))
+		r.With(idempotent).Post("/api/invoices/{id}/accept", invoice.MakeAcceptHandler(invoiceService))
+		r.Post("/api/invoices/{id}/decline", invoice.MakeDeclineHandler(invoiceService))
+		r.Post("/api/invoices/{id}/cancel", invoice.MakeCancelHandler(invoiceService))
+		r.Get("/api/scheduled-transfers", schedule.MakeListHandler(scheduleService))
+		r.Post("/api/scheduled-transfers", schedule.MakeCreateHandler(scheduleService))
+		r.Patch("/api/scheduled-transfers/{id}", schedule.MakeUpdateHandler(scheduleService))
+		r.Delete("/api/scheduled-transfers/{id}", schedule.MakeDeleteHandler(scheduleService))
+		r.Get("/api/scheduled-transfers/{id}/runs", schedule.MakeRunsHandler(scheduleService))
+		r.Get("/api/holds", hold.MakeListHandler(holdService))
+		r.With(idempotent).Post("/api/holds", hold.MakePlaceHandler(holdService))
+		r.Post("/api/transactions/{id}/reversal", reversal.MakeRequestReversalHandler(reversalService))
+		r.With(idempotent).Post("/api/transactions/{id}/return", reversal.MakeReturnHandler(reversalService))
+
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(middleware2.RequireRole(models.RoleAdmin))
+			r.Put("/users/{username}/role", auth.MakeSetRoleHandler(authService))
+			r.With(idempotent).Post("/coins/grant", balance.MakeGrantHandler(balanceService))
+			r.With(idempotent).Post("/coins/deduct", balance.MakeDeductHandler(balanceService))
+			r.With(idempotent).Post("/coins/adjust", balance.MakeAdjustHandler(balanceService))
+
+			r.Get("/merch", merch.MakeListHandler(merchService, true))
+			r.Post("/merch", merch.MakeCreateItemHandler(merchService))
+			r.Patch("/merch/{slug}", merch.MakeUpdateItemHandler(merchService))
+			r.Delete("/merch/{slug}", merch.MakeRetireItemHandler(merchService))
+			r.Post("/merch/{slug}/restock", merch.MakeRestockHandler(merchService))
+
+			r.With(idempotent).Post("/holds/{id}/capture", hold.MakeCaptureHandler(holdService))
+			r.With(idempotent).Post("/holds/{id}/release", hold.MakeReleaseHandler(holdService))
+
+			r.Get("/reversals", reversal.MakeListRequestsHandler(reversalService))
+			r.With(idempotent).Post("/reversals/{id}/approve", reversal.MakeApproveHandler(reversalService))
+			r.Post("/reversals/{id}/reject", reversal.MakeRejectHandler(reversalService))
+			r.With(idempotent).Post("/transactions/{id}/refund", reversal.MakeRefundHandler(reversalService))
+		})
 	})
 
 	server := &http.Server{
@@ -69,6 +176,36 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+	// Потоки событий не завершаются сами, их нужно закрыть, чтобы Shutdown не ждал до таймаута
+	server.RegisterOnShutdown(eventsHub.Close)
+
+	// Фоновые задачи останавливаются вместе с сервером
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	if cfg.ReconcileInterval > 0 {
+		go reconcile.Schedule(jobsCtx, reconcile.NewReconcileService(dbConn), cfg.ReconcileInterval, cfg.ReconcileFix)
+	}
+	if cfg.SchedulerInterval > 0 {
+		go schedule.Start(jobsCtx, scheduleService, cfg.SchedulerInterval)
+	}
+	if cfg.HoldExpiryInterval > 0 {
+		go hold.Start(jobsCtx, holdService, cfg.HoldExpiryInterval)
+	}
+	if cfg.LeaderboardRefreshInterval > 0 {
+		go leaderboard.Start(jobsCtx, leaderboardService, cfg.LeaderboardRefreshInterval)
+	}
+	if cfg.EventsRetention > 0 {
+		go events.Start(jobsCtx, eventsService, cfg.EventsRetention)
+	}
+	if cfg.EventsMaxDelay > 0 {
+		go events.WatchDelay(jobsCtx, eventsService, cfg.EventsMaxDelay)
+	}
+	go func() {
+		if err := eventsHub.Listen(jobsCtx, cfg.DatabaseURL); err != nil {
+			log.Printf("Events listener stopped: %v", err)
+		}
+	}()
 
 	go func() {
 		log.Printf("Server is listening on %s\n", server.Addr)
@@ -81,6 +218,7 @@ func main() {
 	signal.Notify(quit, os.Interrupt)
 	<-quit
 	log.Println("Server is shutting down...")
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
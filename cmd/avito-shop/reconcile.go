@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"Avito-trainee/internal/reconcile"
+)
+
+// runReconcile Подкоманда "reconcile [-fix]": разовая сверка балансов с отчетом в stdout.
+// Код выхода 1, если расхождения найдены и не исправлены
+func runReconcile(dbConn *sql.DB, args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "write correcting adjustment entries for every mismatch")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	report, err := reconcile.NewReconcileService(dbConn).Run(context.Background(), *fix)
+	if err != nil {
+		log.Printf("Reconciliation failed: %v", err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Printf("Unable to encode report: %v", err)
+		return 1
+	}
+
+	if len(report.Mismatches) > 0 && !report.Fixed {
+		return 1
+	}
+	return 0
+}
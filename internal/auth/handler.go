@@ -2,7 +2,12 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type Request struct {
@@ -11,24 +16,193 @@ type Request struct {
 }
 
 type Response struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
 }
 
-func MakeAuthHandler(s Service) http.HandlerFunc {
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func MakeAuthHandler(s Service, g LoginGuard) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req Request
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		token, err := s.Authenticate(r.Context(), req.Username, req.Password)
+
+		ip := clientIP(r)
+		if err := g.Check(r.Context(), req.Username, ip); err != nil {
+			writeLockoutError(w, err)
+			return
+		}
+
+		tokens, err := s.Authenticate(r.Context(), req.Username, req.Password)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidCredentials):
+				if err := g.Fail(r.Context(), req.Username, ip); err != nil {
+					writeLockoutError(w, err)
+					return
+				}
+				http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			case errors.Is(err, ErrInvalidUsername), errors.Is(err, ErrWeakPassword):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err := g.Reset(r.Context(), req.Username); err != nil {
+			log.Printf("Unable to reset login attempts: %v", err)
+		}
+
+		writeTokens(w, tokens)
+	}
+}
+
+func MakeRegisterHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := s.Register(r.Context(), req.Username, req.Password)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidUsername), errors.Is(err, ErrWeakPassword):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, ErrUserExists):
+				http.Error(w, "Username is already taken", http.StatusConflict)
+			default:
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(Response{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken})
+	}
+}
+
+func MakeRefreshHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RefreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := s.Refresh(r.Context(), req.RefreshToken)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			switch {
+			case errors.Is(err, ErrInvalidRefreshToken), errors.Is(err, ErrRefreshTokenReused):
+				http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			default:
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
 			return
 		}
 
-		resp := Response{Token: token}
+		writeTokens(w, tokens)
+	}
+}
+
+func MakeLogoutHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Тело запроса необязательно: без refresh-токена отзывается только access-токен
+		var req RefreshRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+
+		accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		err := s.Logout(r.Context(), accessToken, req.RefreshToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidToken):
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			default:
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Logged out successfully"))
+	}
+}
+
+// writeLockoutError Ответ 429 с заголовком Retry-After для заблокированного входа
+func writeLockoutError(w http.ResponseWriter, err error) {
+	var lockout *LockoutError
+	if !errors.As(err, &lockout) {
+		log.Printf("Login guard error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(lockout.RetryAfter.Seconds())))
+	http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+}
+
+// clientIP Адрес клиента; за обратным прокси RemoteAddr должен выставлять middleware.RealIP
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeTokens(w http.ResponseWriter, tokens Tokens) {
+	resp := Response{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func MakeSetRoleHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SetRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		err := s.SetRole(r.Context(), r.PathValue("username"), req.Role)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidRole):
+				http.Error(w, "Invalid role", http.StatusBadRequest)
+			case errors.Is(err, ErrUserNotFound):
+				http.Error(w, "User not found", http.StatusNotFound)
+			default:
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Role updated successfully"))
+	}
+}
+
+func MakeJWKSHandler(m *JWTManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(m.JWKS())
 	}
 }
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -9,50 +11,91 @@ import (
 
 // JWTManager Структура менеджера:
 //
-//	Секретный ключ
+//	Набор ключей подписи
 //	Длительность токена
 type JWTManager struct {
-	secretKey     string
+	keys          *KeySet
 	tokenDuration time.Duration
 }
 
+// Claims Полезная нагрузка токена: стандартные поля и роль пользователя
+type Claims struct {
+	jwt.StandardClaims
+	Role string `json:"role"`
+}
+
 // NewJWTManager Функция создания нового менеджера
-func NewJWTManager(secretKey string, tokenDuration time.Duration) *JWTManager {
+func NewJWTManager(keys *KeySet, tokenDuration time.Duration) *JWTManager {
 	return &JWTManager{
-		secretKey:     secretKey,
+		keys:          keys,
 		tokenDuration: tokenDuration,
 	}
 }
 
-// Generate Генерация токена по имени пользователя
-func (j *JWTManager) Generate(username string) (string, error) {
-	claims := jwt.StandardClaims{
-		Subject:   username,
-		ExpiresAt: time.Now().Add(j.tokenDuration).Unix(),
-		IssuedAt:  time.Now().Unix(),
+// Generate Генерация токена по имени и роли пользователя.
+// Каждый токен получает уникальный идентификатор (jti), по которому его можно отозвать
+func (j *JWTManager) Generate(username, role string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   username,
+			ExpiresAt: time.Now().Add(j.tokenDuration).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+		Role: role,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(j.secretKey))
+	key := j.keys.active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
 }
 
-// Verify Подтверждение токена и возврат имени пользователя
-func (j *JWTManager) Verify(accessToken string) (string, error) {
+// Verify Подтверждение токена и возврат его полезной нагрузки.
+// Ключ выбирается по kid, алгоритм токена обязан совпадать с алгоритмом ключа
+func (j *JWTManager) Verify(accessToken string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(
 		accessToken,
-		&jwt.StandardClaims{},
+		&Claims{},
 		func(token *jwt.Token) (interface{}, error) {
-			return []byte(j.secretKey), nil
+			kid, _ := token.Header["kid"].(string)
+			key, err := j.keys.lookup(kid)
+			if err != nil {
+				return nil, err
+			}
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, ErrUnexpectedMethod
+			}
+			return key.Public, nil
 		},
 	)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	claims, ok := token.Claims.(*jwt.StandardClaims)
+	claims, ok := token.Claims.(*Claims)
 	if !ok || !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	return claims.Subject, nil
+	return claims, nil
+}
+
+// randomToken Генерация случайной строки из n байт в hex-представлении
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// JWKS Открытые ключи для проверки токенов сторонними сервисами
+func (j *JWTManager) JWKS() JWKS {
+	return j.keys.JWKS()
 }
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrNoActiveKey      = errors.New("active signing key is not set or has no private part")
+	ErrUnsupportedKey   = errors.New("unsupported key type")
+	ErrUnexpectedMethod = errors.New("unexpected signing method")
+)
+
+// SigningKey Ключ подписи токенов:
+//
+//	ID - идентификатор ключа (kid в заголовке токена)
+//	Method - алгоритм подписи, токены с другим alg отклоняются
+//	Private - закрытый ключ, nil для ключей, оставленных только для проверки
+//	Public - ключ для проверки подписи
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// KeySet Набор ключей: одним (активным) подписываются новые токены,
+// все остальные продолжают принимать ранее выданные токены
+type KeySet struct {
+	keys     map[string]*SigningKey
+	activeID string
+}
+
+// NewKeySet Создание набора ключей с указанным активным ключом
+func NewKeySet(activeID string, keys ...*SigningKey) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*SigningKey, len(keys)), activeID: activeID}
+	for _, k := range keys {
+		ks.keys[k.ID] = k
+	}
+
+	active, ok := ks.keys[activeID]
+	if !ok || active.Private == nil {
+		return nil, ErrNoActiveKey
+	}
+	return ks, nil
+}
+
+// NewHMACKeySet Набор из одного симметричного ключа HS256 (режим JWT_SECRET)
+func NewHMACKeySet(secret string) *KeySet {
+	key := &SigningKey{
+		ID:      "default",
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	}
+	return &KeySet{keys: map[string]*SigningKey{key.ID: key}, activeID: key.ID}
+}
+
+// LoadKeySet Загрузка ключей из директории: каждый файл <kid>.pem содержит
+// закрытый или открытый ключ RSA либо Ed25519.
+// Для ротации достаточно положить новый ключ и сменить activeID,
+// а выведенный из оборота ключ можно оставить в виде открытого ключа
+func LoadKeySet(dir, activeID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(activeID, keys...)
+}
+
+func parseKey(kid string, data []byte) (*SigningKey, error) {
+	if priv, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Private: priv, Public: &priv.PublicKey}, nil
+	}
+	if priv, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		edPriv, ok := priv.(ed25519.PrivateKey)
+		if !ok {
+			return nil, ErrUnsupportedKey
+		}
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: edPriv, Public: edPriv.Public()}, nil
+	}
+	if pub, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Public: pub}, nil
+	}
+	if pub, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Public: pub}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// active Ключ для подписи новых токенов
+func (ks *KeySet) active() *SigningKey {
+	return ks.keys[ks.activeID]
+}
+
+// lookup Поиск ключа для проверки токена.
+// Токены без kid были выданы до появления ротации и проверяются активным ключом
+func (ks *KeySet) lookup(kid string) (*SigningKey, error) {
+	if kid == "" {
+		return ks.active(), nil
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// JWK Открытый ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS Публикуемые открытые ключи. Симметричные ключи не публикуются
+func (ks *KeySet) JWKS() JWKS {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range ids {
+		key := ks.keys[id]
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return set
+}
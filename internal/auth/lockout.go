@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+const (
+	scopeUser = "user"
+	scopeIP   = "ip"
+)
+
+// LockoutError Вход временно заблокирован, повторить попытку можно через RetryAfter
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%v: retry after %v", ErrTooManyAttempts, e.RetryAfter)
+}
+
+func (e *LockoutError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// LockoutOptions Настройки защиты от перебора паролей:
+//
+//	MaxAttempts - число неудачных попыток для одного имени до блокировки
+//	IPMaxAttempts - то же для одного IP-адреса
+//	BaseDelay - длительность первой блокировки, каждая следующая вдвое дольше
+//	MaxDelay - максимальная длительность блокировки
+//	Window - через сколько после последней ошибки счетчик начинается заново
+type LockoutOptions struct {
+	MaxAttempts   int
+	IPMaxAttempts int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	Window        time.Duration
+}
+
+// LoginGuard Учет неудачных попыток входа по имени пользователя и IP-адресу.
+// Проверка выполняется до сравнения bcrypt, поэтому заблокированные запросы ничего не стоят
+type LoginGuard interface {
+	Check(ctx context.Context, username, ip string) error
+	Fail(ctx context.Context, username, ip string) error
+	Reset(ctx context.Context, username string) error
+}
+
+type loginGuard struct {
+	db   *sql.DB
+	opts LockoutOptions
+}
+
+func NewLoginGuard(db *sql.DB, opts LockoutOptions) LoginGuard {
+	return &loginGuard{db: db, opts: opts}
+}
+
+// Check Возвращает *LockoutError, если имя или адрес сейчас заблокированы
+func (g *loginGuard) Check(ctx context.Context, username, ip string) error {
+	const op = "auth/lockout/Check"
+	var seconds float64
+	err := g.db.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(MAX(EXTRACT(EPOCH FROM locked_until - now())), 0)
+				FROM login_attempts
+				WHERE ((scope = 'user' AND key = $1) OR (scope = 'ip' AND key = $2))
+				AND locked_until > now()`,
+		username,
+		ip,
+	).Scan(&seconds)
+	if err != nil {
+		return fmt.Errorf("%v: unable to check lockout: %w", op, err)
+	}
+
+	if seconds > 0 {
+		return &LockoutError{RetryAfter: time.Duration(math.Ceil(seconds)) * time.Second}
+	}
+	return nil
+}
+
+// Fail Учет неудачной попытки. Возвращает *LockoutError, если попытка привела к блокировке
+func (g *loginGuard) Fail(ctx context.Context, username, ip string) error {
+	userDelay, err := g.registerFailure(ctx, scopeUser, username, g.opts.MaxAttempts)
+	if err != nil {
+		return err
+	}
+	ipDelay, err := g.registerFailure(ctx, scopeIP, ip, g.opts.IPMaxAttempts)
+	if err != nil {
+		return err
+	}
+
+	if delay := max(userDelay, ipDelay); delay > 0 {
+		return &LockoutError{RetryAfter: delay}
+	}
+	return nil
+}
+
+// Reset Сброс счетчика имени после успешного входа.
+// Счетчик адреса не сбрасывается: иначе вход в свой аккаунт обнулял бы перебор чужих
+func (g *loginGuard) Reset(ctx context.Context, username string) error {
+	const op = "auth/lockout/Reset"
+	_, err := g.db.ExecContext(
+		ctx,
+		"DELETE FROM login_attempts WHERE scope = 'user' AND key = $1",
+		username,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to reset attempts: %w", op, err)
+	}
+	return nil
+}
+
+func (g *loginGuard) registerFailure(ctx context.Context, scope, key string, maxAttempts int) (time.Duration, error) {
+	const op = "auth/lockout/registerFailure"
+	var failedCount int
+	err := g.db.QueryRowContext(
+		ctx,
+		`INSERT INTO login_attempts (scope, key, failed_count, updated_at) VALUES ($1, $2, 1, now())
+				ON CONFLICT (scope, key) DO UPDATE SET
+				failed_count = CASE
+					WHEN login_attempts.updated_at < now() - make_interval(secs => $3) THEN 1
+					ELSE login_attempts.failed_count + 1
+				END,
+				updated_at = now()
+				RETURNING failed_count`,
+		scope,
+		key,
+		g.opts.Window.Seconds(),
+	).Scan(&failedCount)
+	if err != nil {
+		return 0, fmt.Errorf("%v: unable to register failure: %w", op, err)
+	}
+
+	delay := g.delay(failedCount, maxAttempts)
+	if delay == 0 {
+		return 0, nil
+	}
+
+	_, err = g.db.ExecContext(
+		ctx,
+		"UPDATE login_attempts SET locked_until = now() + make_interval(secs => $3) WHERE scope = $1 AND key = $2",
+		scope,
+		key,
+		delay.Seconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%v: unable to lock: %w", op, err)
+	}
+	return delay, nil
+}
+
+// delay Экспоненциальная задержка: BaseDelay после N-й ошибки, затем удвоение до MaxDelay
+func (g *loginGuard) delay(failedCount, maxAttempts int) time.Duration {
+	if maxAttempts <= 0 || failedCount < maxAttempts {
+		return 0
+	}
+
+	delay := g.opts.BaseDelay
+	for i := maxAttempts; i < failedCount && delay < g.opts.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, g.opts.MaxDelay)
+}
@@ -2,60 +2,372 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"regexp"
 	"time"
+	"unicode"
+
+	"Avito-trainee/internal/models"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrUserExists          = errors.New("username is already taken")
+	ErrInvalidUsername     = errors.New("username must be 3-50 characters long and contain only latin letters, digits, '.', '_' or '-'")
+	ErrWeakPassword        = errors.New("password must be 8-72 bytes long and contain at least one letter and one digit")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+const (
+	// AccessTokenDuration Access-токен живет недолго: отозвать его можно только по jti
+	AccessTokenDuration = 15 * time.Minute
+	// Refresh-токен ротируется при каждом использовании
+	refreshTokenDuration = 30 * 24 * time.Hour
+
+	minPasswordLength = 8
+	// bcrypt учитывает только первые 72 байта пароля
+	maxPasswordLength = 72
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,50}$`)
+
+// dummyHash Сравнение с ним выравнивает время ответа для несуществующих пользователей,
+// чтобы по задержке нельзя было определить, занято ли имя
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password-1"), bcrypt.DefaultCost)
+
+// Options Настройки сервиса авторизации:
+//
+//	AutoRegister - создавать пользователя при первом входе с неизвестным именем
+type Options struct {
+	AutoRegister bool
+}
+
+// Tokens Пара токенов, выдаваемая при входе и обновлении
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
 type Service interface {
-	Authenticate(ctx context.Context, username, password string) (string, error)
+	Register(ctx context.Context, username, password string) (Tokens, error)
+	Authenticate(ctx context.Context, username, password string) (Tokens, error)
+	Refresh(ctx context.Context, refreshToken string) (Tokens, error)
+	Logout(ctx context.Context, accessToken, refreshToken string) error
+	SetRole(ctx context.Context, username, role string) error
+}
+
+// execer Общий интерфейс *sql.DB и *sql.Tx для записи токенов
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
 type service struct {
 	db         *sql.DB
 	jwtManager *JWTManager
+	opts       Options
 }
 
-func NewAuthService(db *sql.DB, jwtSecret string) Service {
-	jwtManager := NewJWTManager(jwtSecret, 24*60*60*time.Second)
+func NewAuthService(db *sql.DB, jwtManager *JWTManager, opts Options) Service {
 	return &service{
 		db:         db,
 		jwtManager: jwtManager,
+		opts:       opts,
 	}
 }
 
-func (s *service) Authenticate(ctx context.Context, username, password string) (string, error) {
-	var storedHash string
+// Register Явная регистрация нового пользователя с проверкой имени и пароля
+func (s *service) Register(ctx context.Context, username, password string) (Tokens, error) {
+	const op = "auth/service/Register"
+	if err := validateCredentials(username, password); err != nil {
+		return Tokens{}, err
+	}
+
+	userID, err := s.createUser(ctx, username, password)
+	if err != nil {
+		if errors.Is(err, ErrUserExists) {
+			return Tokens{}, err
+		}
+		return Tokens{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return s.issueTokens(ctx, s.db, userID, username, models.RoleUser, "")
+}
+
+func (s *service) Authenticate(ctx context.Context, username, password string) (Tokens, error) {
+	var (
+		userID     int
+		storedHash string
+		role       string
+	)
 	err := s.db.QueryRowContext(
 		ctx,
-		`SELECT password_hash FROM users WHERE username = $1`,
+		`SELECT id, password_hash, role FROM users WHERE username = $1`,
 		username,
-	).Scan(&storedHash)
+	).Scan(&userID, &storedHash, &role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-			if err != nil {
-				return "", err
+			if !s.opts.AutoRegister {
+				bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+				return Tokens{}, ErrInvalidCredentials
 			}
 
-			_, err = s.db.ExecContext(
-				ctx,
-				"INSERT INTO users (username, password_hash, coins) VALUES ($1, $2, $3)",
-				username,
-				string(hashedPwd),
-				1000,
-			)
+			if err := validateCredentials(username, password); err != nil {
+				return Tokens{}, err
+			}
+			userID, err = s.createUser(ctx, username, password)
 			if err != nil {
-				return "", err
+				return Tokens{}, err
 			}
-			return s.jwtManager.Generate(username)
+			return s.issueTokens(ctx, s.db, userID, username, models.RoleUser, "")
 		}
-		return "", err
+		return Tokens{}, err
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password)); err != nil {
-		return "", errors.New("invalid credentials")
+		return Tokens{}, ErrInvalidCredentials
+	}
+	return s.issueTokens(ctx, s.db, userID, username, role, "")
+}
+
+// Refresh Обмен refresh-токена на новую пару токенов.
+// Повторное предъявление уже использованного токена отзывает всё семейство
+func (s *service) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	const op = "auth/service/Refresh"
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var (
+		tokenID   int
+		userID    int
+		familyID  string
+		username  string
+		role      string
+		expiresAt time.Time
+		revokedAt sql.NullTime
+	)
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT rt.id, rt.user_id, rt.family_id, u.username, u.role, rt.expires_at, rt.revoked_at
+				FROM refresh_tokens rt
+				JOIN users u ON u.id = rt.user_id
+				WHERE rt.token_hash = $1
+				FOR UPDATE OF rt`,
+		hashToken(refreshToken),
+	).Scan(&tokenID, &userID, &familyID, &username, &role, &expiresAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Tokens{}, ErrInvalidRefreshToken
+		}
+		return Tokens{}, fmt.Errorf("%v: unable to find refresh token: %w", op, err)
+	}
+
+	if revokedAt.Valid {
+		// Токен уже был использован или отозван: считаем, что он украден
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL",
+			familyID,
+		)
+		if err != nil {
+			return Tokens{}, fmt.Errorf("%v: unable to revoke token family: %w", op, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return Tokens{}, fmt.Errorf("%v: unable to revoke token family: %w", op, err)
+		}
+		return Tokens{}, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(expiresAt) {
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = now() WHERE id = $1",
+		tokenID,
+	)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%v: unable to rotate refresh token: %w", op, err)
+	}
+
+	tokens, err := s.issueTokens(ctx, tx, userID, username, role, familyID)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%v: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Tokens{}, fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+	return tokens, nil
+}
+
+// Logout Отзыв access-токена по jti и семейства переданного refresh-токена
+func (s *service) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	const op = "auth/service/Logout"
+	claims, err := s.jwtManager.Verify(accessToken)
+	if err != nil || claims.Id == "" {
+		return ErrInvalidToken
+	}
+
+	_, err = s.db.ExecContext(
+		ctx,
+		`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, to_timestamp($2))
+				ON CONFLICT (jti) DO NOTHING`,
+		claims.Id,
+		claims.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to revoke access token: %w", op, err)
+	}
+
+	if refreshToken != "" {
+		_, err = s.db.ExecContext(
+			ctx,
+			`UPDATE refresh_tokens SET revoked_at = now()
+				WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)
+				AND user_id = (SELECT id FROM users WHERE username = $2)
+				AND revoked_at IS NULL`,
+			hashToken(refreshToken),
+			claims.Subject,
+		)
+		if err != nil {
+			return fmt.Errorf("%v: unable to revoke refresh token: %w", op, err)
+		}
+	}
+
+	// Истекшие jti больше не нужны: такие токены отклоняются и без списка отзыва
+	_, err = s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < now()")
+	if err != nil {
+		return fmt.Errorf("%v: unable to clean up revoked tokens: %w", op, err)
+	}
+	return nil
+}
+
+// SetRole Назначение роли пользователю. Роль в уже выданных access-токенах
+// обновится при следующем обмене refresh-токена, а проверка доступа читает роль из базы сразу
+func (s *service) SetRole(ctx context.Context, username, role string) error {
+	const op = "auth/service/SetRole"
+	if !models.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	res, err := s.db.ExecContext(
+		ctx,
+		"UPDATE users SET role = $1 WHERE username = $2",
+		role,
+		username,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to update role: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%v: unable to update role: %w", op, err)
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// createUser Создание пользователя со стартовым балансом
+func (s *service) createUser(ctx context.Context, username, password string) (int, error) {
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	var userID int
+	err = s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO users (username, password_hash, coins) VALUES ($1, $2, $3)
+				ON CONFLICT (username) DO NOTHING RETURNING id`,
+		username,
+		string(hashedPwd),
+		models.InitialCoins,
+	).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserExists
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// validateCredentials Проверка имени пользователя и пароля на соответствие политике
+func validateCredentials(username, password string) error {
+	if !usernamePattern.MatchString(username) {
+		return ErrInvalidUsername
+	}
+
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return ErrWeakPassword
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return ErrWeakPassword
+	}
+	return nil
+}
+
+// issueTokens Выпуск access-токена и нового refresh-токена.
+// Пустой familyID начинает новое семейство (новый вход)
+func (s *service) issueTokens(ctx context.Context, q execer, userID int, username, role, familyID string) (Tokens, error) {
+	accessToken, err := s.jwtManager.Generate(username, role)
+	if err != nil {
+		return Tokens{}, err
 	}
-	return s.jwtManager.Generate(username)
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	if familyID == "" {
+		familyID, err = randomToken(16)
+		if err != nil {
+			return Tokens{}, err
+		}
+	}
+
+	_, err = q.ExecContext(
+		ctx,
+		`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+				VALUES ($1, $2, $3, $4)`,
+		userID,
+		hashToken(refreshToken),
+		familyID,
+		time.Now().Add(refreshTokenDuration),
+	)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("unable to store refresh token: %w", err)
+	}
+
+	return Tokens{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// hashToken В базе хранится только SHA-256 от refresh-токена
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,94 @@
+package balance
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+type ChangeRequest struct {
+	Usernames []string `json:"usernames"`
+	Amount    int      `json:"amount"`
+	Reason    string   `json:"reason"`
+}
+
+type AdjustRequest struct {
+	Adjustments []Correction `json:"adjustments"`
+	Reason      string       `json:"reason"`
+}
+
+func MakeGrantHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		adminID := r.Context().Value("userID").(int)
+		if err := s.Grant(r.Context(), adminID, req.Usernames, req.Amount, req.Reason); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Coins granted successfully"))
+	}
+}
+
+func MakeDeductHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		adminID := r.Context().Value("userID").(int)
+		if err := s.Deduct(r.Context(), adminID, req.Usernames, req.Amount, req.Reason); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Coins deducted successfully"))
+	}
+}
+
+func MakeAdjustHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AdjustRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		adminID := r.Context().Value("userID").(int)
+		if err := s.Adjust(r.Context(), adminID, req.Adjustments, req.Reason); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Balances adjusted successfully"))
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var notFound *UsersNotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		http.Error(w, notFound.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidAmount),
+		errors.Is(err, ErrInvalidBalance),
+		errors.Is(err, ErrReasonRequired),
+		errors.Is(err, ErrReasonTooLong),
+		errors.Is(err, ErrNoUsers),
+		errors.Is(err, ErrTooManyUsers):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrInsufficientFunds):
+		http.Error(w, "Not enough coins", http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
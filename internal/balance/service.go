@@ -0,0 +1,312 @@
+package balance
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"Avito-trainee/internal/ledger"
+	"Avito-trainee/internal/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidAmount     = errors.New("amount must be positive")
+	ErrInvalidBalance    = errors.New("balance can't be negative")
+	ErrReasonRequired    = errors.New("reason is required")
+	ErrReasonTooLong     = errors.New("reason is too long")
+	ErrNoUsers           = errors.New("no users specified")
+	ErrTooManyUsers      = errors.New("too many users in one request")
+	ErrInsufficientFunds = errors.New("not enough coins")
+)
+
+const (
+	maxReasonLength = 255
+	maxBatchSize    = 1000
+)
+
+// UsersNotFoundError Часть пользователей из запроса не существует, операция не выполнена
+type UsersNotFoundError struct {
+	Usernames []string
+}
+
+func (e *UsersNotFoundError) Error() string {
+	return "users not found: " + strings.Join(e.Usernames, ", ")
+}
+
+// Correction Целевой баланс пользователя для корректировки
+type Correction struct {
+	Username string `json:"username"`
+	Balance  int    `json:"balance"`
+}
+
+// Service Административные операции с балансами. Каждая операция выполняется
+// для всех пользователей из запроса в одной транзакции и записывается в историю с основанием
+type Service interface {
+	Grant(ctx context.Context, adminID int, usernames []string, amount int, reason string) error
+	Deduct(ctx context.Context, adminID int, usernames []string, amount int, reason string) error
+	Adjust(ctx context.Context, adminID int, corrections []Correction, reason string) error
+}
+
+type service struct {
+	db *sql.DB
+}
+
+func NewBalanceService(db *sql.DB) Service {
+	return &service{db: db}
+}
+
+type account struct {
+	id       int
+	username string
+	coins    int
+}
+
+// Grant Начисление монет пользователям
+func (s *service) Grant(ctx context.Context, adminID int, usernames []string, amount int, reason string) error {
+	const op = "balance/service/Grant"
+	return s.changeBalances(ctx, op, adminID, usernames, amount, reason, models.TransactionGrant)
+}
+
+// Deduct Списание монет у пользователей. Если хотя бы у одного не хватает монет, не списывается ни у кого
+func (s *service) Deduct(ctx context.Context, adminID int, usernames []string, amount int, reason string) error {
+	const op = "balance/service/Deduct"
+	return s.changeBalances(ctx, op, adminID, usernames, amount, reason, models.TransactionDeduction)
+}
+
+func (s *service) changeBalances(ctx context.Context, op string, adminID int, usernames []string, amount int, reason, txType string) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	reason, err := validateReason(reason)
+	if err != nil {
+		return err
+	}
+	usernames, err = normalizeUsernames(usernames)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	accounts, err := lockAccounts(ctx, tx, usernames)
+	if err != nil {
+		return fmt.Errorf("%v: %w", op, err)
+	}
+
+	delta := amount
+	if txType == models.TransactionDeduction {
+		delta = -amount
+		for _, a := range accounts {
+			if a.coins < amount {
+				return ErrInsufficientFunds
+			}
+		}
+	}
+
+	ids := make([]int64, 0, len(accounts))
+	for _, a := range accounts {
+		ids = append(ids, int64(a.id))
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE users SET coins = coins + $1 WHERE id = ANY($2)",
+		delta,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to update balances: %w", op, err)
+	}
+
+	// Монеты начисляются из mint и при списании возвращаются туда же
+	entryType := ledger.EntryGrant
+	if txType == models.TransactionDeduction {
+		entryType = ledger.EntryDeduction
+	}
+	postings := make([]ledger.Posting, 0, len(accounts)+1)
+	for _, a := range accounts {
+		postings = append(postings, ledger.User(a.id, delta))
+	}
+	postings = append(postings, ledger.System(ledger.AccountMint, -delta*len(accounts)))
+	entryID, err := ledger.Post(ctx, tx, entryType, reason, postings...)
+	if err != nil {
+		return fmt.Errorf("%v: ledger error: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO transactions (user_id, type, counterparty, amount, reason, entry_id)
+				SELECT unnest($1::int[]), $2, username, $3, $4, $6 FROM users WHERE id = $5`,
+		pq.Array(ids),
+		txType,
+		amount,
+		reason,
+		adminID,
+		entryID,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: transaction error: %w", op, err)
+	}
+
+	return tx.Commit()
+}
+
+// Adjust Установка точного баланса. В историю пишется разница со знаком
+func (s *service) Adjust(ctx context.Context, adminID int, corrections []Correction, reason string) error {
+	const op = "balance/service/Adjust"
+	reason, err := validateReason(reason)
+	if err != nil {
+		return err
+	}
+
+	targets := make(map[string]int, len(corrections))
+	usernames := make([]string, 0, len(corrections))
+	for _, c := range corrections {
+		if c.Balance < 0 {
+			return ErrInvalidBalance
+		}
+		targets[strings.TrimSpace(c.Username)] = c.Balance
+		usernames = append(usernames, c.Username)
+	}
+	usernames, err = normalizeUsernames(usernames)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	accounts, err := lockAccounts(ctx, tx, usernames)
+	if err != nil {
+		return fmt.Errorf("%v: %w", op, err)
+	}
+
+	for _, a := range accounts {
+		delta := targets[a.username] - a.coins
+		if delta == 0 {
+			continue
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"UPDATE users SET coins = $1 WHERE id = $2",
+			targets[a.username],
+			a.id,
+		)
+		if err != nil {
+			return fmt.Errorf("%v: unable to update balance: %w", op, err)
+		}
+
+		entryID, err := ledger.Post(
+			ctx,
+			tx,
+			ledger.EntryAdjustment,
+			reason,
+			ledger.User(a.id, delta),
+			ledger.System(ledger.AccountMint, -delta),
+		)
+		if err != nil {
+			return fmt.Errorf("%v: ledger error: %w", op, err)
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO transactions (user_id, type, counterparty, amount, reason, entry_id)
+					SELECT $1, $2, username, $3, $4, $6 FROM users WHERE id = $5`,
+			a.id,
+			models.TransactionAdjustment,
+			delta,
+			reason,
+			adminID,
+			entryID,
+		)
+		if err != nil {
+			return fmt.Errorf("%v: transaction error: %w", op, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// lockAccounts Блокировка строк пользователей в порядке id, чтобы параллельные
+// пакетные операции не взаимоблокировались
+func lockAccounts(ctx context.Context, tx *sql.Tx, usernames []string) ([]account, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id, username, coins FROM users WHERE username = ANY($1) ORDER BY id FOR UPDATE",
+		pq.Array(usernames),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock users: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool, len(usernames))
+	accounts := make([]account, 0, len(usernames))
+	for rows.Next() {
+		var a account
+		if err := rows.Scan(&a.id, &a.username, &a.coins); err != nil {
+			return nil, fmt.Errorf("unable to read users: %w", err)
+		}
+		found[a.username] = true
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read users: %w", err)
+	}
+
+	if len(accounts) != len(usernames) {
+		missing := &UsersNotFoundError{}
+		for _, u := range usernames {
+			if !found[u] {
+				missing.Usernames = append(missing.Usernames, u)
+			}
+		}
+		return nil, missing
+	}
+	return accounts, nil
+}
+
+func validateReason(reason string) (string, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return "", ErrReasonRequired
+	}
+	if len([]rune(reason)) > maxReasonLength {
+		return "", ErrReasonTooLong
+	}
+	return reason, nil
+}
+
+// normalizeUsernames Удаление пустых имен и повторов с сохранением порядка
+func normalizeUsernames(usernames []string) ([]string, error) {
+	seen := make(map[string]bool, len(usernames))
+	result := make([]string, 0, len(usernames))
+	for _, u := range usernames {
+		u = strings.TrimSpace(u)
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		result = append(result, u)
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNoUsers
+	}
+	if len(result) > maxBatchSize {
+		return nil, ErrTooManyUsers
+	}
+	return result, nil
+}
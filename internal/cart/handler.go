@@ -0,0 +1,94 @@
+package cart
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"Avito-trainee/internal/merch"
+)
+
+type AddItemRequest struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+func MakeGetCartHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		cart, err := s.GetCart(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, cart)
+	}
+}
+
+func MakeAddItemHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AddItemRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Item == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		cart, err := s.AddItem(r.Context(), userID, req.Item, req.Quantity)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, cart)
+	}
+}
+
+func MakeRemoveItemHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		cart, err := s.RemoveItem(r.Context(), userID, r.PathValue("item"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, cart)
+	}
+}
+
+func MakeCheckoutHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		order, err := s.Checkout(r.Context(), userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, order)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var unavailable *UnavailableError
+	switch {
+	case errors.As(err, &unavailable) && errors.Is(err, merch.ErrSoldOut):
+		http.Error(w, "Item is sold out: "+unavailable.Item, http.StatusConflict)
+	case errors.As(err, &unavailable):
+		http.Error(w, "Item is no longer available: "+unavailable.Item, http.StatusConflict)
+	case errors.Is(err, merch.ErrItemNotFound):
+		http.Error(w, "Item not found", http.StatusNotFound)
+	case errors.Is(err, ErrInsufficientCoins):
+		http.Error(w, "Not enough coins", http.StatusBadRequest)
+	case errors.Is(err, ErrCartEmpty),
+		errors.Is(err, ErrInvalidQuantity),
+		errors.Is(err, ErrQuantityTooLarge):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,419 @@
+package cart
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"Avito-trainee/internal/ledger"
+	"Avito-trainee/internal/merch"
+	"Avito-trainee/internal/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidQuantity   = errors.New("quantity must be positive")
+	ErrQuantityTooLarge  = errors.New("quantity is too large")
+	ErrCartEmpty         = errors.New("cart is empty")
+	ErrInsufficientCoins = errors.New("not enough coins")
+)
+
+// maxLineQuantity Ограничение количества одного товара в корзине
+const maxLineQuantity = 100
+
+// UnavailableError Товар из корзины больше не продается или распродан, заказ не оформлен.
+// Is совпадает с merch.ErrItemNotFound или merch.ErrSoldOut
+type UnavailableError struct {
+	Item string
+	Err  error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Err, e.Item)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// Line Позиция корзины
+type Line struct {
+	Item      string `json:"item"`
+	Title     string `json:"title"`
+	Price     int    `json:"price"`
+	Quantity  int    `json:"quantity"`
+	Subtotal  int    `json:"subtotal"`
+	Available bool   `json:"available"`
+}
+
+// Cart Содержимое корзины по текущим ценам каталога
+type Cart struct {
+	Items []Line `json:"items"`
+	Total int    `json:"total"`
+}
+
+// OrderLine Позиция оформленного заказа с ценой на момент покупки
+type OrderLine struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+	Price    int    `json:"price"`
+}
+
+type Order struct {
+	ID    int         `json:"id"`
+	Total int         `json:"total"`
+	Items []OrderLine `json:"items"`
+}
+
+// Service Корзина пользователя и оформление заказа.
+// Checkout списывает монеты, резервирует запас и пополняет инвентарь в одной транзакции
+type Service interface {
+	GetCart(ctx context.Context, userID int) (Cart, error)
+	AddItem(ctx context.Context, userID int, item string, quantity int) (Cart, error)
+	RemoveItem(ctx context.Context, userID int, item string) (Cart, error)
+	Checkout(ctx context.Context, userID int) (Order, error)
+}
+
+type service struct {
+	db *sql.DB
+}
+
+func NewCartService(db *sql.DB) Service {
+	return &service{db: db}
+}
+
+// GetCart Корзина с актуальными ценами
+func (s *service) GetCart(ctx context.Context, userID int) (Cart, error) {
+	const op = "cart/service/GetCart"
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT c.item_slug, m.title, m.price, c.quantity, m.active AND (m.stock IS NULL OR m.stock >= c.quantity)
+				FROM cart_items c
+				JOIN merch_items m ON m.slug = c.item_slug
+				WHERE c.user_id = $1
+				ORDER BY c.item_slug`,
+		userID,
+	)
+	if err != nil {
+		return Cart{}, fmt.Errorf("%v: unable to get cart: %w", op, err)
+	}
+	defer rows.Close()
+
+	cart := Cart{Items: []Line{}}
+	for rows.Next() {
+		var l Line
+		if err := rows.Scan(&l.Item, &l.Title, &l.Price, &l.Quantity, &l.Available); err != nil {
+			return Cart{}, fmt.Errorf("%v: unable to read cart: %w", op, err)
+		}
+		l.Subtotal = l.Price * l.Quantity
+		cart.Total += l.Subtotal
+		cart.Items = append(cart.Items, l)
+	}
+	if err := rows.Err(); err != nil {
+		return Cart{}, fmt.Errorf("%v: unable to read cart: %w", op, err)
+	}
+	return cart, nil
+}
+
+// AddItem Добавление товара; если он уже в корзине, количество увеличивается
+func (s *service) AddItem(ctx context.Context, userID int, item string, quantity int) (Cart, error) {
+	const op = "cart/service/AddItem"
+	if quantity <= 0 {
+		return Cart{}, ErrInvalidQuantity
+	}
+	if quantity > maxLineQuantity {
+		return Cart{}, ErrQuantityTooLarge
+	}
+
+	// Лимит проверяется в самом upsert, чтобы параллельные добавления его не обошли
+	res, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO cart_items (user_id, item_slug, quantity)
+				SELECT $1, slug, $3 FROM merch_items WHERE slug = $2 AND active
+				ON CONFLICT (user_id, item_slug)
+				DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity, updated_at = now()
+				WHERE cart_items.quantity + EXCLUDED.quantity <= $4`,
+		userID,
+		item,
+		quantity,
+		maxLineQuantity,
+	)
+	if err != nil {
+		return Cart{}, fmt.Errorf("%v: unable to add item: %w", op, err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		var inCart bool
+		err = s.db.QueryRowContext(
+			ctx,
+			"SELECT EXISTS (SELECT 1 FROM cart_items WHERE user_id = $1 AND item_slug = $2)",
+			userID,
+			item,
+		).Scan(&inCart)
+		if err != nil {
+			return Cart{}, fmt.Errorf("%v: unable to read cart: %w", op, err)
+		}
+		if inCart {
+			return Cart{}, ErrQuantityTooLarge
+		}
+		return Cart{}, merch.ErrItemNotFound
+	}
+
+	return s.GetCart(ctx, userID)
+}
+
+// RemoveItem Удаление позиции из корзины
+func (s *service) RemoveItem(ctx context.Context, userID int, item string) (Cart, error) {
+	const op = "cart/service/RemoveItem"
+	res, err := s.db.ExecContext(
+		ctx,
+		"DELETE FROM cart_items WHERE user_id = $1 AND item_slug = $2",
+		userID,
+		item,
+	)
+	if err != nil {
+		return Cart{}, fmt.Errorf("%v: unable to remove item: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Cart{}, merch.ErrItemNotFound
+	}
+
+	return s.GetCart(ctx, userID)
+}
+
+// Checkout Оформление заказа. Все позиции покупаются вместе или не покупается ничего
+func (s *service) Checkout(ctx context.Context, userID int) (Order, error) {
+	const op = "cart/service/Checkout"
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	// Блокировка пользователя сериализует оформление заказов и изменение корзины при оплате
+	var coins int
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT coins FROM users WHERE id = $1 FOR UPDATE`,
+		userID,
+	).Scan(&coins)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: unable to read balance: %w", op, err)
+	}
+
+	lines, err := readCart(ctx, tx, userID)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: %w", op, err)
+	}
+	if len(lines) == 0 {
+		return Order{}, ErrCartEmpty
+	}
+
+	// Запас резервируется в порядке slug: параллельные заказы блокируют товары в одном порядке
+	order := Order{Items: make([]OrderLine, 0, len(lines))}
+	for _, l := range lines {
+		if !l.active {
+			return Order{}, &UnavailableError{Item: l.item, Err: merch.ErrItemNotFound}
+		}
+
+		price := l.price
+		if l.limited {
+			price, err = merch.ReserveStock(ctx, tx, l.item, l.quantity)
+			if err != nil {
+				if errors.Is(err, merch.ErrSoldOut) {
+					return Order{}, &UnavailableError{Item: l.item, Err: merch.ErrSoldOut}
+				}
+				return Order{}, fmt.Errorf("%v: %w", op, err)
+			}
+		}
+
+		order.Total += price * l.quantity
+		order.Items = append(order.Items, OrderLine{Item: l.item, Quantity: l.quantity, Price: price})
+	}
+
+	if coins < order.Total {
+		return Order{}, ErrInsufficientCoins
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE users SET coins = coins - $1 WHERE id = $2`,
+		order.Total,
+		userID,
+	)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: debit error: %w", op, err)
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		`INSERT INTO orders (user_id, total) VALUES ($1, $2) RETURNING id`,
+		userID,
+		order.Total,
+	).Scan(&order.ID)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: unable to create order: %w", op, err)
+	}
+
+	slugs := make([]string, 0, len(order.Items))
+	quantities := make([]int64, 0, len(order.Items))
+	prices := make([]int64, 0, len(order.Items))
+	amounts := make([]int64, 0, len(order.Items))
+	for _, l := range order.Items {
+		slugs = append(slugs, l.Item)
+		quantities = append(quantities, int64(l.Quantity))
+		prices = append(prices, int64(l.Price))
+		amounts = append(amounts, int64(l.Price*l.Quantity))
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO order_items (order_id, item_slug, quantity, price)
+				SELECT $1, unnest($2::text[]), unnest($3::int[]), unnest($4::int[])`,
+		order.ID,
+		pq.Array(slugs),
+		pq.Array(quantities),
+		pq.Array(prices),
+	)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: unable to save order items: %w", op, err)
+	}
+
+	entryID, err := ledger.Post(
+		ctx,
+		tx,
+		ledger.EntryPurchase,
+		fmt.Sprintf("order %d", order.ID),
+		ledger.User(userID, -order.Total),
+		ledger.System(ledger.AccountShopRevenue, order.Total),
+	)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: ledger error: %w", op, err)
+	}
+
+	// Одна запись purchased на позицию: amount - стоимость всей позиции
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO transactions (user_id, type, merch, amount, quantity, order_id, entry_id)
+				SELECT $1, $2, unnest($3::text[]), unnest($4::int[]), unnest($5::int[]), $6, $7`,
+		userID,
+		models.TransactionPurchased,
+		pq.Array(slugs),
+		pq.Array(amounts),
+		pq.Array(quantities),
+		order.ID,
+		entryID,
+	)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: transaction error: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO inventory (user_id, item_type, quantity)
+				SELECT $1, unnest($2::text[]), unnest($3::int[])
+				ON CONFLICT (user_id, item_type)
+				DO UPDATE SET quantity = inventory.quantity + EXCLUDED.quantity, updated_at = now()`,
+		userID,
+		pq.Array(slugs),
+		pq.Array(quantities),
+	)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: unable to update inventory: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM cart_items WHERE user_id = $1", userID)
+	if err != nil {
+		return Order{}, fmt.Errorf("%v: unable to clear cart: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Order{}, fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+	return order, nil
+}
+
+type cartLine struct {
+	item     string
+	quantity int
+	price    int
+	active   bool
+	limited  bool
+}
+
+func readCart(ctx context.Context, tx *sql.Tx, userID int) ([]cartLine, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT c.item_slug, c.quantity, m.price, m.active, m.stock IS NOT NULL
+				FROM cart_items c
+				JOIN merch_items m ON m.slug = c.item_slug
+				WHERE c.user_id = $1
+				ORDER BY c.item_slug`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cart: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		lines []cartLine
+		slugs []string
+	)
+	for rows.Next() {
+		var l cartLine
+		if err := rows.Scan(&l.item, &l.quantity, &l.price, &l.active, &l.limited); err != nil {
+			return nil, fmt.Errorf("unable to read cart: %w", err)
+		}
+		lines = append(lines, l)
+		slugs = append(slugs, l.item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read cart: %w", err)
+	}
+	rows.Close()
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	// Цена товаров без ограничения запаса фиксируется до конца транзакции (FOR SHARE), как в
+	// merch.BuyItem, чтобы параллельное изменение цены не повлияло на заказ. Блокировки берутся
+	// в порядке slug. Товары с запасом так не блокируются: их строки блокирует merch.ReserveStock
+	locked, err := tx.QueryContext(
+		ctx,
+		`SELECT slug, price, active FROM merch_items
+				WHERE slug = ANY($1) AND stock IS NULL
+				ORDER BY slug
+				FOR SHARE`,
+		pq.Array(slugs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock items: %w", err)
+	}
+	defer locked.Close()
+
+	unlimited := make(map[string]cartLine, len(lines))
+	for locked.Next() {
+		var l cartLine
+		if err := locked.Scan(&l.item, &l.price, &l.active); err != nil {
+			return nil, fmt.Errorf("unable to lock items: %w", err)
+		}
+		unlimited[l.item] = l
+	}
+	if err := locked.Err(); err != nil {
+		return nil, fmt.Errorf("unable to lock items: %w", err)
+	}
+
+	// После блокировки действуют прочитанные под ней цена и статус; товар, которому за это время
+	// задали запас, покупается через ReserveStock
+	for i, l := range lines {
+		if u, ok := unlimited[l.item]; ok {
+			lines[i].price, lines[i].active, lines[i].limited = u.price, u.active, false
+		} else {
+			lines[i].limited = true
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,221 @@
+package coin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"Avito-trainee/internal/db"
+	"Avito-trainee/internal/ledger"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNoRecipients       = errors.New("no recipients specified")
+	ErrTooManyRecipients  = errors.New("too many recipients in one request")
+	ErrDuplicateRecipient = errors.New("recipient is listed more than once")
+)
+
+const maxBatchRecipients = 100
+
+// Transfer Один перевод в пакете
+type Transfer struct {
+	ToUser string `json:"toUser"`
+	Amount int    `json:"amount"`
+}
+
+// RecipientsNotFoundError Часть получателей не существует, ни один перевод не выполнен
+type RecipientsNotFoundError struct {
+	Usernames []string
+}
+
+func (e *RecipientsNotFoundError) Error() string {
+	return "recipients not found: " + strings.Join(e.Usernames, ", ")
+}
+
+type batchAccount struct {
+	id       int
+	username string
+	coins    int
+}
+
+// SendCoinBatch Переводы нескольким получателям в одной транзакции: выполняются все или ни один.
+// Отправитель и получатели блокируются одним запросом в порядке id, поэтому параллельные
+// пакеты с пересекающимися участниками не взаимоблокируются
+func (s *service) SendCoinBatch(ctx context.Context, fromUserID int, transfers []Transfer, memo Memo) error {
+	transfers, err := normalizeTransfers(transfers)
+	if err != nil {
+		return err
+	}
+	memo, err = memo.Normalize()
+	if err != nil {
+		return err
+	}
+
+	return db.Retry(ctx, func() error {
+		return s.sendCoinBatch(ctx, fromUserID, transfers, memo)
+	})
+}
+
+func (s *service) sendCoinBatch(ctx context.Context, fromUserID int, transfers []Transfer, memo Memo) error {
+	const op = "coin/service/SendCoinBatch"
+
+	usernames := make([]string, 0, len(transfers))
+	total := 0
+	for _, t := range transfers {
+		usernames = append(usernames, t.ToUser)
+		total += t.Amount
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id, username, coins FROM users WHERE id = $1 OR username = ANY($2) ORDER BY id FOR UPDATE",
+		fromUserID,
+		pq.Array(usernames),
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to lock users: %w", op, err)
+	}
+	var (
+		sender     *batchAccount
+		recipients = make(map[string]batchAccount, len(transfers))
+	)
+	for rows.Next() {
+		var a batchAccount
+		if err := rows.Scan(&a.id, &a.username, &a.coins); err != nil {
+			rows.Close()
+			return fmt.Errorf("%v: unable to read users: %w", op, err)
+		}
+		if a.id == fromUserID {
+			sender = &a
+		}
+		recipients[a.username] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%v: unable to read users: %w", op, err)
+	}
+	if sender == nil {
+		return fmt.Errorf("%v: user id not found: %v", op, fromUserID)
+	}
+
+	missing := &RecipientsNotFoundError{}
+	for _, t := range transfers {
+		if t.ToUser == sender.username {
+			return ErrSameUser
+		}
+		if _, ok := recipients[t.ToUser]; !ok {
+			missing.Usernames = append(missing.Usernames, t.ToUser)
+		}
+	}
+	if len(missing.Usernames) > 0 {
+		return missing
+	}
+
+	if err := s.checkPolicies(ctx, tx, Sender{ID: sender.id, Username: sender.username}, transfers); err != nil {
+		return err
+	}
+
+	if sender.coins < total {
+		return ErrInsufficientFunds
+	}
+
+	ids := make([]int64, 0, len(transfers))
+	amounts := make([]int64, 0, len(transfers))
+	postings := make([]ledger.Posting, 0, len(transfers)+1)
+	postings = append(postings, ledger.User(sender.id, -total))
+	for _, t := range transfers {
+		id := recipients[t.ToUser].id
+		ids = append(ids, int64(id))
+		amounts = append(amounts, int64(t.Amount))
+		postings = append(postings, ledger.User(id, t.Amount))
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE users SET coins = coins - $1 WHERE id = $2",
+		total,
+		sender.id,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: debit error: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE users SET coins = users.coins + p.amount
+				FROM unnest($1::int[], $2::int[]) AS p(id, amount)
+				WHERE users.id = p.id`,
+		pq.Array(ids),
+		pq.Array(amounts),
+	)
+	if err != nil {
+		return fmt.Errorf("%v: credit error: %w", op, err)
+	}
+
+	entryID, err := ledger.Post(ctx, tx, ledger.EntryTransfer, "", postings...)
+	if err != nil {
+		return fmt.Errorf("%v: ledger error: %w", op, err)
+	}
+
+	// Пары записей sent/received на каждого получателя с общей записью журнала
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO transactions (user_id, type, counterparty, amount, entry_id, message, tags)
+				SELECT $1, 'sent', p.username, p.amount, $4, $5, $6
+				FROM unnest($2::text[], $3::int[]) AS p(username, amount)
+				UNION ALL
+				SELECT u.id, 'received', $7, p.amount, $4, $5, $6
+				FROM unnest($2::text[], $3::int[]) AS p(username, amount)
+				JOIN users u ON u.username = p.username`,
+		sender.id,
+		pq.Array(usernames),
+		pq.Array(amounts),
+		entryID,
+		sql.NullString{String: memo.Message, Valid: memo.Message != ""},
+		pq.Array(memo.Tags),
+		sender.username,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: transaction error: %w", op, err)
+	}
+
+	return tx.Commit()
+}
+
+// normalizeTransfers Проверка пакета: суммы положительны, каждый получатель указан один раз
+func normalizeTransfers(transfers []Transfer) ([]Transfer, error) {
+	if len(transfers) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if len(transfers) > maxBatchRecipients {
+		return nil, ErrTooManyRecipients
+	}
+
+	seen := make(map[string]bool, len(transfers))
+	result := make([]Transfer, 0, len(transfers))
+	for _, t := range transfers {
+		t.ToUser = strings.TrimSpace(t.ToUser)
+		if t.ToUser == "" {
+			return nil, ErrNoRecipients
+		}
+		if err := checkAmount(t.Amount); err != nil {
+			return nil, err
+		}
+		if seen[t.ToUser] {
+			return nil, ErrDuplicateRecipient
+		}
+		seen[t.ToUser] = true
+		result = append(result, t)
+	}
+	return result, nil
+}
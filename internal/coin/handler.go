@@ -2,12 +2,18 @@ package coin
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"Avito-trainee/internal/db"
 )
 
 type SendCoinRequest struct {
 	ToUser string `json:"ToUser"`
 	Amount int    `json:"amount"`
+	// Необязательные сообщение (до 200 символов) и теги перевода
+	Message string   `json:"message,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
 }
 
 func MakeSendCoinHandler(s Service) http.HandlerFunc {
@@ -19,13 +25,70 @@ func MakeSendCoinHandler(s Service) http.HandlerFunc {
 		}
 
 		userID := r.Context().Value("userID").(int)
-		err := s.SendCoin(r.Context(), userID, req.ToUser, req.Amount)
+		err := s.SendCoin(r.Context(), userID, req.ToUser, req.Amount, Memo{Message: req.Message, Tags: req.Tags})
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInsufficientFunds):
+				http.Error(w, "Not enough coins", http.StatusBadRequest)
+			case errors.Is(err, ErrSameUser):
+				http.Error(w, "Unable to send coins to yourself", http.StatusBadRequest)
+			case errors.Is(err, ErrUserNotFound):
+				http.Error(w, "User not found", http.StatusBadRequest)
+			case errors.Is(err, ErrPolicyViolation):
+				WritePolicyError(w, err)
+			case errors.Is(err, ErrMessageTooLong),
+				errors.Is(err, ErrInvalidTag),
+				errors.Is(err, ErrTooManyTags):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, db.ErrBusy):
+				WriteBusy(w)
+			default:
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Coins sent successfully"))
+	}
+}
+
+type SendCoinBatchRequest struct {
+	Transfers []Transfer `json:"transfers"`
+	Message   string     `json:"message,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+}
+
+func MakeSendCoinBatchHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SendCoinBatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		err := s.SendCoinBatch(r.Context(), userID, req.Transfers, Memo{Message: req.Message, Tags: req.Tags})
 		if err != nil {
-			switch err {
-			case ErrInsufficientFunds:
+			var notFound *RecipientsNotFoundError
+			switch {
+			case errors.As(err, &notFound):
+				http.Error(w, notFound.Error(), http.StatusBadRequest)
+			case errors.Is(err, ErrInsufficientFunds):
 				http.Error(w, "Not enough coins", http.StatusBadRequest)
-			case ErrSameUser:
+			case errors.Is(err, ErrSameUser):
 				http.Error(w, "Unable to send coins to yourself", http.StatusBadRequest)
+			case errors.Is(err, ErrPolicyViolation):
+				WritePolicyError(w, err)
+			case errors.Is(err, ErrNoRecipients),
+				errors.Is(err, ErrTooManyRecipients),
+				errors.Is(err, ErrDuplicateRecipient),
+				errors.Is(err, ErrMessageTooLong),
+				errors.Is(err, ErrInvalidTag),
+				errors.Is(err, ErrTooManyTags):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, db.ErrBusy):
+				WriteBusy(w)
 			default:
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
@@ -36,3 +99,28 @@ func MakeSendCoinHandler(s Service) http.HandlerFunc {
 		w.Write([]byte("Coins sent successfully"))
 	}
 }
+
+// WriteBusy Конфликт с параллельными переводами не разрешился повторами (503 с Retry-After)
+func WriteBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Service is busy, try again later", http.StatusServiceUnavailable)
+}
+
+// WritePolicyError Нарушение политики в JSON с кодом правила: 400 для ограничений
+// на сумму перевода, 422 для лимитов и запретов
+func WritePolicyError(w http.ResponseWriter, err error) {
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusUnprocessableEntity
+	if policyErr.Code == CodeBelowMinimum || policyErr.Code == CodeAboveMaximum {
+		status = http.StatusBadRequest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(policyErr)
+}
@@ -0,0 +1,75 @@
+package coin
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrMessageTooLong = errors.New("message is too long")
+	ErrInvalidTag     = errors.New("invalid tag")
+	ErrTooManyTags    = errors.New("too many tags")
+)
+
+const (
+	maxMessageLength = 200
+	maxTags          = 5
+)
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,30}$`)
+
+// Memo Необязательное сообщение и теги перевода, например благодарность коллеге
+type Memo struct {
+	Message string
+	Tags    []string
+}
+
+// Normalize Очистка сообщения и тегов. Из сообщения удаляются управляющие и невидимые символы,
+// пробелы схлопываются. Теги приводятся к нижнему регистру без ведущего '#', повторы удаляются
+func (m Memo) Normalize() (Memo, error) {
+	message := sanitizeMessage(m.Message)
+	if len([]rune(message)) > maxMessageLength {
+		return Memo{}, ErrMessageTooLong
+	}
+
+	tags := make([]string, 0, len(m.Tags))
+	seen := make(map[string]bool, len(m.Tags))
+	for _, tag := range m.Tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if !tagPattern.MatchString(tag) {
+			return Memo{}, ErrInvalidTag
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTags {
+		return Memo{}, ErrTooManyTags
+	}
+
+	return Memo{Message: message, Tags: tags}, nil
+}
+
+func sanitizeMessage(message string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range message {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), r == unicode.ReplacementChar:
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
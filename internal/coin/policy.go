@@ -0,0 +1,180 @@
+package coin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ErrPolicyViolation Перевод запрещен политикой; конкретное правило - в PolicyError.Code
+var ErrPolicyViolation = errors.New("transfer violates policy")
+
+// Коды нарушений политики переводов
+const (
+	CodeBelowMinimum        = "amount_below_minimum"
+	CodeAboveMaximum        = "amount_above_maximum"
+	CodeDailyLimit          = "daily_limit_exceeded"
+	CodeWeeklyLimit         = "weekly_limit_exceeded"
+	CodeRecipientDailyLimit = "recipient_daily_limit_exceeded"
+	CodeBlockedPair         = "transfer_blocked"
+)
+
+// PolicyError Нарушение политики переводов с машиночитаемым кодом
+type PolicyError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrPolicyViolation, e.Message)
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrPolicyViolation
+}
+
+// checkAmount Сумма перевода не меньше 1 при любых настройках политики. Проверяется до обращения
+// к базе, но возвращается тем же PolicyError, что и настраиваемый минимум
+func checkAmount(amount int) error {
+	if amount <= 0 {
+		return &PolicyError{Code: CodeBelowMinimum, Message: "transfer amount must be positive"}
+	}
+	return nil
+}
+
+// Policy Правило, которое проверяется внутри транзакции перевода после блокировки
+// строки отправителя, поэтому параллельные переводы не могут обойти лимиты.
+// transfers содержит один перевод для SendCoin и весь пакет для SendCoinBatch
+type Policy interface {
+	Check(ctx context.Context, tx *sql.Tx, sender Sender, transfers []Transfer) error
+}
+
+// Sender Отправитель перевода
+type Sender struct {
+	ID       int
+	Username string
+}
+
+// PolicyConfig Настройки стандартной политики; нулевое значение лимита означает его отсутствие.
+// Дневные и недельные лимиты считаются по скользящему окну 24 часа и 7 дней.
+// BlockedPairs - пары (отправитель, получатель), "*" подходит для любого имени
+type PolicyConfig struct {
+	MinAmount           int
+	MaxAmount           int
+	DailyLimit          int
+	WeeklyLimit         int
+	RecipientDailyLimit int
+	BlockedPairs        [][2]string
+}
+
+type limitsPolicy struct {
+	cfg PolicyConfig
+}
+
+// NewLimitsPolicy Стандартная политика: минимальная и максимальная сумма, лимиты исходящих
+// переводов за сутки и неделю, лимит на одного получателя за сутки и запрещенные пары
+func NewLimitsPolicy(cfg PolicyConfig) Policy {
+	return &limitsPolicy{cfg: cfg}
+}
+
+func (p *limitsPolicy) Check(ctx context.Context, tx *sql.Tx, sender Sender, transfers []Transfer) error {
+	total := 0
+	for _, t := range transfers {
+		if p.blocked(sender.Username, t.ToUser) {
+			return &PolicyError{Code: CodeBlockedPair, Message: fmt.Sprintf("transfers to %s are not allowed", t.ToUser)}
+		}
+		if p.cfg.MinAmount > 0 && t.Amount < p.cfg.MinAmount {
+			return &PolicyError{Code: CodeBelowMinimum, Message: fmt.Sprintf("minimum transfer amount is %d", p.cfg.MinAmount)}
+		}
+		if p.cfg.MaxAmount > 0 && t.Amount > p.cfg.MaxAmount {
+			return &PolicyError{Code: CodeAboveMaximum, Message: fmt.Sprintf("maximum transfer amount is %d", p.cfg.MaxAmount)}
+		}
+		total += t.Amount
+	}
+
+	if p.cfg.DailyLimit > 0 || p.cfg.WeeklyLimit > 0 {
+		var daily, weekly int
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT COALESCE(SUM(amount) FILTER (WHERE created_at > now() - interval '1 day'), 0),
+					COALESCE(SUM(amount), 0)
+					FROM transactions
+					WHERE user_id = $1 AND type = 'sent' AND created_at > now() - interval '7 days'`,
+			sender.ID,
+		).Scan(&daily, &weekly)
+		if err != nil {
+			return fmt.Errorf("unable to get outgoing totals: %w", err)
+		}
+		if p.cfg.DailyLimit > 0 && daily+total > p.cfg.DailyLimit {
+			return &PolicyError{Code: CodeDailyLimit, Message: fmt.Sprintf("daily transfer limit is %d, already sent %d", p.cfg.DailyLimit, daily)}
+		}
+		if p.cfg.WeeklyLimit > 0 && weekly+total > p.cfg.WeeklyLimit {
+			return &PolicyError{Code: CodeWeeklyLimit, Message: fmt.Sprintf("weekly transfer limit is %d, already sent %d", p.cfg.WeeklyLimit, weekly)}
+		}
+	}
+
+	if p.cfg.RecipientDailyLimit > 0 {
+		if err := p.checkRecipients(ctx, tx, sender, transfers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *limitsPolicy) checkRecipients(ctx context.Context, tx *sql.Tx, sender Sender, transfers []Transfer) error {
+	usernames := make([]string, 0, len(transfers))
+	for _, t := range transfers {
+		usernames = append(usernames, t.ToUser)
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT counterparty, SUM(amount) FROM transactions
+				WHERE user_id = $1 AND type = 'sent' AND counterparty = ANY($2)
+				AND created_at > now() - interval '1 day'
+				GROUP BY counterparty`,
+		sender.ID,
+		pq.Array(usernames),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to get recipient totals: %w", err)
+	}
+	defer rows.Close()
+
+	sent := make(map[string]int, len(transfers))
+	for rows.Next() {
+		var (
+			username string
+			amount   int
+		)
+		if err := rows.Scan(&username, &amount); err != nil {
+			return fmt.Errorf("unable to read recipient totals: %w", err)
+		}
+		sent[username] = amount
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("unable to read recipient totals: %w", err)
+	}
+
+	for _, t := range transfers {
+		if sent[t.ToUser]+t.Amount > p.cfg.RecipientDailyLimit {
+			return &PolicyError{
+				Code:    CodeRecipientDailyLimit,
+				Message: fmt.Sprintf("daily limit for transfers to %s is %d, already sent %d", t.ToUser, p.cfg.RecipientDailyLimit, sent[t.ToUser]),
+			}
+		}
+	}
+	return nil
+}
+
+func (p *limitsPolicy) blocked(from, to string) bool {
+	for _, pair := range p.cfg.BlockedPairs {
+		if (pair[0] == "*" || pair[0] == from) && (pair[1] == "*" || pair[1] == to) {
+			return true
+		}
+	}
+	return false
+}
@@ -5,26 +5,65 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+
+	"Avito-trainee/internal/db"
+	"Avito-trainee/internal/ledger"
+
+	"github.com/lib/pq"
 )
 
 var (
 	ErrInsufficientFunds = errors.New("not enough coins")
 	ErrSameUser          = errors.New("unable to send coins to yourself")
+	ErrUserNotFound      = errors.New("user not found")
 )
 
 type Service interface {
-	SendCoin(ctx context.Context, fromUserID int, toUsername string, amount int) error
+	SendCoin(ctx context.Context, fromUserID int, toUsername string, amount int, memo Memo) error
+	// SendCoinTx Перевод внутри транзакции вызывающего, чтобы он зафиксировался вместе с его изменениями.
+	// Транзакция не фиксируется и не повторяется: это делает вызывающий, например через db.Retry
+	SendCoinTx(ctx context.Context, tx *sql.Tx, fromUserID int, toUsername string, amount int, memo Memo) error
+	SendCoinBatch(ctx context.Context, fromUserID int, transfers []Transfer, memo Memo) error
 }
 
 type service struct {
-	db *sql.DB
+	db       *sql.DB
+	policies []Policy
+}
+
+// NewCoinService Сервис переводов; policies проверяются по порядку для каждого перевода
+func NewCoinService(db *sql.DB, policies ...Policy) Service {
+	return &service{db: db, policies: policies}
 }
 
-func NewCoinService(db *sql.DB) Service {
-	return &service{db: db}
+// SendCoin Перевод монет. При взаимоблокировке или ошибке сериализации транзакция повторяется,
+// после исчерпания попыток возвращается ошибка, совпадающая с db.ErrBusy
+func (s *service) SendCoin(ctx context.Context, fromUserID int, toUsername string, amount int, memo Memo) error {
+	if err := checkAmount(amount); err != nil {
+		return err
+	}
+	memo, err := memo.Normalize()
+	if err != nil {
+		return err
+	}
+
+	return db.Retry(ctx, func() error {
+		return s.sendCoin(ctx, fromUserID, toUsername, amount, memo)
+	})
 }
 
-func (s *service) SendCoin(ctx context.Context, fromUserID int, toUsername string, amount int) error {
+func (s *service) SendCoinTx(ctx context.Context, tx *sql.Tx, fromUserID int, toUsername string, amount int, memo Memo) error {
+	if err := checkAmount(amount); err != nil {
+		return err
+	}
+	memo, err := memo.Normalize()
+	if err != nil {
+		return err
+	}
+	return s.transfer(ctx, tx, fromUserID, toUsername, amount, memo)
+}
+
+func (s *service) sendCoin(ctx context.Context, fromUserID int, toUsername string, amount int, memo Memo) error {
 	const op = "coin/service/SendCoin"
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -32,15 +71,24 @@ func (s *service) SendCoin(ctx context.Context, fromUserID int, toUsername strin
 	}
 	defer tx.Rollback()
 
+	if err := s.transfer(ctx, tx, fromUserID, toUsername, amount, memo); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// transfer Списание, зачисление, проводка и записи истории перевода в транзакции tx
+func (s *service) transfer(ctx context.Context, tx *sql.Tx, fromUserID int, toUsername string, amount int, memo Memo) error {
+	const op = "coin/service/SendCoin"
 	var toUserID int
-	err = tx.QueryRowContext(
+	err := tx.QueryRowContext(
 		ctx,
 		"SELECT id FROM users WHERE username = $1",
 		toUsername,
 	).Scan(&toUserID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("%v: user not found: %s", op, toUsername)
+			return fmt.Errorf("%v: %w: %s", op, ErrUserNotFound, toUsername)
 		}
 		return fmt.Errorf("%v: unable to find user: %w", op, err)
 	}
@@ -62,14 +110,16 @@ func (s *service) SendCoin(ctx context.Context, fromUserID int, toUsername strin
 		return ErrSameUser
 	}
 
-	var currentBalance int
-	err = tx.QueryRowContext(
-		ctx,
-		"SELECT coins FROM users WHERE id = $1 FOR UPDATE ",
-		fromUserID,
-	).Scan(&currentBalance)
+	// Обе строки блокируются в порядке id: встречные переводы A->B и B->A
+	// берут блокировки в одном порядке и не взаимоблокируются
+	currentBalance, err := lockPair(ctx, tx, fromUserID, toUserID)
 	if err != nil {
-		return fmt.Errorf("%v: unable to check balance: %w", op, err)
+		return fmt.Errorf("%v: %w", op, err)
+	}
+
+	sender := Sender{ID: fromUserID, Username: fromUsername}
+	if err := s.checkPolicies(ctx, tx, sender, []Transfer{{ToUser: toUsername, Amount: amount}}); err != nil {
+		return err
 	}
 
 	if currentBalance < amount {
@@ -96,20 +146,79 @@ func (s *service) SendCoin(ctx context.Context, fromUserID int, toUsername strin
 		return fmt.Errorf("%v: credit error: %w", op, err)
 	}
 
+	entryID, err := ledger.Post(
+		ctx,
+		tx,
+		ledger.EntryTransfer,
+		"",
+		ledger.User(fromUserID, -amount),
+		ledger.User(toUserID, amount),
+	)
+	if err != nil {
+		return fmt.Errorf("%v: ledger error: %w", op, err)
+	}
+
 	_, err = tx.ExecContext(
 		ctx,
-		`INSERT INTO transactions (user_id, type, counterparty, amount) 
-				VALUES ($1, 'sent', $2, $3), ($4, 'received', $5, $6)`,
+		`INSERT INTO transactions (user_id, type, counterparty, amount, entry_id, message, tags) 
+				VALUES ($1, 'sent', $2, $3, $7, $8, $9), ($4, 'received', $5, $6, $7, $8, $9)`,
 		fromUserID,
 		toUsername,
 		amount,
 		toUserID,
 		fromUsername,
 		amount,
+		entryID,
+		sql.NullString{String: memo.Message, Valid: memo.Message != ""},
+		pq.Array(memo.Tags),
 	)
 	if err != nil {
 		return fmt.Errorf("%v: transaction error: %w", op, err)
 	}
+	return nil
+}
 
-	return tx.Commit()
+// lockPair Блокировка отправителя и получателя в порядке id. Возвращает баланс отправителя
+func lockPair(ctx context.Context, tx *sql.Tx, fromUserID, toUserID int) (int, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id, coins FROM users WHERE id = ANY($1) ORDER BY id FOR UPDATE",
+		pq.Array([]int64{int64(fromUserID), int64(toUserID)}),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("unable to lock users: %w", err)
+	}
+	defer rows.Close()
+
+	balance, found := 0, false
+	for rows.Next() {
+		var id, coins int
+		if err := rows.Scan(&id, &coins); err != nil {
+			return 0, fmt.Errorf("unable to check balance: %w", err)
+		}
+		if id == fromUserID {
+			balance, found = coins, true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("unable to check balance: %w", err)
+	}
+	if !found {
+		return 0, fmt.Errorf("user id not found: %v", fromUserID)
+	}
+	return balance, nil
+}
+
+// checkPolicies Проверка перевода всеми политиками; нарушение возвращается как есть (*PolicyError)
+func (s *service) checkPolicies(ctx context.Context, tx *sql.Tx, sender Sender, transfers []Transfer) error {
+	for _, p := range s.policies {
+		if err := p.Check(ctx, tx, sender, transfers); err != nil {
+			var policyErr *PolicyError
+			if errors.As(err, &policyErr) {
+				return err
+			}
+			return fmt.Errorf("policy error: %w", err)
+		}
+	}
+	return nil
 }
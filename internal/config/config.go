@@ -2,10 +2,13 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,7 +18,53 @@ type Config struct {
 	Port        string
 	DatabaseURL string
 	JWTSecret   string
+	JWTKeysDir  string
+	JWTKeyID    string
 	ReadTimeout int
+	// Автоматическая регистрация при первом входе с неизвестным именем
+	AutoRegister bool
+
+	// Защита от перебора паролей
+	LoginMaxAttempts   int
+	LoginIPMaxAttempts int
+	LoginLockoutBase   time.Duration
+	LoginLockoutMax    time.Duration
+	LoginAttemptWindow time.Duration
+
+	// Время хранения ответов для повторов с Idempotency-Key
+	IdempotencyTTL time.Duration
+
+	// Периодическая сверка балансов (0 - выключена) и автоисправление расхождений
+	ReconcileInterval time.Duration
+	ReconcileFix      bool
+
+	// Политика переводов: 0 - ограничение не действует
+	TransferMinAmount           int
+	TransferMaxAmount           int
+	TransferDailyLimit          int
+	TransferWeeklyLimit         int
+	TransferRecipientDailyLimit int
+	// Запрещенные пары отправитель:получатель, "*" - любое имя
+	TransferBlockedPairs [][2]string
+
+	// Срок действия запроса на оплату
+	InvoiceTTL time.Duration
+
+	// Период запуска отложенных переводов (0 - выключен)
+	SchedulerInterval time.Duration
+
+	// Срок удержания по умолчанию и период возврата истекших удержаний (0 - выключен)
+	HoldTTL            time.Duration
+	HoldExpiryInterval time.Duration
+
+	// Период обновления агрегатов рейтинга (0 - выключено)
+	LeaderboardRefreshInterval time.Duration
+
+	// Срок хранения событий для повторной отправки после переподключения (0 - не удалять)
+	EventsRetention time.Duration
+
+	// Задержка выдачи событий из-за долгой транзакции, после которой она пишется в лог (0 - не проверять)
+	EventsMaxDelay time.Duration
 }
 
 func LoadConfig() (*Config, error) {
@@ -58,12 +107,16 @@ func LoadConfig() (*Config, error) {
 	}
 	conf.DatabaseURL = dURL
 
-	// Секретный ключ для JWT
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return nil, errors.New("JWT_SECRET is not set")
+	// Ключи для JWT: директория с RSA/Ed25519 ключами или, для обратной совместимости, общий секрет
+	conf.JWTKeysDir = os.Getenv("JWT_KEYS_DIR")
+	conf.JWTKeyID = os.Getenv("JWT_ACTIVE_KID")
+	conf.JWTSecret = os.Getenv("JWT_SECRET")
+	if conf.JWTKeysDir != "" && conf.JWTKeyID == "" {
+		return nil, errors.New("JWT_ACTIVE_KID is not set")
+	}
+	if conf.JWTKeysDir == "" && conf.JWTSecret == "" {
+		return nil, errors.New("neither JWT_KEYS_DIR nor JWT_SECRET is set")
 	}
-	conf.JWTSecret = jwtSecret
 
 	// Таймаут чтения запросов
 	if readTimeoutStr := os.Getenv("READ_TIMEOUT"); readTimeoutStr != "" {
@@ -76,5 +129,139 @@ func LoadConfig() (*Config, error) {
 		conf.ReadTimeout = 5 // По умолчанию 5 секунд
 	}
 
+	// Режим автоматической регистрации (по умолчанию выключен)
+	if autoRegisterStr := os.Getenv("AUTO_REGISTER"); autoRegisterStr != "" {
+		autoRegister, err := strconv.ParseBool(autoRegisterStr)
+		if err != nil {
+			return nil, errors.New("invalid AUTO_REGISTER value")
+		}
+		conf.AutoRegister = autoRegister
+	}
+
+	// Блокировка входа после серии неудачных попыток
+	if conf.LoginMaxAttempts, err = getInt("LOGIN_MAX_ATTEMPTS", 5); err != nil {
+		return nil, err
+	}
+	if conf.LoginIPMaxAttempts, err = getInt("LOGIN_IP_MAX_ATTEMPTS", 50); err != nil {
+		return nil, err
+	}
+	if conf.LoginLockoutBase, err = getDuration("LOGIN_LOCKOUT_BASE", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if conf.LoginLockoutMax, err = getDuration("LOGIN_LOCKOUT_MAX", time.Hour); err != nil {
+		return nil, err
+	}
+	if conf.LoginAttemptWindow, err = getDuration("LOGIN_ATTEMPT_WINDOW", time.Hour); err != nil {
+		return nil, err
+	}
+
+	if conf.IdempotencyTTL, err = getDuration("IDEMPOTENCY_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	// Сверка балансов с историей (по умолчанию выключена)
+	if conf.ReconcileInterval, err = getDuration("RECONCILE_INTERVAL", 0); err != nil {
+		return nil, err
+	}
+	if reconcileFixStr := os.Getenv("RECONCILE_FIX"); reconcileFixStr != "" {
+		reconcileFix, err := strconv.ParseBool(reconcileFixStr)
+		if err != nil {
+			return nil, errors.New("invalid RECONCILE_FIX value")
+		}
+		conf.ReconcileFix = reconcileFix
+	}
+
+	// Ограничения переводов монет
+	if conf.TransferMinAmount, err = getInt("TRANSFER_MIN_AMOUNT", 1); err != nil {
+		return nil, err
+	}
+	if conf.TransferMaxAmount, err = getInt("TRANSFER_MAX_AMOUNT", 0); err != nil {
+		return nil, err
+	}
+	if conf.TransferDailyLimit, err = getInt("TRANSFER_DAILY_LIMIT", 0); err != nil {
+		return nil, err
+	}
+	if conf.TransferWeeklyLimit, err = getInt("TRANSFER_WEEKLY_LIMIT", 0); err != nil {
+		return nil, err
+	}
+	if conf.TransferRecipientDailyLimit, err = getInt("TRANSFER_RECIPIENT_DAILY_LIMIT", 0); err != nil {
+		return nil, err
+	}
+	if conf.TransferBlockedPairs, err = getPairs("TRANSFER_BLOCKED_PAIRS"); err != nil {
+		return nil, err
+	}
+
+	if conf.InvoiceTTL, err = getDuration("INVOICE_TTL", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if conf.InvoiceTTL <= 0 {
+		return nil, errors.New("invalid INVOICE_TTL value")
+	}
+
+	if conf.SchedulerInterval, err = getDuration("SCHEDULER_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+
+	if conf.HoldTTL, err = getDuration("HOLD_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if conf.HoldExpiryInterval, err = getDuration("HOLD_EXPIRY_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+
+	if conf.LeaderboardRefreshInterval, err = getDuration("LEADERBOARD_REFRESH_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+
+	if conf.EventsRetention, err = getDuration("EVENTS_RETENTION", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if conf.EventsMaxDelay, err = getDuration("EVENTS_MAX_DELAY", time.Minute); err != nil {
+		return nil, err
+	}
+
 	return conf, nil
 }
+
+// getPairs Список пар через запятую в формате from:to (например, alice:bob,*:bob)
+func getPairs(name string) ([][2]string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, nil
+	}
+	var pairs [][2]string
+	for _, item := range strings.Split(value, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid %s value", name)
+		}
+		pairs = append(pairs, [2]string{from, to})
+	}
+	return pairs, nil
+}
+
+// getInt Целочисленный параметр окружения со значением по умолчанию
+func getInt(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value", name)
+	}
+	return n, nil
+}
+
+// getDuration Параметр окружения в формате time.ParseDuration (например, 30s, 15m)
+func getDuration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value", name)
+	}
+	return d, nil
+}
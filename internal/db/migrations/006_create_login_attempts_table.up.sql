@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    scope VARCHAR(10) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    locked_until timestamptz,
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (scope, key)
+);
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reason VARCHAR(255);
\ No newline at end of file
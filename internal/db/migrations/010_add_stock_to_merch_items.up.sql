@@ -0,0 +1,5 @@
+-- NULL означает неограниченный запас
+ALTER TABLE merch_items ADD COLUMN IF NOT EXISTS stock INTEGER;
+
+ALTER TABLE merch_items DROP CONSTRAINT IF EXISTS check_merch_stock;
+ALTER TABLE merch_items ADD CONSTRAINT check_merch_stock CHECK (stock >= 0);
\ No newline at end of file
@@ -0,0 +1,118 @@
+-- Счета двойной записи: пользовательские и системные (mint - эмиссия, shop_revenue - выручка магазина)
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER UNIQUE REFERENCES users(id),
+    code VARCHAR(50) UNIQUE,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT check_ledger_account_owner CHECK ((user_id IS NULL) <> (code IS NULL))
+);
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(30) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES journal_entries(id) ON DELETE CASCADE,
+    account_id INTEGER NOT NULL REFERENCES ledger_accounts(id),
+    amount INTEGER NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_postings_entry ON postings (entry_id);
+CREATE INDEX IF NOT EXISTS idx_postings_account ON postings (account_id);
+
+-- Сумма проводок каждой записи равна нулю; проверка откладывается до конца транзакции,
+-- чтобы проводки одной записи можно было вставлять по очереди
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced() RETURNS trigger AS $$
+DECLARE
+    v_entry_id BIGINT;
+    v_sum BIGINT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        v_entry_id := OLD.entry_id;
+    ELSE
+        v_entry_id := NEW.entry_id;
+    END IF;
+
+    SELECT COALESCE(SUM(amount), 0) INTO v_sum FROM postings WHERE entry_id = v_entry_id;
+    IF v_sum <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is unbalanced: postings sum to %', v_entry_id, v_sum;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS postings_balanced ON postings;
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT OR UPDATE OR DELETE ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_journal_entry_balanced();
+
+-- Системный счет по коду; создается при первом обращении
+CREATE OR REPLACE FUNCTION ledger_system_account(p_code VARCHAR) RETURNS INTEGER AS $$
+DECLARE
+    v_id INTEGER;
+BEGIN
+    SELECT id INTO v_id FROM ledger_accounts WHERE code = p_code;
+    IF v_id IS NULL THEN
+        INSERT INTO ledger_accounts (code) VALUES (p_code)
+        ON CONFLICT (code) DO NOTHING;
+        SELECT id INTO v_id FROM ledger_accounts WHERE code = p_code;
+    END IF;
+    RETURN v_id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Счет пользователя и начальный баланс как эмиссия из mint
+CREATE OR REPLACE FUNCTION open_user_ledger_account(p_user_id INTEGER, p_coins INTEGER) RETURNS VOID AS $$
+DECLARE
+    v_account_id INTEGER;
+    v_entry_id BIGINT;
+BEGIN
+    INSERT INTO ledger_accounts (user_id) VALUES (p_user_id)
+    ON CONFLICT (user_id) DO NOTHING
+    RETURNING id INTO v_account_id;
+
+    IF v_account_id IS NULL OR p_coins = 0 THEN
+        RETURN;
+    END IF;
+
+    INSERT INTO journal_entries (type, description) VALUES ('opening', 'opening balance')
+    RETURNING id INTO v_entry_id;
+    INSERT INTO postings (entry_id, account_id, amount) VALUES
+        (v_entry_id, ledger_system_account('mint'), -p_coins),
+        (v_entry_id, v_account_id, p_coins);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION users_open_ledger_account() RETURNS trigger AS $$
+BEGIN
+    PERFORM open_user_ledger_account(NEW.id, NEW.coins);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_ledger_account ON users;
+CREATE TRIGGER users_ledger_account
+    AFTER INSERT ON users
+    FOR EACH ROW EXECUTE FUNCTION users_open_ledger_account();
+
+SELECT ledger_system_account('mint');
+SELECT ledger_system_account('shop_revenue');
+
+-- Перенос текущих балансов существующих пользователей
+SELECT open_user_ledger_account(id, coins) FROM users ORDER BY id;
+
+-- Записи истории ссылаются на запись журнала; у перевода она общая для sent и received
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS entry_id BIGINT REFERENCES journal_entries(id);
+CREATE INDEX IF NOT EXISTS idx_transactions_entry ON transactions (entry_id);
+
+-- Балансы по проводкам
+CREATE OR REPLACE VIEW ledger_balances AS
+SELECT a.id AS account_id, a.user_id, a.code, COALESCE(SUM(p.amount), 0) AS balance
+FROM ledger_accounts a
+LEFT JOIN postings p ON p.account_id = a.id
+GROUP BY a.id;
\ No newline at end of file
@@ -0,0 +1,3 @@
+-- Сообщение и теги перевода; хранятся в обеих записях (sent и received)
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS message VARCHAR(200);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
\ No newline at end of file
@@ -0,0 +1,18 @@
+-- Запросы на оплату: requester просит payer перевести ему amount монет
+CREATE TABLE IF NOT EXISTS payment_requests (
+    id SERIAL PRIMARY KEY,
+    requester_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    payer_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    note VARCHAR(200) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled')),
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    resolved_at timestamptz,
+    CHECK (requester_id <> payer_id)
+);
+
+-- Просроченный запрос остается pending в таблице, статус expired вычисляется при чтении
+CREATE INDEX IF NOT EXISTS idx_payment_requests_payer ON payment_requests (payer_id, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_payment_requests_requester ON payment_requests (requester_id, id);
\ No newline at end of file
@@ -0,0 +1,32 @@
+-- Отложенные и повторяющиеся переводы
+CREATE TABLE IF NOT EXISTS scheduled_transfers (
+    id SERIAL PRIMARY KEY,
+    owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    message VARCHAR(200) NOT NULL DEFAULT '',
+    repeat VARCHAR(10) NOT NULL DEFAULT 'once'
+        CHECK (repeat IN ('once', 'daily', 'weekly', 'monthly')),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at timestamptz NOT NULL,
+    last_run_at timestamptz,
+    last_status VARCHAR(16),
+    last_error TEXT,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CHECK (owner_id <> recipient_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_due ON scheduled_transfers (next_run_at) WHERE active;
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfers_owner ON scheduled_transfers (owner_id, id);
+
+-- Результат каждого запуска: succeeded или failed с причиной
+CREATE TABLE IF NOT EXISTS scheduled_transfer_runs (
+    id SERIAL PRIMARY KEY,
+    schedule_id INTEGER NOT NULL REFERENCES scheduled_transfers(id) ON DELETE CASCADE,
+    scheduled_for timestamptz NOT NULL,
+    executed_at timestamptz NOT NULL DEFAULT now(),
+    status VARCHAR(16) NOT NULL CHECK (status IN ('succeeded', 'failed')),
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_transfer_runs_schedule ON scheduled_transfer_runs (schedule_id, id);
\ No newline at end of file
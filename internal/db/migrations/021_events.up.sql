@@ -0,0 +1,66 @@
+-- События для подписчиков /api/events; хранятся для повторной отправки после переподключения
+CREATE TABLE IF NOT EXISTS events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(32) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_user ON events (user_id, id);
+CREATE INDEX IF NOT EXISTS idx_events_created ON events (created_at);
+
+-- Запись события и уведомление в канал events; NOTIFY доставляется только после фиксации транзакции,
+-- поэтому подписчики не видят событий отмененных операций
+CREATE OR REPLACE FUNCTION publish_event(p_user_id INTEGER, p_type VARCHAR, p_payload JSONB) RETURNS VOID AS $$
+DECLARE
+    v_id BIGINT;
+BEGIN
+    INSERT INTO events (user_id, type, payload) VALUES (p_user_id, p_type, p_payload)
+    RETURNING id INTO v_id;
+    PERFORM pg_notify('events', json_build_object('id', v_id, 'userId', p_user_id, 'type', p_type, 'data', p_payload)::text);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION transactions_publish_event() RETURNS trigger AS $$
+BEGIN
+    IF NEW.type = 'received' THEN
+        PERFORM publish_event(NEW.user_id, 'coins.received', jsonb_build_object(
+            'transactionId', NEW.id,
+            'from', NEW.counterparty,
+            'amount', NEW.amount,
+            'message', NEW.message
+        ));
+    ELSIF NEW.type = 'purchased' THEN
+        PERFORM publish_event(NEW.user_id, 'purchase.completed', jsonb_build_object(
+            'transactionId', NEW.id,
+            'item', NEW.merch,
+            'quantity', NEW.quantity,
+            'amount', NEW.amount,
+            'orderId', NEW.order_id
+        ));
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transactions_events ON transactions;
+CREATE TRIGGER transactions_events
+    AFTER INSERT ON transactions
+    FOR EACH ROW EXECUTE FUNCTION transactions_publish_event();
+
+CREATE OR REPLACE FUNCTION users_publish_balance_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM publish_event(NEW.id, 'balance.changed', jsonb_build_object(
+        'balance', NEW.coins,
+        'change', NEW.coins - OLD.coins
+    ));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_balance_events ON users;
+CREATE TRIGGER users_balance_events
+    AFTER UPDATE OF coins ON users
+    FOR EACH ROW WHEN (NEW.coins IS DISTINCT FROM OLD.coins)
+    EXECUTE FUNCTION users_publish_balance_event();
\ No newline at end of file
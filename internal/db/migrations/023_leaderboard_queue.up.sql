@@ -0,0 +1,27 @@
+-- Записи transactions, еще не учтенные в агрегатах рейтинга. Строка очереди появляется вместе
+-- с фиксацией записи, поэтому обновление не пропускает записи долгих транзакций и записи,
+-- зафиксированные не в порядке id, как это было с отметкой last_transaction_id
+CREATE TABLE IF NOT EXISTS leaderboard_queue (
+    transaction_id INTEGER PRIMARY KEY REFERENCES transactions(id) ON DELETE CASCADE
+);
+
+CREATE OR REPLACE FUNCTION transactions_enqueue_leaderboard() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO leaderboard_queue (transaction_id) VALUES (NEW.id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transactions_leaderboard ON transactions;
+CREATE TRIGGER transactions_leaderboard
+    AFTER INSERT ON transactions
+    FOR EACH ROW WHEN (NEW.type IN ('sent', 'reversal_credit', 'purchased', 'refund'))
+    EXECUTE FUNCTION transactions_enqueue_leaderboard();
+
+-- Агрегаты, собранные по отметке, могли пропустить записи; они пересобираются из всей истории
+TRUNCATE leaderboard_transfers, leaderboard_spending;
+INSERT INTO leaderboard_queue (transaction_id)
+SELECT id FROM transactions WHERE type IN ('sent', 'reversal_credit', 'purchased', 'refund')
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE leaderboard_state DROP COLUMN IF EXISTS last_transaction_id;
\ No newline at end of file
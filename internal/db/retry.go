@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrBusy Операция не выполнилась из-за конфликтов с параллельными транзакциями
+// даже после повторов; клиенту стоит повторить запрос позже
+var ErrBusy = errors.New("database is busy, try again later")
+
+const (
+	retryAttempts  = 5
+	retryBaseDelay = 10 * time.Millisecond
+	retryMaxDelay  = 200 * time.Millisecond
+)
+
+// Коды SQLSTATE, после которых транзакцию можно безопасно повторить
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+)
+
+// IsRetryable Ошибка сериализации или взаимоблокировки: транзакция откачена целиком
+func IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == codeSerializationFailure || pqErr.Code == codeDeadlockDetected
+	}
+	return false
+}
+
+// Retry Выполняет fn, повторяя ее при ошибках сериализации и взаимоблокировки
+// с экспоненциальной задержкой и случайным разбросом. fn должна целиком открывать
+// и завершать свою транзакцию. Если попытки исчерпаны, возвращается ошибка, совпадающая с ErrBusy
+func Retry(ctx context.Context, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+		if attempt == retryAttempts {
+			return fmt.Errorf("%w: %v", ErrBusy, err)
+		}
+
+		// Разброс не дает конфликтующим транзакциям повторяться синхронно
+		sleep := delay/2 + rand.N(delay/2+1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleep):
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
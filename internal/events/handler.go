@@ -0,0 +1,122 @@
+package events
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// heartbeatInterval Период комментариев-пингов, чтобы прокси не закрывали простаивающее соединение.
+	// Заодно перечитываются события, которые при прошлой выборке ждали завершения старых транзакций
+	heartbeatInterval = 15 * time.Second
+	// recheckDelay Повторная выборка после уведомления: событие могло быть отложено
+	// из-за незавершенной транзакции, начатой раньше
+	recheckDelay = time.Second
+	// pageSize Сколько событий читается за один запрос
+	pageSize = 500
+)
+
+// MakeEventsHandler GET /api/events - поток Server-Sent Events пользователя.
+// При переподключении с заголовком Last-Event-ID (или параметром ?lastEventId= для первого подключения)
+// сначала отправляются все сохраненные события после него, затем новые. Доставка не реже одного раза:
+// клиент должен отбрасывать события с уже полученным id
+func MakeEventsHandler(h *Hub, s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = r.URL.Query().Get("lastEventId")
+		}
+
+		userID := r.Context().Value("userID").(int)
+		// Подписка до чтения таблицы, чтобы не потерять сигнал о событии между выборкой и подпиской
+		sub := h.Subscribe(userID)
+		defer h.Unsubscribe(sub)
+
+		var (
+			cursor Cursor
+			err    error
+		)
+		if lastID != "" {
+			if cursor, err = ParseCursor(lastID); err != nil {
+				http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+		} else if cursor, err = s.Head(r.Context()); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Поток живет дольше WriteTimeout сервера
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "retry: 3000\n\n")
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		// send Отправка всех доступных событий после cursor постранично
+		send := func() error {
+			for {
+				events, err := s.After(r.Context(), userID, cursor, pageSize)
+				if err != nil {
+					return err
+				}
+				for _, e := range events {
+					if err := writeEvent(w, e); err != nil {
+						return err
+					}
+					cursor = e.Cursor()
+				}
+				if err := rc.Flush(); err != nil {
+					return err
+				}
+				if len(events) < pageSize {
+					return nil
+				}
+			}
+		}
+		if err := send(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		recheck := time.NewTimer(recheckDelay)
+		recheck.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case _, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				recheck.Reset(recheckDelay)
+			case <-recheck.C:
+			case <-heartbeat.C:
+				if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+					return
+				}
+			}
+			// При ошибке клиент переподключится с последним полученным id
+			if err := send(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent Данные - JSON в одну строку, поэтому поле data одно
+func writeEvent(w io.Writer, e Event) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.Cursor(), e.Type, e.Data)
+	return err
+}
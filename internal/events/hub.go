@@ -0,0 +1,154 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// channel Канал NOTIFY, в который пишет функция publish_event
+const channel = "events"
+
+// Subscription Сигналы о новых событиях пользователя для одного подключения. Сами события
+// читаются из таблицы events, поэтому сигналы, пришедшие до обработки предыдущего, схлопываются в один.
+// Канал C закрывается при остановке сервера
+type Subscription struct {
+	C      <-chan struct{}
+	c      chan struct{}
+	userID int
+}
+
+// Hub Раздает уведомления из Postgres подключениям этой реплики. Каждая реплика слушает канал сама,
+// поэтому событие доходит до пользователя, к какой бы реплике он ни был подключен
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[int]map[*Subscription]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[int]map[*Subscription]struct{})}
+}
+
+func (h *Hub) Subscribe(userID int) *Subscription {
+	c := make(chan struct{}, 1)
+	sub := &Subscription{C: c, c: c, userID: userID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(c)
+		return sub
+	}
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*Subscription]struct{})
+	}
+	h.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+// Publish Сигнал подписчикам пользователя о новом событии
+func (h *Hub) Publish(userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[userID] {
+		wake(sub)
+	}
+}
+
+// WakeAll Сигнал всем подписчикам, например после переподключения к базе, когда уведомления могли потеряться
+func (h *Hub) WakeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subs := range h.subs {
+		for sub := range subs {
+			wake(sub)
+		}
+	}
+}
+
+// Close Закрытие всех подписок без приема новых; вызывается при остановке сервера,
+// чтобы открытые потоки не задерживали Shutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// remove Вызывается под h.mu
+func (h *Hub) remove(sub *Subscription) {
+	subs, ok := h.subs[sub.userID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subs, sub.userID)
+	}
+	close(sub.c)
+}
+
+// wake Неблокирующая отправка: если сигнал уже ждет обработки, второй не нужен
+func wake(sub *Subscription) {
+	select {
+	case sub.c <- struct{}{}:
+	default:
+	}
+}
+
+// Listen Прием уведомлений из канала events до отмены ctx. pq.Listener сам переподключается
+// к базе; после переподключения будятся все подписчики, так как уведомления за время разрыва потеряны
+func (h *Hub) Listen(ctx context.Context, databaseURL string) error {
+	listener := pq.NewListener(databaseURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Events listener: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(channel); err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// nil приходит после восстановления соединения
+			if n == nil {
+				h.WakeAll()
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				log.Printf("Invalid event notification: %v", err)
+				continue
+			}
+			h.Publish(e.UserID)
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// pruneInterval Как часто удаляются события старше срока хранения
+const pruneInterval = time.Hour
+
+// Start Удаление событий старше retention до отмены ctx; пока событие хранится,
+// его можно получить повторно после переподключения
+func Start(ctx context.Context, s Service, retention time.Duration) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.Prune(ctx, time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("Events pruning failed: %v", err)
+			}
+			if deleted > 0 {
+				log.Printf("Old events deleted: %d", deleted)
+			}
+		}
+	}
+}
+
+// WatchDelay Проверка до отмены ctx, не задерживает ли выдачу событий транзакция дольше maxDelay.
+// Такая транзакция пишется в лог с pid, чтобы ее можно было найти и завершить
+func WatchDelay(ctx context.Context, s Service, maxDelay time.Duration) {
+	ticker := time.NewTicker(maxDelay / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t, ok, err := s.OldestTransaction(ctx)
+			if err != nil {
+				log.Printf("Events delay check failed: %v", err)
+				continue
+			}
+			if ok && t.Age > maxDelay {
+				log.Printf("Event delivery is held back by transaction pid %d running for %v: %s", t.PID, t.Age.Round(time.Second), t.Query)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid event id")
+
+// Типы событий; пишутся триггерами базы (миграция 021_events)
+const (
+	TypeCoinsReceived     = "coins.received"
+	TypePurchaseCompleted = "purchase.completed"
+	TypeBalanceChanged    = "balance.changed"
+)
+
+type Event struct {
+	ID     int64           `json:"id"`
+	UserID int             `json:"userId"`
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+	// TxID Транзакция, записавшая событие; вместе с ID задает порядок выдачи
+	TxID int64 `json:"-"`
+}
+
+// Cursor Позиция в потоке событий: последнее отданное событие. Порядок - (TxID, ID), а не ID:
+// id выдается при вставке, и события фиксируются не в порядке id
+type Cursor struct {
+	TxID int64
+	ID   int64
+}
+
+func (e Event) Cursor() Cursor {
+	return Cursor{TxID: e.TxID, ID: e.ID}
+}
+
+// String Значение поля id события Server-Sent Events
+func (c Cursor) String() string {
+	return strconv.FormatInt(c.TxID, 10) + "-" + strconv.FormatInt(c.ID, 10)
+}
+
+// ParseCursor Разбор Last-Event-ID в формате, который выдает Cursor.String
+func ParseCursor(s string) (Cursor, error) {
+	tx, id, ok := strings.Cut(s, "-")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var (
+		c   Cursor
+		err error
+	)
+	if c.TxID, err = strconv.ParseInt(tx, 10, 64); err != nil || c.TxID < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil || c.ID < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Service Чтение сохраненных событий и удаление старых
+type Service interface {
+	// Head Позиция, с которой новый подписчик получает только новые события
+	Head(ctx context.Context) (Cursor, error)
+	// After События пользователя после cursor по порядку, не больше limit
+	After(ctx context.Context, userID int, cursor Cursor, limit int) ([]Event, error)
+	Prune(ctx context.Context, before time.Time) (int, error)
+	// OldestTransaction Самая старая транзакция с записью в кластере; false, если таких нет
+	OldestTransaction(ctx context.Context) (Transaction, bool, error)
+}
+
+// Transaction Транзакция, которая задерживает выдачу событий: пока она не завершится,
+// события транзакций, начатых после нее, не отдаются (см. After)
+type Transaction struct {
+	PID   int
+	Age   time.Duration
+	Query string
+}
+
+type service struct {
+	db *sql.DB
+}
+
+func NewEventsService(db *sql.DB) Service {
+	return &service{db: db}
+}
+
+// Head Все транзакции младше xmin текущего снимка завершены, их события уже записаны и старше подписки
+func (s *service) Head(ctx context.Context) (Cursor, error) {
+	const op = "events/service/Head"
+	var c Cursor
+	err := s.db.QueryRowContext(ctx, "SELECT pg_snapshot_xmin(pg_current_snapshot())").Scan(&c.TxID)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%v: unable to get snapshot: %w", op, err)
+	}
+	return c, nil
+}
+
+// After Отдаются только события транзакций старше самой старой незавершенной: после такого события
+// уже не может зафиксироваться событие с меньшей позицией. События незавершенных транзакций
+// попадут в следующую выборку. Ограничение: одна долгая пишущая транзакция в любой базе кластера
+// задерживает события всех подписчиков до своего завершения; такие транзакции находит
+// OldestTransaction, а WatchDelay пишет о них в лог. Читающие транзакции без записи (например,
+// выгрузка выписки) номер транзакции не получают и выдачу не задерживают
+func (s *service) After(ctx context.Context, userID int, cursor Cursor, limit int) ([]Event, error) {
+	const op = "events/service/After"
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, tx_id, type, payload FROM events
+				WHERE user_id = $1 AND (tx_id, id) > ($2::xid8, $3)
+				AND tx_id < pg_snapshot_xmin(pg_current_snapshot())
+				ORDER BY tx_id, id
+				LIMIT $4`,
+		userID,
+		cursor.TxID,
+		cursor.ID,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%v: unable to get events: %w", op, err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		e := Event{UserID: userID}
+		if err := rows.Scan(&e.ID, &e.TxID, &e.Type, &e.Data); err != nil {
+			return nil, fmt.Errorf("%v: unable to read events: %w", op, err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%v: unable to read events: %w", op, err)
+	}
+	return events, nil
+}
+
+func (s *service) Prune(ctx context.Context, before time.Time) (int, error) {
+	const op = "events/service/Prune"
+	res, err := s.db.ExecContext(ctx, "DELETE FROM events WHERE created_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("%v: unable to delete events: %w", op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%v: unable to count deleted events: %w", op, err)
+	}
+	return int(n), nil
+}
+
+// OldestTransaction Номер транзакции выдается при первой записи, и только такие транзакции
+// ограничивают xmin снимка, по которому After отбирает события
+func (s *service) OldestTransaction(ctx context.Context) (Transaction, bool, error) {
+	const op = "events/service/OldestTransaction"
+	var (
+		t   Transaction
+		age float64
+	)
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT pid, EXTRACT(EPOCH FROM now() - xact_start), left(query, 200)
+				FROM pg_stat_activity
+				WHERE backend_xid IS NOT NULL AND xact_start IS NOT NULL
+				ORDER BY xact_start
+				LIMIT 1`,
+	).Scan(&t.PID, &age, &t.Query)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Transaction{}, false, nil
+		}
+		return Transaction{}, false, fmt.Errorf("%v: unable to get transactions: %w", op, err)
+	}
+	t.Age = time.Duration(age * float64(time.Second))
+	return t, true, nil
+}
@@ -0,0 +1,124 @@
+package hold
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type PlaceRequest struct {
+	Amount    int    `json:"amount"`
+	Reference string `json:"reference,omitempty"`
+	// Срок удержания в формате time.ParseDuration, например 30m или 48h
+	TTL string `json:"ttl,omitempty"`
+}
+
+type CaptureRequest struct {
+	ToUser string `json:"toUser"`
+}
+
+func MakePlaceHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PlaceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		var ttl time.Duration
+		if req.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.TTL); err != nil {
+				http.Error(w, "Invalid ttl", http.StatusBadRequest)
+				return
+			}
+		}
+
+		userID := r.Context().Value("userID").(int)
+		hold, err := s.Place(r.Context(), userID, req.Amount, req.Reference, ttl)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, hold)
+	}
+}
+
+func MakeListHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		holds, err := s.List(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, holds)
+	}
+}
+
+func MakeCaptureHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid hold id", http.StatusBadRequest)
+			return
+		}
+		var req CaptureRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ToUser == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		hold, err := s.Capture(r.Context(), id, req.ToUser)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, hold)
+	}
+}
+
+func MakeReleaseHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid hold id", http.StatusBadRequest)
+			return
+		}
+
+		hold, err := s.Release(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, hold)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrHoldNotFound):
+		http.Error(w, "Hold not found", http.StatusNotFound)
+	case errors.Is(err, ErrNotActive),
+		errors.Is(err, ErrHoldExpired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInsufficientFunds):
+		http.Error(w, "Not enough coins", http.StatusBadRequest)
+	case errors.Is(err, ErrRecipientNotFound):
+		http.Error(w, "User not found", http.StatusBadRequest)
+	case errors.Is(err, ErrInvalidAmount),
+		errors.Is(err, ErrInvalidTTL),
+		errors.Is(err, ErrReferenceTooLong),
+		errors.Is(err, ErrSameUser):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
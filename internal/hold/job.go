@@ -0,0 +1,28 @@
+package hold
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Start Возврат удержаний с истекшим сроком с периодом interval до отмены ctx
+func Start(ctx context.Context, s Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := s.ExpireDue(ctx)
+			if err != nil {
+				log.Printf("Hold expiry failed: %v", err)
+			}
+			if expired > 0 {
+				log.Printf("Expired holds released: %d", expired)
+			}
+		}
+	}
+}
@@ -0,0 +1,490 @@
+package hold
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"Avito-trainee/internal/ledger"
+	"Avito-trainee/internal/models"
+)
+
+var (
+	ErrInvalidAmount     = errors.New("amount must be positive")
+	ErrInvalidTTL        = errors.New("ttl must be positive and at most 30 days")
+	ErrReferenceTooLong  = errors.New("reference is too long")
+	ErrInsufficientFunds = errors.New("not enough coins")
+	ErrHoldNotFound      = errors.New("hold not found")
+	ErrNotActive         = errors.New("hold is already captured or released")
+	ErrHoldExpired       = errors.New("hold has expired")
+	ErrRecipientNotFound = errors.New("recipient not found")
+	ErrSameUser          = errors.New("unable to capture hold to its owner, release it instead")
+)
+
+// Статусы удержания
+const (
+	StatusActive   = "active"
+	StatusCaptured = "captured"
+	StatusReleased = "released"
+	StatusExpired  = "expired"
+)
+
+const (
+	maxReferenceLength = 100
+	maxTTL             = 30 * 24 * time.Hour
+	// expireBatch Сколько удержаний снимается за одну транзакцию
+	expireBatch = 100
+)
+
+// Hold Удержание монет пользователя User до перевода получателю ToUser или возврата
+type Hold struct {
+	ID         int        `json:"id"`
+	User       string     `json:"user"`
+	Amount     int        `json:"amount"`
+	Reference  string     `json:"reference,omitempty"`
+	Status     string     `json:"status"`
+	ToUser     string     `json:"toUser,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// Service Двухфазные переводы. Place списывает монеты с users.coins на системный счет escrow,
+// поэтому удержанные монеты нельзя потратить через SendCoin или BuyItem. Capture переводит их
+// получателю, Release и истечение срока возвращают владельцу
+type Service interface {
+	Place(ctx context.Context, userID, amount int, reference string, ttl time.Duration) (Hold, error)
+	List(ctx context.Context, userID int) ([]Hold, error)
+	Capture(ctx context.Context, id int, toUser string) (Hold, error)
+	Release(ctx context.Context, id int) (Hold, error)
+	ExpireDue(ctx context.Context) (int, error)
+}
+
+type service struct {
+	db         *sql.DB
+	defaultTTL time.Duration
+}
+
+// NewHoldService ttl - срок удержания, если он не указан при создании
+func NewHoldService(db *sql.DB, ttl time.Duration) Service {
+	return &service{db: db, defaultTTL: ttl}
+}
+
+const selectHold = `SELECT h.id, u.username, h.amount, h.reference, h.status, COALESCE(r.username, ''),
+		h.created_at, h.expires_at, h.resolved_at
+		FROM holds h
+		JOIN users u ON u.id = h.user_id
+		LEFT JOIN users r ON r.id = h.recipient_id`
+
+func (s *service) Place(ctx context.Context, userID, amount int, reference string, ttl time.Duration) (Hold, error) {
+	const op = "hold/service/Place"
+	if amount <= 0 {
+		return Hold{}, ErrInvalidAmount
+	}
+	if ttl == 0 {
+		ttl = s.defaultTTL
+	}
+	if ttl <= 0 || ttl > maxTTL {
+		return Hold{}, ErrInvalidTTL
+	}
+	if len([]rune(reference)) > maxReferenceLength {
+		return Hold{}, ErrReferenceTooLong
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var coins int
+	err = tx.QueryRowContext(ctx, "SELECT coins FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&coins)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: unable to check balance: %w", op, err)
+	}
+	if coins < amount {
+		return Hold{}, ErrInsufficientFunds
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET coins = coins - $1 WHERE id = $2", amount, userID)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: debit error: %w", op, err)
+	}
+
+	var id int
+	err = tx.QueryRowContext(
+		ctx,
+		`INSERT INTO holds (user_id, amount, reference, expires_at)
+				VALUES ($1, $2, $3, now() + $4 * interval '1 second') RETURNING id`,
+		userID,
+		amount,
+		reference,
+		int64(ttl/time.Second),
+	).Scan(&id)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: unable to create hold: %w", op, err)
+	}
+
+	entryID, err := ledger.Post(
+		ctx,
+		tx,
+		ledger.EntryHold,
+		reference,
+		ledger.User(userID, -amount),
+		ledger.System(ledger.AccountEscrow, amount),
+	)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: ledger error: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO transactions (user_id, type, amount, reason, entry_id, hold_id) VALUES ($1, $2, $3, $4, $5, $6)`,
+		userID,
+		models.TransactionHold,
+		amount,
+		reference,
+		entryID,
+		id,
+	)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: transaction error: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Hold{}, fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+
+	hold, err := s.get(ctx, id)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return hold, nil
+}
+
+func (s *service) List(ctx context.Context, userID int) ([]Hold, error) {
+	const op = "hold/service/List"
+	rows, err := s.db.QueryContext(ctx, selectHold+` WHERE h.user_id = $1 ORDER BY h.id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%v: unable to get holds: %w", op, err)
+	}
+	defer rows.Close()
+
+	holds := []Hold{}
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", op, err)
+		}
+		holds = append(holds, hold)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%v: unable to read holds: %w", op, err)
+	}
+	return holds, nil
+}
+
+// locked Удержание, заблокированное в транзакции
+type locked struct {
+	id        int
+	userID    int
+	username  string
+	amount    int
+	reference string
+}
+
+// lockActive Блокировка активного удержания. Для удержания с истекшим сроком возвращается
+// и само удержание, и ErrHoldExpired: его можно только вернуть владельцу
+func lockActive(ctx context.Context, tx *sql.Tx, id int) (locked, error) {
+	var (
+		h       locked
+		status  string
+		expired bool
+	)
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT h.user_id, u.username, h.amount, h.reference, h.status, h.expires_at <= now()
+				FROM holds h
+				JOIN users u ON u.id = h.user_id
+				WHERE h.id = $1
+				FOR UPDATE OF h`,
+		id,
+	).Scan(&h.userID, &h.username, &h.amount, &h.reference, &status, &expired)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return locked{}, ErrHoldNotFound
+		}
+		return locked{}, fmt.Errorf("unable to lock hold: %w", err)
+	}
+	if status != StatusActive {
+		return locked{}, ErrNotActive
+	}
+	h.id = id
+	if expired {
+		return h, ErrHoldExpired
+	}
+	return h, nil
+}
+
+// Capture Перевод удержанных монет получателю. Владельцу пишутся hold_released и sent,
+// получателю - received, поэтому перевод виден в истории обоих как обычный
+func (s *service) Capture(ctx context.Context, id int, toUser string) (Hold, error) {
+	const op = "hold/service/Capture"
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	h, err := lockActive(ctx, tx, id)
+	if err != nil {
+		if errors.Is(err, ErrHoldNotFound) || errors.Is(err, ErrNotActive) || errors.Is(err, ErrHoldExpired) {
+			return Hold{}, err
+		}
+		return Hold{}, fmt.Errorf("%v: %w", op, err)
+	}
+
+	var recipientID int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", toUser).Scan(&recipientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Hold{}, ErrRecipientNotFound
+		}
+		return Hold{}, fmt.Errorf("%v: unable to find user: %w", op, err)
+	}
+	if recipientID == h.userID {
+		return Hold{}, ErrSameUser
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET coins = coins + $1 WHERE id = $2", h.amount, recipientID)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: credit error: %w", op, err)
+	}
+
+	entryID, err := ledger.Post(
+		ctx,
+		tx,
+		ledger.EntryCapture,
+		h.reference,
+		ledger.System(ledger.AccountEscrow, -h.amount),
+		ledger.User(recipientID, h.amount),
+	)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: ledger error: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO transactions (user_id, type, counterparty, amount, reason, message, entry_id, hold_id) VALUES
+					($1, 'hold_released', NULL, $3, $5, NULL, $6, $7),
+					($1, 'sent', $4, $3, NULL, NULLIF($5, ''), $6, $7),
+					($2, 'received', $8, $3, NULL, NULLIF($5, ''), $6, $7)`,
+		h.userID,
+		recipientID,
+		h.amount,
+		toUser,
+		h.reference,
+		entryID,
+		h.id,
+		h.username,
+	)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: transaction error: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE holds SET status = 'captured', recipient_id = $2, resolved_at = now() WHERE id = $1`,
+		h.id,
+		recipientID,
+	)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: unable to update hold: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Hold{}, fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+
+	hold, err := s.get(ctx, id)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return hold, nil
+}
+
+// Release Возврат удержанных монет владельцу
+func (s *service) Release(ctx context.Context, id int) (Hold, error) {
+	const op = "hold/service/Release"
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	h, err := lockActive(ctx, tx, id)
+	// Истекшее удержание можно вернуть, не дожидаясь фоновой задачи
+	if errors.Is(err, ErrHoldExpired) {
+		err = nil
+	}
+	if err != nil {
+		if errors.Is(err, ErrHoldNotFound) || errors.Is(err, ErrNotActive) {
+			return Hold{}, err
+		}
+		return Hold{}, fmt.Errorf("%v: %w", op, err)
+	}
+
+	if err := release(ctx, tx, h, StatusReleased); err != nil {
+		return Hold{}, fmt.Errorf("%v: %w", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Hold{}, fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+
+	hold, err := s.get(ctx, id)
+	if err != nil {
+		return Hold{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return hold, nil
+}
+
+// ExpireDue Возврат всех удержаний с истекшим сроком. Удержания, которые в это время
+// обрабатывает другая реплика или Capture, пропускаются благодаря SKIP LOCKED
+func (s *service) ExpireDue(ctx context.Context) (int, error) {
+	const op = "hold/service/ExpireDue"
+	expired := 0
+	for {
+		n, err := s.expireBatch(ctx)
+		if err != nil {
+			return expired, fmt.Errorf("%v: %w", op, err)
+		}
+		expired += n
+		if n < expireBatch {
+			return expired, nil
+		}
+	}
+}
+
+func (s *service) expireBatch(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT h.id, h.user_id, u.username, h.amount, h.reference
+				FROM holds h
+				JOIN users u ON u.id = h.user_id
+				WHERE h.status = 'active' AND h.expires_at <= now()
+				ORDER BY h.user_id, h.id
+				LIMIT $1
+				FOR UPDATE OF h SKIP LOCKED`,
+		expireBatch,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get expired holds: %w", err)
+	}
+
+	var batch []locked
+	for rows.Next() {
+		var h locked
+		if err := rows.Scan(&h.id, &h.userID, &h.username, &h.amount, &h.reference); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("unable to read expired holds: %w", err)
+		}
+		batch = append(batch, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("unable to read expired holds: %w", err)
+	}
+
+	// Балансы меняются в порядке id пользователей, как и при переводах, чтобы не взаимоблокироваться с ними
+	for _, h := range batch {
+		if err := release(ctx, tx, h, StatusExpired); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return len(batch), nil
+}
+
+// release Возврат монет владельцу заблокированного удержания
+func release(ctx context.Context, tx *sql.Tx, h locked, status string) error {
+	_, err := tx.ExecContext(ctx, "UPDATE users SET coins = coins + $1 WHERE id = $2", h.amount, h.userID)
+	if err != nil {
+		return fmt.Errorf("credit error: %w", err)
+	}
+
+	entryID, err := ledger.Post(
+		ctx,
+		tx,
+		ledger.EntryRelease,
+		h.reference,
+		ledger.System(ledger.AccountEscrow, -h.amount),
+		ledger.User(h.userID, h.amount),
+	)
+	if err != nil {
+		return fmt.Errorf("ledger error: %w", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO transactions (user_id, type, amount, reason, entry_id, hold_id) VALUES ($1, $2, $3, $4, $5, $6)`,
+		h.userID,
+		models.TransactionHoldRelease,
+		h.amount,
+		h.reference,
+		entryID,
+		h.id,
+	)
+	if err != nil {
+		return fmt.Errorf("transaction error: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE holds SET status = $2, resolved_at = now() WHERE id = $1`, h.id, status)
+	if err != nil {
+		return fmt.Errorf("unable to update hold: %w", err)
+	}
+	return nil
+}
+
+func (s *service) get(ctx context.Context, id int) (Hold, error) {
+	return scanHold(s.db.QueryRowContext(ctx, selectHold+` WHERE h.id = $1`, id))
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanHold(row scanner) (Hold, error) {
+	var (
+		hold       Hold
+		resolvedAt sql.NullTime
+	)
+	err := row.Scan(
+		&hold.ID,
+		&hold.User,
+		&hold.Amount,
+		&hold.Reference,
+		&hold.Status,
+		&hold.ToUser,
+		&hold.CreatedAt,
+		&hold.ExpiresAt,
+		&resolvedAt,
+	)
+	if err != nil {
+		return Hold{}, fmt.Errorf("unable to read hold: %w", err)
+	}
+	if resolvedAt.Valid {
+		hold.ResolvedAt = &resolvedAt.Time
+	}
+	return hold, nil
+}
@@ -2,13 +2,28 @@ package info
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func MakeInfoHandler(s Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// ?groupPurchases=true группирует покупки по товару
+		var opts Options
+		if group := r.URL.Query().Get("groupPurchases"); group != "" {
+			value, err := strconv.ParseBool(group)
+			if err != nil {
+				http.Error(w, "Invalid groupPurchases", http.StatusBadRequest)
+				return
+			}
+			opts.GroupPurchases = value
+		}
+
 		userID := r.Context().Value("userID").(int)
-		info, err := s.GetInfo(r.Context(), userID)
+		info, err := s.GetInfo(r.Context(), userID, opts)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
@@ -18,3 +33,55 @@ func MakeInfoHandler(s Service) http.HandlerFunc {
 		json.NewEncoder(w).Encode(info)
 	}
 }
+
+// MakeTransactionsHandler GET /api/transactions?type=sent,received&counterparty=&merch=&from=&to=&limit=&cursor=
+// from и to в формате RFC 3339
+func MakeTransactionsHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := TransactionFilter{
+			Counterparty: query.Get("counterparty"),
+			Merch:        query.Get("merch"),
+			Cursor:       query.Get("cursor"),
+		}
+		if types := query.Get("type"); types != "" {
+			filter.Types = strings.Split(types, ",")
+		}
+		if limit := query.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = n
+		}
+		for name, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+			if value := query.Get(name); value != "" {
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					http.Error(w, "Invalid "+name, http.StatusBadRequest)
+					return
+				}
+				*dst = &t
+			}
+		}
+
+		userID := r.Context().Value("userID").(int)
+		page, err := s.ListTransactions(r.Context(), userID, filter)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidCursor),
+				errors.Is(err, ErrInvalidType),
+				errors.Is(err, ErrInvalidLimit),
+				errors.Is(err, ErrInvalidPeriod):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
@@ -4,12 +4,22 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 type InfoResponse struct {
+	// Coins и Available - монеты, которые можно потратить; Held - удержанные, они вернутся
+	// или будут переведены получателю
 	Coins       int             `json:"coins"`
+	Available   int             `json:"available"`
+	Held        int             `json:"held"`
 	Inventory   []InventoryItem `json:"inventory"`
 	CoinHistory CoinHistory     `json:"coinHistory"`
+	// Покупки по порядку или, с Options.GroupPurchases, сгруппированные по товару
+	Purchases       []Purchase      `json:"purchases,omitempty"`
+	PurchasesByItem []ItemPurchases `json:"purchasesByItem,omitempty"`
 }
 
 type InventoryItem struct {
@@ -18,18 +28,60 @@ type InventoryItem struct {
 }
 
 type CoinHistory struct {
-	Received []Transaction `json:"received"`
-	Sent     []Transaction `json:"sent"`
+	Received    []Transaction `json:"received"`
+	Sent        []Transaction `json:"sent"`
+	Adjustments []Adjustment  `json:"adjustments"`
 }
 
 type Transaction struct {
+	// ID записи истории, по нему можно запросить отмену перевода или вернуть полученный
+	ID       int    `json:"id"`
 	FromUser string `json:"fromUser,omitempty"`
 	ToUser   string `json:"toUser,omitempty"`
 	Amount   int    `json:"amount"`
+	// Сообщение и теги, указанные отправителем
+	Message string   `json:"message,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// Adjustment Административное изменение баланса: начисление, списание, корректировка,
+// отмена перевода или возврат покупки. Amount со знаком: положительный при увеличении баланса
+type Adjustment struct {
+	Type   string `json:"type"`
+	Amount int    `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+// Purchase Покупка товара: цена за единицу и сумма на момент покупки
+type Purchase struct {
+	ID          int       `json:"id"`
+	Item        string    `json:"item"`
+	Quantity    int       `json:"quantity"`
+	Price       int       `json:"price"`
+	Total       int       `json:"total"`
+	OrderID     *int      `json:"orderId,omitempty"`
+	Refunded    bool      `json:"refunded,omitempty"`
+	PurchasedAt time.Time `json:"purchasedAt"`
+}
+
+// ItemPurchases Покупки одного товара. Quantity и Spent без учета возвращенных покупок
+type ItemPurchases struct {
+	Item             string     `json:"item"`
+	Quantity         int        `json:"quantity"`
+	Spent            int        `json:"spent"`
+	FirstPurchasedAt time.Time  `json:"firstPurchasedAt"`
+	LastPurchasedAt  time.Time  `json:"lastPurchasedAt"`
+	Purchases        []Purchase `json:"purchases"`
+}
+
+// Options Параметры ответа GetInfo
+type Options struct {
+	GroupPurchases bool
 }
 
 type Service interface {
-	GetInfo(ctx context.Context, userID int) (InfoResponse, error)
+	GetInfo(ctx context.Context, userID int, opts Options) (InfoResponse, error)
+	ListTransactions(ctx context.Context, userID int, filter TransactionFilter) (TransactionPage, error)
 }
 type service struct {
 	db *sql.DB
@@ -39,16 +91,17 @@ func NewInfoService(db *sql.DB) Service {
 	return &service{db: db}
 }
 
-func (s *service) GetInfo(ctx context.Context, userID int) (InfoResponse, error) {
+func (s *service) GetInfo(ctx context.Context, userID int, opts Options) (InfoResponse, error) {
 	const op = "info/service/GetInfo"
-	var coins int
+	var coins, held int
 	err := s.db.QueryRowContext(
 		ctx,
-		"SELECT coins FROM users WHERE id = $1",
+		`SELECT coins, (SELECT COALESCE(SUM(amount), 0) FROM holds WHERE user_id = $1 AND status = 'active')
+				FROM users WHERE id = $1`,
 		userID,
-	).Scan(&coins)
+	).Scan(&coins, &held)
 	if err != nil {
-		return InfoResponse{}, fmt.Errorf("%v: unable to get balance: %w", err)
+		return InfoResponse{}, fmt.Errorf("%v: unable to get balance: %w", op, err)
 	}
 
 	rows, err := s.db.QueryContext(
@@ -73,7 +126,7 @@ func (s *service) GetInfo(ctx context.Context, userID int) (InfoResponse, error)
 	var received, sent []Transaction
 	receivedRows, err := s.db.QueryContext(
 		ctx,
-		"SELECT counterparty, amount FROM transactions WHERE user_id = $1 AND type = 'received'",
+		"SELECT id, counterparty, amount, COALESCE(message, ''), tags FROM transactions WHERE user_id = $1 AND type = 'received'",
 		userID,
 	)
 	if err != nil {
@@ -83,7 +136,7 @@ func (s *service) GetInfo(ctx context.Context, userID int) (InfoResponse, error)
 
 	for receivedRows.Next() {
 		var t Transaction
-		if err := receivedRows.Scan(&t.FromUser, &t.Amount); err != nil {
+		if err := receivedRows.Scan(&t.ID, &t.FromUser, &t.Amount, &t.Message, pq.Array(&t.Tags)); err != nil {
 			return InfoResponse{}, fmt.Errorf("%v: unable to get details of received transactions: %w", op, err)
 		}
 		received = append(received, t)
@@ -91,7 +144,7 @@ func (s *service) GetInfo(ctx context.Context, userID int) (InfoResponse, error)
 
 	sentRows, err := s.db.QueryContext(
 		ctx,
-		"SELECT counterparty, amount FROM transactions WHERE user_id = $1 AND type = 'sent'",
+		"SELECT id, counterparty, amount, COALESCE(message, ''), tags FROM transactions WHERE user_id = $1 AND type = 'sent'",
 		userID,
 	)
 	if err != nil {
@@ -101,18 +154,114 @@ func (s *service) GetInfo(ctx context.Context, userID int) (InfoResponse, error)
 
 	for sentRows.Next() {
 		var t Transaction
-		if err := sentRows.Scan(&t.ToUser, &t.Amount); err != nil {
+		if err := sentRows.Scan(&t.ID, &t.ToUser, &t.Amount, &t.Message, pq.Array(&t.Tags)); err != nil {
 			return InfoResponse{}, fmt.Errorf("%v: unable to get details of sent transactions: %w", op, err)
 		}
 		sent = append(sent, t)
 	}
 
-	return InfoResponse{
+	var adjustments []Adjustment
+	adjustmentRows, err := s.db.QueryContext(
+		ctx,
+		`SELECT type, CASE WHEN type IN ('deduction', 'reversal_debit') THEN -amount ELSE amount END, COALESCE(reason, '')
+				FROM transactions
+				WHERE user_id = $1 AND type IN ('grant', 'deduction', 'adjustment', 'reversal_debit', 'reversal_credit', 'refund')
+				ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return InfoResponse{}, fmt.Errorf("%v: unable to get adjustments: %w", op, err)
+	}
+	defer adjustmentRows.Close()
+
+	for adjustmentRows.Next() {
+		var a Adjustment
+		if err := adjustmentRows.Scan(&a.Type, &a.Amount, &a.Reason); err != nil {
+			return InfoResponse{}, fmt.Errorf("%v: unable to get details of adjustments: %w", op, err)
+		}
+		adjustments = append(adjustments, a)
+	}
+
+	purchases, err := s.getPurchases(ctx, userID)
+	if err != nil {
+		return InfoResponse{}, fmt.Errorf("%v: %w", op, err)
+	}
+
+	response := InfoResponse{
 		Coins:     coins,
+		Available: coins,
+		Held:      held,
 		Inventory: inventory,
 		CoinHistory: CoinHistory{
-			Received: received,
-			Sent:     sent,
+			Received:    received,
+			Sent:        sent,
+			Adjustments: adjustments,
 		},
-	}, nil
+		Purchases: purchases,
+	}
+	if opts.GroupPurchases {
+		response.Purchases = nil
+		response.PurchasesByItem = groupPurchases(purchases)
+	}
+	return response, nil
+}
+
+// getPurchases Покупки пользователя от старых к новым
+func (s *service) getPurchases(ctx context.Context, userID int) ([]Purchase, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT t.id, t.merch, t.quantity, t.amount, t.order_id,
+					EXISTS (SELECT 1 FROM transactions r WHERE r.reverses_id = t.id), t.created_at
+				FROM transactions t
+				WHERE t.user_id = $1 AND t.type = 'purchased'
+				ORDER BY t.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get purchases: %w", err)
+	}
+	defer rows.Close()
+
+	var purchases []Purchase
+	for rows.Next() {
+		var (
+			p       Purchase
+			orderID sql.NullInt64
+		)
+		if err := rows.Scan(&p.ID, &p.Item, &p.Quantity, &p.Total, &orderID, &p.Refunded, &p.PurchasedAt); err != nil {
+			return nil, fmt.Errorf("unable to get details of purchases: %w", err)
+		}
+		p.Price = p.Total
+		if p.Quantity > 0 {
+			p.Price = p.Total / p.Quantity
+		}
+		p.OrderID = nullableInt(orderID)
+		purchases = append(purchases, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to get details of purchases: %w", err)
+	}
+	return purchases, nil
+}
+
+// groupPurchases Группировка по товару в порядке первой покупки
+func groupPurchases(purchases []Purchase) []ItemPurchases {
+	var groups []ItemPurchases
+	index := make(map[string]int)
+	for _, p := range purchases {
+		i, ok := index[p.Item]
+		if !ok {
+			i = len(groups)
+			index[p.Item] = i
+			groups = append(groups, ItemPurchases{Item: p.Item, FirstPurchasedAt: p.PurchasedAt})
+		}
+		g := &groups[i]
+		g.Purchases = append(g.Purchases, p)
+		g.LastPurchasedAt = p.PurchasedAt
+		if !p.Refunded {
+			g.Quantity += p.Quantity
+			g.Spent += p.Total
+		}
+	}
+	return groups
 }
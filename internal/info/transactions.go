@@ -0,0 +1,232 @@
+package info
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"Avito-trainee/internal/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidType   = errors.New("unknown transaction type")
+	ErrInvalidLimit  = errors.New("limit must be positive")
+	ErrInvalidPeriod = errors.New("from must be before to")
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// TransactionFilter Фильтры истории. Пустые поля не ограничивают выборку;
+// From включается в период, To - нет
+type TransactionFilter struct {
+	Types        []string
+	Counterparty string
+	Merch        string
+	From         *time.Time
+	To           *time.Time
+	Cursor       string
+	Limit        int
+}
+
+// HistoryEntry Запись истории с балансом пользователя сразу после нее
+type HistoryEntry struct {
+	ID           int       `json:"id"`
+	Type         string    `json:"type"`
+	Amount       int       `json:"amount"`
+	Counterparty string    `json:"counterparty,omitempty"`
+	Merch        string    `json:"merch,omitempty"`
+	Quantity     int       `json:"quantity,omitempty"`
+	OrderID      *int      `json:"orderId,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Message      string    `json:"message,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	HoldID       *int      `json:"holdId,omitempty"`
+	ReversesID   *int      `json:"reversesId,omitempty"`
+	BalanceAfter int       `json:"balanceAfter"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TransactionPage Страница истории от новых записей к старым; NextCursor пуст на последней странице
+type TransactionPage struct {
+	Items      []HistoryEntry `json:"items"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// ListTransactions История операций пользователя с курсорной пагинацией.
+// Баланс после каждой записи отсчитывается назад от текущего users.coins, поэтому у самой
+// новой записи он совпадает с балансом, даже если история начиналась не со стартовой суммы
+func (s *service) ListTransactions(ctx context.Context, userID int, filter TransactionFilter) (TransactionPage, error) {
+	const op = "info/service/ListTransactions"
+	for _, t := range filter.Types {
+		if _, ok := models.TransactionSigns[t]; !ok {
+			return TransactionPage{}, fmt.Errorf("%w: %s", ErrInvalidType, t)
+		}
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return TransactionPage{}, ErrInvalidPeriod
+	}
+	limit := filter.Limit
+	switch {
+	case limit < 0:
+		return TransactionPage{}, ErrInvalidLimit
+	case limit == 0:
+		limit = defaultPageSize
+	case limit > maxPageSize:
+		limit = maxPageSize
+	}
+	before, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return TransactionPage{}, err
+	}
+
+	var from, to sql.NullTime
+	if filter.From != nil {
+		from = sql.NullTime{Time: *filter.From, Valid: true}
+	}
+	if filter.To != nil {
+		to = sql.NullTime{Time: *filter.To, Valid: true}
+	}
+	types, signs := models.SignedTypes()
+
+	// Сначала выбирается страница с фильтрами и LIMIT, затем баланс: начало - текущий баланс
+	// за вычетом одной суммы записей новее страницы, окно идет только по записям между первой
+	// и последней записью страницы, включая отфильтрованные. Стоимость зависит от размера
+	// страницы, а не от длины истории
+	rows, err := s.db.QueryContext(
+		ctx,
+		`WITH signs AS (
+					SELECT * FROM unnest($2::text[], $3::int[]) AS s(type, sign)
+				), page AS (
+					SELECT t.id, t.type, t.amount, COALESCE(t.counterparty, '') AS counterparty,
+						COALESCE(t.merch, '') AS merch, t.quantity, t.order_id, COALESCE(t.reason, '') AS reason,
+						COALESCE(t.message, '') AS message, t.tags, t.hold_id, t.reverses_id, t.created_at
+					FROM transactions t
+					WHERE t.user_id = $1 AND ($4 = 0 OR t.id < $4)
+					AND (cardinality($5::text[]) = 0 OR t.type = ANY($5))
+					AND ($6 = '' OR t.counterparty = $6)
+					AND ($7 = '' OR t.merch = $7)
+					AND ($8::timestamptz IS NULL OR t.created_at >= $8)
+					AND ($9::timestamptz IS NULL OR t.created_at < $9)
+					ORDER BY t.id DESC
+					LIMIT $10
+				), anchor AS (
+					SELECT u.coins - COALESCE((
+						SELECT SUM(COALESCE(s.sign, 0) * t.amount)
+						FROM transactions t
+						LEFT JOIN signs s ON s.type = t.type
+						WHERE t.user_id = $1 AND t.id > (SELECT max(id) FROM page)
+					), 0) AS balance
+					FROM users u
+					WHERE u.id = $1
+				), span AS (
+					SELECT t.id,
+						a.balance - COALESCE(SUM(COALESCE(s.sign, 0) * t.amount) OVER (
+							ORDER BY t.id DESC ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING
+						), 0) AS balance_after
+					FROM transactions t
+					CROSS JOIN anchor a
+					LEFT JOIN signs s ON s.type = t.type
+					WHERE t.user_id = $1
+					AND t.id BETWEEN (SELECT min(id) FROM page) AND (SELECT max(id) FROM page)
+				)
+				SELECT p.id, p.type, p.amount, p.counterparty, p.merch, p.quantity, p.order_id, p.reason,
+					p.message, p.tags, p.hold_id, p.reverses_id, b.balance_after, p.created_at
+				FROM page p
+				JOIN span b ON b.id = p.id
+				ORDER BY p.id DESC`,
+		userID,
+		pq.Array(types),
+		pq.Array(signs),
+		before,
+		pq.Array(filter.Types),
+		filter.Counterparty,
+		filter.Merch,
+		from,
+		to,
+		limit+1,
+	)
+	if err != nil {
+		return TransactionPage{}, fmt.Errorf("%v: unable to get transactions: %w", op, err)
+	}
+	defer rows.Close()
+
+	page := TransactionPage{Items: []HistoryEntry{}}
+	for rows.Next() {
+		var (
+			e                           HistoryEntry
+			orderID, holdID, reversesID sql.NullInt64
+		)
+		err := rows.Scan(
+			&e.ID,
+			&e.Type,
+			&e.Amount,
+			&e.Counterparty,
+			&e.Merch,
+			&e.Quantity,
+			&orderID,
+			&e.Reason,
+			&e.Message,
+			pq.Array(&e.Tags),
+			&holdID,
+			&reversesID,
+			&e.BalanceAfter,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return TransactionPage{}, fmt.Errorf("%v: unable to read transactions: %w", op, err)
+		}
+		e.OrderID = nullableInt(orderID)
+		e.HoldID = nullableInt(holdID)
+		e.ReversesID = nullableInt(reversesID)
+		page.Items = append(page.Items, e)
+	}
+	if err := rows.Err(); err != nil {
+		return TransactionPage{}, fmt.Errorf("%v: unable to read transactions: %w", op, err)
+	}
+
+	// Лишняя запись означает, что есть следующая страница
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = encodeCursor(page.Items[limit-1].ID)
+	}
+	return page, nil
+}
+
+// encodeCursor Курсор - id последней записи страницы; клиент не должен разбирать его формат
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+// decodeCursor id, с которого начинается страница; 0 для пустого курсора
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(string(raw))
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
+
+func nullableInt(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
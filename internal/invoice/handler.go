@@ -0,0 +1,121 @@
+package invoice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"Avito-trainee/internal/coin"
+	"Avito-trainee/internal/db"
+)
+
+type CreateRequest struct {
+	Payer  string `json:"payer"`
+	Amount int    `json:"amount"`
+	Note   string `json:"note,omitempty"`
+}
+
+func MakeCreateHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Payer == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		request, err := s.Create(r.Context(), userID, req.Payer, req.Amount, req.Note)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, request)
+	}
+}
+
+// MakeListHandler ?direction=incoming (по умолчанию) или outgoing
+func MakeListHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		direction := r.URL.Query().Get("direction")
+		if direction == "" {
+			direction = Incoming
+		}
+		if direction != Incoming && direction != Outgoing {
+			http.Error(w, "Invalid direction", http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		requests, err := s.List(r.Context(), userID, direction)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, requests)
+	}
+}
+
+func MakeAcceptHandler(s Service) http.HandlerFunc {
+	return makeActionHandler(s.Accept)
+}
+
+func MakeDeclineHandler(s Service) http.HandlerFunc {
+	return makeActionHandler(s.Decline)
+}
+
+func MakeCancelHandler(s Service) http.HandlerFunc {
+	return makeActionHandler(s.Cancel)
+}
+
+func makeActionHandler(action func(ctx context.Context, userID, id int) (Request, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid request id", http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		request, err := action(r.Context(), userID, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, request)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrRequestNotFound):
+		http.Error(w, "Payment request not found", http.StatusNotFound)
+	case errors.Is(err, ErrNotPending),
+		errors.Is(err, ErrExpired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrPayerNotFound):
+		http.Error(w, "User not found", http.StatusBadRequest)
+	case errors.Is(err, ErrInvalidAmount),
+		errors.Is(err, ErrSelfRequest),
+		errors.Is(err, coin.ErrMessageTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	// Ошибки перевода при принятии запроса
+	case errors.Is(err, coin.ErrInsufficientFunds):
+		http.Error(w, "Not enough coins", http.StatusBadRequest)
+	case errors.Is(err, coin.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusBadRequest)
+	case errors.Is(err, coin.ErrPolicyViolation):
+		coin.WritePolicyError(w, err)
+	case errors.Is(err, db.ErrBusy):
+		coin.WriteBusy(w)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
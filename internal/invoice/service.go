@@ -0,0 +1,306 @@
+package invoice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"Avito-trainee/internal/coin"
+	"Avito-trainee/internal/db"
+)
+
+var (
+	ErrInvalidAmount   = errors.New("amount must be positive")
+	ErrSelfRequest     = errors.New("unable to request coins from yourself")
+	ErrPayerNotFound   = errors.New("payer not found")
+	ErrRequestNotFound = errors.New("payment request not found")
+	ErrNotPending      = errors.New("payment request is already resolved")
+	ErrExpired         = errors.New("payment request has expired")
+)
+
+// Статусы запроса. StatusExpired не хранится в таблице: его получает запрос pending с истекшим сроком
+const (
+	StatusPending   = "pending"
+	StatusAccepted  = "accepted"
+	StatusDeclined  = "declined"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
+// Направления списка запросов
+const (
+	Incoming = "incoming" // Запросы к пользователю, ожидающие оплаты
+	Outgoing = "outgoing" // Запросы, созданные пользователем, во всех статусах
+)
+
+// listLimit Сколько последних запросов возвращает List
+const listLimit = 100
+
+// Request Запрос на оплату: From просит To перевести Amount монет
+type Request struct {
+	ID         int        `json:"id"`
+	From       string     `json:"from"`
+	To         string     `json:"to"`
+	Amount     int        `json:"amount"`
+	Note       string     `json:"note,omitempty"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// Service Запросы на оплату между пользователями. Принятие запроса выполняет обычный перевод
+// coin.Service.SendCoinTx от плательщика к автору запроса, поэтому действуют те же проверки баланса и политики
+type Service interface {
+	Create(ctx context.Context, requesterID int, payer string, amount int, note string) (Request, error)
+	List(ctx context.Context, userID int, direction string) ([]Request, error)
+	Accept(ctx context.Context, payerID, id int) (Request, error)
+	Decline(ctx context.Context, payerID, id int) (Request, error)
+	Cancel(ctx context.Context, requesterID, id int) (Request, error)
+}
+
+type service struct {
+	db    *sql.DB
+	coins coin.Service
+	ttl   time.Duration
+}
+
+// NewInvoiceService Запросы истекают через ttl после создания
+func NewInvoiceService(db *sql.DB, coins coin.Service, ttl time.Duration) Service {
+	return &service{db: db, coins: coins, ttl: ttl}
+}
+
+// selectRequest Запрос с именами участников и статусом с учетом срока действия
+const selectRequest = `SELECT p.id, r.username, u.username, p.amount, p.note,
+		CASE WHEN p.status = 'pending' AND p.expires_at <= now() THEN 'expired' ELSE p.status END,
+		p.created_at, p.expires_at, p.resolved_at
+		FROM payment_requests p
+		JOIN users r ON r.id = p.requester_id
+		JOIN users u ON u.id = p.payer_id`
+
+func (s *service) Create(ctx context.Context, requesterID int, payer string, amount int, note string) (Request, error) {
+	const op = "invoice/service/Create"
+	if amount <= 0 {
+		return Request{}, ErrInvalidAmount
+	}
+	memo, err := coin.Memo{Message: note}.Normalize()
+	if err != nil {
+		return Request{}, err
+	}
+
+	var payerID int
+	err = s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", payer).Scan(&payerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Request{}, ErrPayerNotFound
+		}
+		return Request{}, fmt.Errorf("%v: unable to find user: %w", op, err)
+	}
+	if payerID == requesterID {
+		return Request{}, ErrSelfRequest
+	}
+
+	var id int
+	err = s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO payment_requests (requester_id, payer_id, amount, note, expires_at)
+				VALUES ($1, $2, $3, $4, now() + $5 * interval '1 second') RETURNING id`,
+		requesterID,
+		payerID,
+		amount,
+		memo.Message,
+		int64(s.ttl/time.Second),
+	).Scan(&id)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: unable to create request: %w", op, err)
+	}
+
+	request, err := s.get(ctx, id)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return request, nil
+}
+
+func (s *service) List(ctx context.Context, userID int, direction string) ([]Request, error) {
+	const op = "invoice/service/List"
+	query := selectRequest + ` WHERE p.payer_id = $1 AND p.status = 'pending' AND p.expires_at > now()
+			ORDER BY p.id DESC LIMIT $2`
+	if direction == Outgoing {
+		query = selectRequest + ` WHERE p.requester_id = $1 ORDER BY p.id DESC LIMIT $2`
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, userID, listLimit)
+	if err != nil {
+		return nil, fmt.Errorf("%v: unable to get requests: %w", op, err)
+	}
+	defer rows.Close()
+
+	requests := []Request{}
+	for rows.Next() {
+		request, err := scanRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", op, err)
+		}
+		requests = append(requests, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%v: unable to read requests: %w", op, err)
+	}
+	return requests, nil
+}
+
+// Accept Оплата запроса. Запрос переводится в accepted условным UPDATE, поэтому
+// параллельные принятия не приведут к двойной оплате. Смена статуса и перевод выполняются
+// в одной транзакции: если перевод не прошел (не хватает монет, нарушена политика) или процесс
+// упал, запрос остается pending, а ошибка перевода возвращается вызывающему
+func (s *service) Accept(ctx context.Context, payerID, id int) (Request, error) {
+	const op = "invoice/service/Accept"
+	err := db.Retry(ctx, func() error {
+		return s.accept(ctx, payerID, id)
+	})
+	if err != nil {
+		return Request{}, err
+	}
+
+	request, err := s.get(ctx, id)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return request, nil
+}
+
+func (s *service) accept(ctx context.Context, payerID, id int) error {
+	const op = "invoice/service/Accept"
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var (
+		requester string
+		amount    int
+		note      string
+	)
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE payment_requests p SET status = 'accepted', resolved_at = now()
+				FROM users r
+				WHERE p.id = $1 AND p.payer_id = $2 AND p.status = 'pending' AND p.expires_at > now()
+				AND r.id = p.requester_id
+				RETURNING r.username, p.amount, p.note`,
+		id,
+		payerID,
+	).Scan(&requester, &amount, &note)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			tx.Rollback()
+			return s.claimError(ctx, id, "payer_id", payerID)
+		}
+		return fmt.Errorf("%v: unable to accept request: %w", op, err)
+	}
+
+	if err := s.coins.SendCoinTx(ctx, tx, payerID, requester, amount, coin.Memo{Message: note}); err != nil {
+		return fmt.Errorf("%v: %w", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+	return nil
+}
+
+func (s *service) Decline(ctx context.Context, payerID, id int) (Request, error) {
+	return s.resolve(ctx, "invoice/service/Decline", StatusDeclined, "payer_id", payerID, id)
+}
+
+func (s *service) Cancel(ctx context.Context, requesterID, id int) (Request, error) {
+	return s.resolve(ctx, "invoice/service/Cancel", StatusCancelled, "requester_id", requesterID, id)
+}
+
+// resolve Закрытие запроса без перевода; owner - колонка участника, которому это разрешено
+func (s *service) resolve(ctx context.Context, op, status, owner string, userID, id int) (Request, error) {
+	res, err := s.db.ExecContext(
+		ctx,
+		`UPDATE payment_requests SET status = $3, resolved_at = now()
+				WHERE id = $1 AND `+owner+` = $2 AND status = 'pending' AND expires_at > now()`,
+		id,
+		userID,
+		status,
+	)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: unable to update request: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Request{}, fmt.Errorf("%v: %w", op, err)
+	} else if n == 0 {
+		return Request{}, s.claimError(ctx, id, owner, userID)
+	}
+
+	request, err := s.get(ctx, id)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return request, nil
+}
+
+// claimError Причина, по которой запрос не удалось закрыть. Чужой запрос неотличим от несуществующего
+func (s *service) claimError(ctx context.Context, id int, owner string, userID int) error {
+	var status string
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT CASE WHEN status = 'pending' AND expires_at <= now() THEN 'expired' ELSE status END
+				FROM payment_requests WHERE id = $1 AND `+owner+` = $2`,
+		id,
+		userID,
+	).Scan(&status)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRequestNotFound
+	case err != nil:
+		return fmt.Errorf("unable to get request: %w", err)
+	case status == StatusExpired:
+		return ErrExpired
+	default:
+		return ErrNotPending
+	}
+}
+
+func (s *service) get(ctx context.Context, id int) (Request, error) {
+	request, err := scanRequest(s.db.QueryRowContext(ctx, selectRequest+` WHERE p.id = $1`, id))
+	if err != nil {
+		return Request{}, err
+	}
+	return request, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRequest(row scanner) (Request, error) {
+	var (
+		request    Request
+		resolvedAt sql.NullTime
+	)
+	err := row.Scan(
+		&request.ID,
+		&request.From,
+		&request.To,
+		&request.Amount,
+		&request.Note,
+		&request.Status,
+		&request.CreatedAt,
+		&request.ExpiresAt,
+		&resolvedAt,
+	)
+	if err != nil {
+		return Request{}, fmt.Errorf("unable to read request: %w", err)
+	}
+	if resolvedAt.Valid {
+		request.ResolvedAt = &resolvedAt.Time
+	}
+	return request, nil
+}
@@ -0,0 +1,90 @@
+package leaderboard
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultPeriod Рейтинг по умолчанию - за последние 30 дней, включая сегодняшний
+const defaultPeriod = 30
+
+type OptOutResponse struct {
+	OptOut bool `json:"optOut"`
+}
+
+// MakeGetHandler GET /api/leaderboard?metric=received|sent|thanked|spent&from=&to=&limit=
+// from и to - даты YYYY-MM-DD (UTC), обе включаются в период
+func MakeGetHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		q := Query{
+			Metric: query.Get("metric"),
+			From:   today.AddDate(0, 0, 1-defaultPeriod),
+			To:     today,
+		}
+		if q.Metric == "" {
+			q.Metric = MetricReceived
+		}
+		for name, dst := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+			if value := query.Get(name); value != "" {
+				t, err := time.Parse(time.DateOnly, value)
+				if err != nil {
+					http.Error(w, "Invalid "+name, http.StatusBadRequest)
+					return
+				}
+				*dst = t
+			}
+		}
+		if limit := query.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			q.Limit = n
+		}
+
+		board, err := s.Get(r.Context(), q)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, board)
+	}
+}
+
+// MakeOptOutHandler POST /api/leaderboard/opt-out скрывает пользователя из рейтинга (optOut = true),
+// DELETE - возвращает
+func MakeOptOutHandler(s Service, optOut bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		if err := s.SetOptOut(r.Context(), userID, optOut); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, OptOutResponse{OptOut: optOut})
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, ErrInvalidMetric),
+		errors.Is(err, ErrInvalidPeriod),
+		errors.Is(err, ErrInvalidLimit):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,24 @@
+package leaderboard
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Start Обновление агрегатов рейтинга с периодом interval до отмены ctx
+func Start(ctx context.Context, s Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Refresh(ctx); err != nil {
+				log.Printf("Leaderboard refresh failed: %v", err)
+			}
+		}
+	}
+}
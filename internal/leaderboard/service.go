@@ -0,0 +1,248 @@
+package leaderboard
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidMetric = errors.New("unknown metric")
+	ErrInvalidPeriod = errors.New("from must not be after to")
+	ErrInvalidLimit  = errors.New("limit must be positive")
+	ErrUserNotFound  = errors.New("user not found")
+)
+
+// Показатели рейтинга
+const (
+	MetricReceived = "received" // получено монет
+	MetricSent     = "sent"     // отправлено монет
+	MetricThanked  = "thanked"  // разных получателей переводов
+	MetricSpent    = "spent"    // потрачено на покупки
+)
+
+const (
+	defaultLimit = 10
+	maxLimit     = 100
+
+	// refreshBatchSize Сколько записей очереди обрабатывается в одной транзакции
+	refreshBatchSize = 10000
+)
+
+// metricQueries Значение показателя по пользователям за дни [$1, $2] из агрегатов
+var metricQueries = map[string]string{
+	MetricReceived: `SELECT recipient_id AS user_id, SUM(amount) AS value
+				FROM leaderboard_transfers WHERE day BETWEEN $1 AND $2 GROUP BY recipient_id`,
+	MetricSent: `SELECT sender_id AS user_id, SUM(amount) AS value
+				FROM leaderboard_transfers WHERE day BETWEEN $1 AND $2 GROUP BY sender_id`,
+	// Полностью отмененные переводы паре не засчитываются
+	MetricThanked: `SELECT sender_id AS user_id, COUNT(*) AS value FROM (
+					SELECT sender_id, recipient_id FROM leaderboard_transfers WHERE day BETWEEN $1 AND $2
+					GROUP BY sender_id, recipient_id HAVING SUM(amount) > 0
+				) p GROUP BY sender_id`,
+	MetricSpent: `SELECT user_id, SUM(amount) AS value
+				FROM leaderboard_spending WHERE day BETWEEN $1 AND $2 GROUP BY user_id`,
+}
+
+// Query Рейтинг по показателю Metric за дни с From по To включительно (UTC)
+type Query struct {
+	Metric string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+type Entry struct {
+	Rank     int    `json:"rank"`
+	Username string `json:"username"`
+	Value    int    `json:"value"`
+}
+
+// Board Рейтинг; RefreshedAt - время последнего обновления агрегатов, более новые операции в нем еще не учтены
+type Board struct {
+	Metric      string     `json:"metric"`
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	RefreshedAt *time.Time `json:"refreshedAt,omitempty"`
+	Entries     []Entry    `json:"entries"`
+}
+
+// Service Рейтинг пользователей по переводам и покупкам. Строится по дневным агрегатам,
+// которые Refresh дополняет новыми записями transactions из очереди, а не пересчитывает заново
+type Service interface {
+	Get(ctx context.Context, q Query) (Board, error)
+	Refresh(ctx context.Context) (int, error)
+	SetOptOut(ctx context.Context, userID int, optOut bool) error
+}
+
+type service struct {
+	db *sql.DB
+}
+
+func NewLeaderboardService(db *sql.DB) Service {
+	return &service{db: db}
+}
+
+// Get Пользователи, скрывшие себя из рейтинга, в нем не показываются и не занимают места.
+// Одинаковые значения делят одно место
+func (s *service) Get(ctx context.Context, q Query) (Board, error) {
+	const op = "leaderboard/service/Get"
+	query, ok := metricQueries[q.Metric]
+	if !ok {
+		return Board{}, fmt.Errorf("%w: %s", ErrInvalidMetric, q.Metric)
+	}
+	if q.To.Before(q.From) {
+		return Board{}, ErrInvalidPeriod
+	}
+	limit := q.Limit
+	switch {
+	case limit < 0:
+		return Board{}, ErrInvalidLimit
+	case limit == 0:
+		limit = defaultLimit
+	case limit > maxLimit:
+		limit = maxLimit
+	}
+
+	board := Board{
+		Metric:  q.Metric,
+		From:    q.From.Format(time.DateOnly),
+		To:      q.To.Format(time.DateOnly),
+		Entries: []Entry{},
+	}
+	var refreshedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT refreshed_at FROM leaderboard_state").Scan(&refreshedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Board{}, fmt.Errorf("%v: unable to read leaderboard state: %w", op, err)
+	}
+	if refreshedAt.Valid {
+		board.RefreshedAt = &refreshedAt.Time
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT RANK() OVER (ORDER BY m.value DESC), u.username, m.value
+				FROM (`+query+`) m
+				JOIN users u ON u.id = m.user_id
+				WHERE m.value > 0 AND NOT u.leaderboard_opt_out
+				ORDER BY m.value DESC, u.username
+				LIMIT $3`,
+		board.From,
+		board.To,
+		limit,
+	)
+	if err != nil {
+		return Board{}, fmt.Errorf("%v: unable to get leaderboard: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.Rank, &e.Username, &e.Value); err != nil {
+			return Board{}, fmt.Errorf("%v: unable to read leaderboard: %w", op, err)
+		}
+		board.Entries = append(board.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return Board{}, fmt.Errorf("%v: unable to read leaderboard: %w", op, err)
+	}
+	return board, nil
+}
+
+// Refresh Добавляет в агрегаты записи transactions из leaderboard_queue и возвращает их число.
+// Очередь заполняет триггер при вставке записи, и ее строка видна только после фиксации,
+// поэтому записи долгих транзакций не теряются. Отмены и возвраты вычитаются из дня
+// исходного перевода или покупки. Очередь разбирается пачками по refreshBatchSize
+func (s *service) Refresh(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := s.refreshBatch(ctx)
+		total += n
+		if err != nil || n < refreshBatchSize {
+			return total, err
+		}
+	}
+}
+
+// refreshBatch Строка leaderboard_state берется с SKIP LOCKED, поэтому при одновременном запуске
+// на нескольких репликах очередь разбирает одна, а остальные сразу возвращают 0
+func (s *service) refreshBatch(ctx context.Context) (int, error) {
+	const op = "leaderboard/service/Refresh"
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	err = tx.QueryRowContext(ctx, "SELECT TRUE FROM leaderboard_state FOR UPDATE SKIP LOCKED").Scan(&locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%v: unable to lock leaderboard state: %w", op, err)
+	}
+
+	// Изменяющие CTE выполняются всегда, поэтому пачка удаляется из очереди и попадает
+	// в оба агрегата одним запросом
+	var count int
+	err = tx.QueryRowContext(
+		ctx,
+		`WITH batch AS (
+					DELETE FROM leaderboard_queue
+					WHERE transaction_id IN (SELECT transaction_id FROM leaderboard_queue ORDER BY transaction_id LIMIT $1)
+					RETURNING transaction_id
+				), batch_rows AS (
+					SELECT t.user_id, t.type, t.amount, t.counterparty,
+						(COALESCE(o.created_at, t.created_at) AT TIME ZONE 'UTC')::date AS day
+					FROM batch b
+					JOIN transactions t ON t.id = b.transaction_id
+					LEFT JOIN transactions o ON o.id = t.reverses_id
+				), transfers AS (
+					INSERT INTO leaderboard_transfers (day, sender_id, recipient_id, amount)
+					SELECT r.day, r.user_id, c.id, SUM(CASE WHEN r.type = 'sent' THEN r.amount ELSE -r.amount END)
+					FROM batch_rows r
+					JOIN users c ON c.username = r.counterparty
+					WHERE r.type IN ('sent', 'reversal_credit')
+					GROUP BY 1, 2, 3
+					ON CONFLICT (day, sender_id, recipient_id)
+					DO UPDATE SET amount = leaderboard_transfers.amount + EXCLUDED.amount
+				), spending AS (
+					INSERT INTO leaderboard_spending (day, user_id, amount)
+					SELECT r.day, r.user_id, SUM(CASE WHEN r.type = 'purchased' THEN r.amount ELSE -r.amount END)
+					FROM batch_rows r
+					WHERE r.type IN ('purchased', 'refund')
+					GROUP BY 1, 2
+					ON CONFLICT (day, user_id)
+					DO UPDATE SET amount = leaderboard_spending.amount + EXCLUDED.amount
+				)
+				SELECT COUNT(*) FROM batch`,
+		refreshBatchSize,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("%v: unable to aggregate transactions: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE leaderboard_state SET refreshed_at = now()")
+	if err != nil {
+		return 0, fmt.Errorf("%v: unable to save leaderboard state: %w", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+	return count, nil
+}
+
+func (s *service) SetOptOut(ctx context.Context, userID int, optOut bool) error {
+	const op = "leaderboard/service/SetOptOut"
+	res, err := s.db.ExecContext(ctx, "UPDATE users SET leaderboard_opt_out = $2 WHERE id = $1", userID, optOut)
+	if err != nil {
+		return fmt.Errorf("%v: unable to update user: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUnbalanced  = errors.New("postings don't sum to zero")
+	ErrNoPostings  = errors.New("journal entry has no postings")
+	ErrZeroPosting = errors.New("posting amount can't be zero")
+)
+
+// Системные счета
+const (
+	AccountMint        = "mint"         // Эмиссия: начальные балансы и начисления администратором
+	AccountShopRevenue = "shop_revenue" // Выручка магазина от покупок мерча
+	AccountEscrow      = "escrow"       // Удержанные монеты до перевода получателю или возврата
+)
+
+// Типы записей журнала
+const (
+	EntryOpening    = "opening"
+	EntryTransfer   = "transfer"
+	EntryPurchase   = "purchase"
+	EntryGrant      = "grant"
+	EntryDeduction  = "deduction"
+	EntryAdjustment = "adjustment"
+	EntryHold       = "hold"
+	EntryRelease    = "hold_release"
+	EntryCapture    = "hold_capture"
+	EntryReversal   = "reversal"
+	EntryRefund     = "refund"
+)
+
+// Posting Проводка по счету пользователя (UserID) или системному счету (System).
+// Положительная сумма увеличивает баланс счета
+type Posting struct {
+	UserID int
+	System string
+	Amount int
+}
+
+// User Проводка по счету пользователя
+func User(userID, amount int) Posting {
+	return Posting{UserID: userID, Amount: amount}
+}
+
+// System Проводка по системному счету
+func System(code string, amount int) Posting {
+	return Posting{System: code, Amount: amount}
+}
+
+// Post Запись в журнал внутри транзакции вызывающего. Сумма проводок должна быть равна нулю;
+// то же самое проверяет отложенный триггер базы при коммите. Возвращает id записи журнала
+func Post(ctx context.Context, tx *sql.Tx, entryType, description string, postings ...Posting) (int64, error) {
+	if len(postings) == 0 {
+		return 0, ErrNoPostings
+	}
+
+	sum := 0
+	userIDs := make([]int64, 0, len(postings))
+	codes := make([]string, 0, len(postings))
+	amounts := make([]int64, 0, len(postings))
+	for _, p := range postings {
+		if p.Amount == 0 {
+			return 0, ErrZeroPosting
+		}
+		sum += p.Amount
+		userIDs = append(userIDs, int64(p.UserID))
+		codes = append(codes, p.System)
+		amounts = append(amounts, int64(p.Amount))
+	}
+	if sum != 0 {
+		return 0, ErrUnbalanced
+	}
+
+	var entryID int64
+	err := tx.QueryRowContext(
+		ctx,
+		`INSERT INTO journal_entries (type, description) VALUES ($1, $2) RETURNING id`,
+		entryType,
+		description,
+	).Scan(&entryID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to create journal entry: %w", err)
+	}
+
+	// Счет пользователя создается триггером при регистрации; отсутствие счета нарушит NOT NULL
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO postings (entry_id, account_id, amount)
+				SELECT $1,
+					CASE WHEN p.user_id > 0
+						THEN (SELECT id FROM ledger_accounts WHERE user_id = p.user_id)
+						ELSE ledger_system_account(p.code)
+					END,
+					p.amount
+				FROM unnest($2::int[], $3::text[], $4::int[]) AS p(user_id, code, amount)`,
+		entryID,
+		pq.Array(userIDs),
+		pq.Array(codes),
+		pq.Array(amounts),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("unable to write postings: %w", err)
+	}
+	return entryID, nil
+}
+
+// Balance Баланс пользователя по проводкам
+func Balance(ctx context.Context, db *sql.DB, userID int) (int, error) {
+	const op = "ledger/Balance"
+	var balance int
+	err := db.QueryRowContext(
+		ctx,
+		"SELECT balance FROM ledger_balances WHERE user_id = $1",
+		userID,
+	).Scan(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("%v: unable to get balance: %w", op, err)
+	}
+	return balance, nil
+}
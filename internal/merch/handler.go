@@ -1,10 +1,24 @@
 package merch
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 )
 
+type CreateItemRequest struct {
+	Slug        string `json:"slug"`
+	Title       string `json:"title"`
+	Price       int    `json:"price"`
+	Active      *bool  `json:"active"`
+	Description string `json:"description"`
+	Stock       *int   `json:"stock"`
+}
+
+type RestockRequest struct {
+	Quantity int `json:"quantity"`
+}
+
 func MakeBuyHandler(s Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		item := r.PathValue("item")
@@ -21,6 +35,8 @@ func MakeBuyHandler(s Service) http.HandlerFunc {
 				http.Error(w, "Not enough coins", http.StatusBadRequest)
 			case errors.Is(err, ErrItemNotFound):
 				http.Error(w, "Item not found", http.StatusBadRequest)
+			case errors.Is(err, ErrSoldOut):
+				http.Error(w, "Item is sold out", http.StatusConflict)
 			default:
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
@@ -31,3 +47,117 @@ func MakeBuyHandler(s Service) http.HandlerFunc {
 		w.Write([]byte("Item purchased successfully"))
 	}
 }
+
+// MakeListHandler Список товаров; includeRetired используется для административного просмотра
+func MakeListHandler(s Service, includeRetired bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := s.ListItems(r.Context(), includeRetired)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+func MakeCreateItemHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateItemRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		active := true
+		if req.Active != nil {
+			active = *req.Active
+		}
+		item, err := s.CreateItem(r.Context(), Item{
+			Slug:        req.Slug,
+			Title:       req.Title,
+			Price:       req.Price,
+			Active:      active,
+			Description: req.Description,
+			Stock:       req.Stock,
+		})
+		if err != nil {
+			writeCatalogError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(item)
+	}
+}
+
+func MakeUpdateItemHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ItemUpdate
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		item, err := s.UpdateItem(r.Context(), r.PathValue("slug"), req)
+		if err != nil {
+			writeCatalogError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(item)
+	}
+}
+
+func MakeRetireItemHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.RetireItem(r.Context(), r.PathValue("slug")); err != nil {
+			writeCatalogError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Item retired successfully"))
+	}
+}
+
+func MakeRestockHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RestockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		item, err := s.Restock(r.Context(), r.PathValue("slug"), req.Quantity)
+		if err != nil {
+			writeCatalogError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(item)
+	}
+}
+
+func writeCatalogError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrItemNotFound):
+		http.Error(w, "Item not found", http.StatusNotFound)
+	case errors.Is(err, ErrItemExists):
+		http.Error(w, "Item already exists", http.StatusConflict)
+	case errors.Is(err, ErrInvalidSlug),
+		errors.Is(err, ErrInvalidTitle),
+		errors.Is(err, ErrInvalidPrice),
+		errors.Is(err, ErrInvalidDesc),
+		errors.Is(err, ErrInvalidStock),
+		errors.Is(err, ErrInvalidQuantity),
+		errors.Is(err, ErrNotLimited):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
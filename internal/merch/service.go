@@ -6,28 +6,64 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"strings"
+
+	"Avito-trainee/internal/ledger"
+
+	"github.com/lib/pq"
 )
 
 var (
 	ErrItemNotFound      = errors.New("can't find the product")
+	ErrSoldOut           = errors.New("item is sold out")
+	ErrNotLimited        = errors.New("item has unlimited stock")
+	ErrInvalidQuantity   = errors.New("quantity must be positive")
+	ErrInvalidStock      = errors.New("stock can't be negative")
 	ErrInsufficientCoins = errors.New("not enough coins")
+	ErrItemExists        = errors.New("item with this slug already exists")
+	ErrInvalidSlug       = errors.New("slug must be 1-50 characters: lowercase latin letters, digits and '-'")
+	ErrInvalidTitle      = errors.New("title must be 1-100 characters long")
+	ErrInvalidPrice      = errors.New("price must be positive")
+	ErrInvalidDesc       = errors.New("description must be at most 1000 characters long")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9-]{1,50}$`)
+
+const (
+	maxTitleLength       = 100
+	maxDescriptionLength = 1000
 )
 
-var itemPrices = map[string]int{
-	"t-shirt":    80,
-	"cup":        20,
-	"book":       50,
-	"pen":        10,
-	"powerbank":  200,
-	"hoody":      300,
-	"umbrella":   200,
-	"socks":      10,
-	"wallet":     50,
-	"pink-hoody": 500,
+// Item Товар каталога. Stock == nil означает неограниченный запас
+type Item struct {
+	Slug        string `json:"slug"`
+	Title       string `json:"title"`
+	Price       int    `json:"price"`
+	Active      bool   `json:"active"`
+	Description string `json:"description"`
+	Stock       *int   `json:"stock"`
+	Available   bool   `json:"available"`
+}
+
+// ItemUpdate Частичное изменение товара: nil-поля не меняются.
+// UnlimitedStock снимает ограничение запаса и имеет приоритет над Stock
+type ItemUpdate struct {
+	Title          *string `json:"title"`
+	Price          *int    `json:"price"`
+	Active         *bool   `json:"active"`
+	Description    *string `json:"description"`
+	Stock          *int    `json:"stock"`
+	UnlimitedStock bool    `json:"unlimitedStock"`
 }
 
 type Service interface {
 	BuyItem(ctx context.Context, userID int, item string) error
+	ListItems(ctx context.Context, includeRetired bool) ([]Item, error)
+	CreateItem(ctx context.Context, item Item) (Item, error)
+	UpdateItem(ctx context.Context, slug string, upd ItemUpdate) (Item, error)
+	RetireItem(ctx context.Context, slug string) error
+	Restock(ctx context.Context, slug string, quantity int) (Item, error)
 }
 
 type service struct {
@@ -39,11 +75,6 @@ func NewMerchService(db *sql.DB) Service {
 }
 func (s *service) BuyItem(ctx context.Context, userID int, item string) error {
 	const op = "merch/service/BuyItem"
-	price, ok := itemPrices[item]
-	if !ok {
-		return ErrItemNotFound
-	}
-
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("Error starting transaction: %v", err)
@@ -51,10 +82,38 @@ func (s *service) BuyItem(ctx context.Context, userID int, item string) error {
 	}
 	defer tx.Rollback()
 
+	// Цена товара без ограничения запаса читается в транзакции и фиксируется до ее завершения (FOR SHARE),
+	// поэтому параллельное изменение цены не повлияет на покупку. Товар с запасом так не блокируется:
+	// его строку меняет ReserveStock, и повышение FOR SHARE до записи у двух покупателей привело бы
+	// к взаимоблокировке. Его цена берется из строки, заблокированной условным UPDATE в ReserveStock
+	var (
+		price int
+		stock sql.NullInt64
+	)
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT price, stock FROM merch_items WHERE slug = $1 AND active AND stock IS NULL FOR SHARE`,
+		item,
+	).Scan(&price, &stock)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(
+			ctx,
+			`SELECT price, stock FROM merch_items WHERE slug = $1 AND active`,
+			item,
+		).Scan(&price, &stock)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrItemNotFound
+		}
+		log.Printf("Unable to read price: %v", err)
+		return fmt.Errorf("%v: unable to read price: %w", op, err)
+	}
+
 	var coins int
 	err = tx.QueryRowContext(
 		ctx,
-		`SELECT coins FROM users WHERE id = $1`,
+		`SELECT coins FROM users WHERE id = $1 FOR UPDATE`,
 		userID,
 	).Scan(&coins)
 	if err != nil {
@@ -71,6 +130,20 @@ func (s *service) BuyItem(ctx context.Context, userID int, item string) error {
 		return ErrInsufficientCoins
 	}
 
+	if stock.Valid {
+		price, err = ReserveStock(ctx, tx, item, 1)
+		if err != nil {
+			if errors.Is(err, ErrSoldOut) {
+				return ErrSoldOut
+			}
+			log.Printf("Stock error: %v", err)
+			return fmt.Errorf("%v: %w", op, err)
+		}
+		if coins < price {
+			return ErrInsufficientCoins
+		}
+	}
+
 	// Списание монет
 	_, err = tx.ExecContext(
 		ctx,
@@ -83,15 +156,29 @@ func (s *service) BuyItem(ctx context.Context, userID int, item string) error {
 		return fmt.Errorf("%v: debit error: %w", op, err)
 	}
 
+	entryID, err := ledger.Post(
+		ctx,
+		tx,
+		ledger.EntryPurchase,
+		item,
+		ledger.User(userID, -price),
+		ledger.System(ledger.AccountShopRevenue, price),
+	)
+	if err != nil {
+		log.Printf("Ledger error: %v", err)
+		return fmt.Errorf("%v: ledger error: %w", op, err)
+	}
+
 	// Запись транзакции
 	_, err = tx.ExecContext(
 		ctx,
-		`INSERT INTO transactions (user_id, type, merch, amount) 
-				VALUES ($1, $2, $3, $4);`,
+		`INSERT INTO transactions (user_id, type, merch, amount, entry_id) 
+				VALUES ($1, $2, $3, $4, $5);`,
 		userID,
 		"purchased",
 		item,
 		price,
+		entryID,
 	)
 	if err != nil {
 		log.Printf("Transaction error: %v", err)
@@ -115,3 +202,235 @@ func (s *service) BuyItem(ctx context.Context, userID int, item string) error {
 
 	return tx.Commit()
 }
+
+// ReserveStock Атомарное резервирование запаса: условный UPDATE блокирует строку товара
+// до конца транзакции, поэтому параллельные покупки не продадут больше, чем есть.
+// Возвращает цену из заблокированной строки
+func ReserveStock(ctx context.Context, tx *sql.Tx, slug string, quantity int) (int, error) {
+	var price int
+	err := tx.QueryRowContext(
+		ctx,
+		`UPDATE merch_items SET stock = stock - $2
+				WHERE slug = $1 AND active AND stock >= $2
+				RETURNING price`,
+		slug,
+		quantity,
+	).Scan(&price)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrSoldOut
+		}
+		return 0, fmt.Errorf("unable to reserve stock: %w", err)
+	}
+	return price, nil
+}
+
+// ListItems Список товаров каталога; снятые с продажи включаются только по запросу
+func (s *service) ListItems(ctx context.Context, includeRetired bool) ([]Item, error) {
+	const op = "merch/service/ListItems"
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT slug, title, price, active, description, stock FROM merch_items
+				WHERE active OR $1
+				ORDER BY price, slug`,
+		includeRetired,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%v: unable to get items: %w", op, err)
+	}
+	defer rows.Close()
+
+	items := []Item{}
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.Slug, &item.Title, &item.Price, &item.Active, &item.Description, &item.Stock); err != nil {
+			return nil, fmt.Errorf("%v: unable to read item: %w", op, err)
+		}
+		item.Available = isAvailable(item)
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%v: unable to read items: %w", op, err)
+	}
+	return items, nil
+}
+
+// CreateItem Добавление товара в каталог
+func (s *service) CreateItem(ctx context.Context, item Item) (Item, error) {
+	const op = "merch/service/CreateItem"
+	item.Title = strings.TrimSpace(item.Title)
+	if !slugPattern.MatchString(item.Slug) {
+		return Item{}, ErrInvalidSlug
+	}
+	if err := validateItem(item); err != nil {
+		return Item{}, err
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO merch_items (slug, title, price, active, description, stock) VALUES ($1, $2, $3, $4, $5, $6)`,
+		item.Slug,
+		item.Title,
+		item.Price,
+		item.Active,
+		item.Description,
+		item.Stock,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return Item{}, ErrItemExists
+		}
+		return Item{}, fmt.Errorf("%v: unable to create item: %w", op, err)
+	}
+	item.Available = isAvailable(item)
+	return item, nil
+}
+
+// UpdateItem Изменение товара. Уже начатые покупки завершатся по старой цене
+func (s *service) UpdateItem(ctx context.Context, slug string, upd ItemUpdate) (Item, error) {
+	const op = "merch/service/UpdateItem"
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Item{}, fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var item Item
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT slug, title, price, active, description, stock FROM merch_items WHERE slug = $1 FOR UPDATE`,
+		slug,
+	).Scan(&item.Slug, &item.Title, &item.Price, &item.Active, &item.Description, &item.Stock)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Item{}, ErrItemNotFound
+		}
+		return Item{}, fmt.Errorf("%v: unable to find item: %w", op, err)
+	}
+
+	if upd.Title != nil {
+		item.Title = strings.TrimSpace(*upd.Title)
+	}
+	if upd.Price != nil {
+		item.Price = *upd.Price
+	}
+	if upd.Active != nil {
+		item.Active = *upd.Active
+	}
+	if upd.Description != nil {
+		item.Description = *upd.Description
+	}
+	if upd.UnlimitedStock {
+		item.Stock = nil
+	} else if upd.Stock != nil {
+		item.Stock = upd.Stock
+	}
+	if err := validateItem(item); err != nil {
+		return Item{}, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE merch_items SET title = $1, price = $2, active = $3, description = $4, stock = $5, updated_at = now()
+				WHERE slug = $6`,
+		item.Title,
+		item.Price,
+		item.Active,
+		item.Description,
+		item.Stock,
+		slug,
+	)
+	if err != nil {
+		return Item{}, fmt.Errorf("%v: unable to update item: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Item{}, fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+	item.Available = isAvailable(item)
+	return item, nil
+}
+
+// RetireItem Снятие товара с продажи. Товар остается в каталоге и в инвентаре покупателей
+func (s *service) RetireItem(ctx context.Context, slug string) error {
+	const op = "merch/service/RetireItem"
+	res, err := s.db.ExecContext(
+		ctx,
+		`UPDATE merch_items SET active = FALSE, updated_at = now() WHERE slug = $1`,
+		slug,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to retire item: %w", op, err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%v: unable to retire item: %w", op, err)
+	}
+	if affected == 0 {
+		return ErrItemNotFound
+	}
+	return nil
+}
+
+// Restock Пополнение запаса товара с ограниченным количеством
+func (s *service) Restock(ctx context.Context, slug string, quantity int) (Item, error) {
+	const op = "merch/service/Restock"
+	if quantity <= 0 {
+		return Item{}, ErrInvalidQuantity
+	}
+
+	var item Item
+	err := s.db.QueryRowContext(
+		ctx,
+		`UPDATE merch_items SET stock = stock + $2, updated_at = now()
+				WHERE slug = $1 AND stock IS NOT NULL
+				RETURNING slug, title, price, active, description, stock`,
+		slug,
+		quantity,
+	).Scan(&item.Slug, &item.Title, &item.Price, &item.Active, &item.Description, &item.Stock)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return Item{}, fmt.Errorf("%v: unable to restock item: %w", op, err)
+		}
+
+		// Различаем отсутствующий товар и товар без ограничения запаса
+		var exists bool
+		err = s.db.QueryRowContext(
+			ctx,
+			`SELECT EXISTS (SELECT 1 FROM merch_items WHERE slug = $1)`,
+			slug,
+		).Scan(&exists)
+		if err != nil {
+			return Item{}, fmt.Errorf("%v: unable to find item: %w", op, err)
+		}
+		if exists {
+			return Item{}, ErrNotLimited
+		}
+		return Item{}, ErrItemNotFound
+	}
+
+	item.Available = isAvailable(item)
+	return item, nil
+}
+
+// isAvailable Товар можно купить: он в продаже и запас не исчерпан
+func isAvailable(item Item) bool {
+	return item.Active && (item.Stock == nil || *item.Stock > 0)
+}
+
+func validateItem(item Item) error {
+	if item.Title == "" || len([]rune(item.Title)) > maxTitleLength {
+		return ErrInvalidTitle
+	}
+	if item.Price <= 0 {
+		return ErrInvalidPrice
+	}
+	if len([]rune(item.Description)) > maxDescriptionLength {
+		return ErrInvalidDesc
+	}
+	if item.Stock != nil && *item.Stock < 0 {
+		return ErrInvalidStock
+	}
+	return nil
+}
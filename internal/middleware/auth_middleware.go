@@ -6,10 +6,10 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/golang-jwt/jwt"
+	"Avito-trainee/internal/auth"
 )
 
-func JWTAuthMiddleware(db *sql.DB, secretKey string) func(next http.Handler) http.Handler {
+func JWTAuthMiddleware(db *sql.DB, jwtManager *auth.JWTManager) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -19,29 +19,31 @@ func JWTAuthMiddleware(db *sql.DB, secretKey string) func(next http.Handler) htt
 			}
 
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				return []byte(secretKey), nil
-			})
-			if err != nil || !token.Valid {
+			claims, err := jwtManager.Verify(tokenString)
+			if err != nil || claims.Subject == "" || claims.Id == "" {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			username := claims["sub"].(string)
-			var userID int
-			err = db.QueryRowContext(r.Context(), "SELECT id FROM users WHERE username = $1", username).Scan(&userID)
-			if err != nil {
+			// Роль берется из базы, а не из токена: понижение прав действует сразу
+			var (
+				userID  int
+				role    string
+				revoked bool
+			)
+			err = db.QueryRowContext(
+				r.Context(),
+				"SELECT id, role, EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $2) FROM users WHERE username = $1",
+				claims.Subject,
+				claims.Id,
+			).Scan(&userID, &role, &revoked)
+			if err != nil || revoked {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
 			ctx := context.WithValue(r.Context(), "userID", userID)
+			ctx = context.WithValue(ctx, "role", role)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
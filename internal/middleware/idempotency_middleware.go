@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayHeader Выставляется в ответе, если он взят из сохраненного результата
+	IdempotentReplayHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	maxIdempotentBodySize   = 1 << 20
+
+	saveAttempts   = 3
+	saveRetryDelay = 50 * time.Millisecond
+)
+
+// Idempotency Дедупликация повторов запросов с заголовком Idempotency-Key.
+// Первый ответ сохраняется для пары (пользователь, ключ) на время ttl и отдается на повтор
+// с тем же запросом; повтор с другим телом или пока первый запрос выполняется получает 409.
+// Ответы 5xx не сохраняются, чтобы клиент мог повторить запрос. Запросы без заголовка
+// обрабатываются как обычно. Должен подключаться после JWTAuthMiddleware
+func Idempotency(db *sql.DB, ttl time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(w, "Idempotency key is too long", http.StatusBadRequest)
+				return
+			}
+
+			userID := r.Context().Value("userID").(int)
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBodySize+1))
+			if err != nil || len(body) > maxIdempotentBodySize {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			hash := requestHash(r, body)
+
+			// Ключ захватывается вставкой; просроченная запись перезаписывается
+			var claimed bool
+			err = db.QueryRowContext(
+				r.Context(),
+				`INSERT INTO idempotency_keys (user_id, key, request_hash, expires_at)
+						VALUES ($1, $2, $3, now() + make_interval(secs => $4))
+						ON CONFLICT (user_id, key) DO UPDATE SET
+						request_hash = EXCLUDED.request_hash,
+						status_code = NULL,
+						content_type = '',
+						response_body = NULL,
+						created_at = now(),
+						expires_at = EXCLUDED.expires_at
+						WHERE idempotency_keys.expires_at <= now()
+						RETURNING true`,
+				userID,
+				key,
+				hash,
+				ttl.Seconds(),
+			).Scan(&claimed)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Idempotency claim error: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !claimed {
+				replay(w, r, db, userID, key, hash)
+				return
+			}
+
+			// Результат сохраняется, даже если клиент уже отключился
+			ctx := context.WithoutCancel(r.Context())
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			completed := false
+			defer func() {
+				// Ключ освобождается, если обработчик упал или вернул 5xx
+				if completed && rec.status < http.StatusInternalServerError {
+					return
+				}
+				_, err := db.ExecContext(
+					ctx,
+					"DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2",
+					userID,
+					key,
+				)
+				if err != nil {
+					log.Printf("Idempotency release error: %v", err)
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+			completed = true
+			if rec.status >= http.StatusInternalServerError {
+				return
+			}
+
+			// Операция уже выполнена, поэтому ключ не освобождается, даже если ответ не сохранился:
+			// повтор получит 409 до истечения ttl, но не выполнит операцию второй раз
+			if err := saveResponse(ctx, db, userID, key, rec); err != nil {
+				log.Printf("Idempotency save error, key %q stays in progress: %v", key, err)
+			}
+		})
+	}
+}
+
+// saveResponse Сохранение ответа с несколькими попытками при временных ошибках базы
+func saveResponse(ctx context.Context, db *sql.DB, userID int, key string, rec *responseRecorder) error {
+	var err error
+	for attempt := 1; attempt <= saveAttempts; attempt++ {
+		_, err = db.ExecContext(
+			ctx,
+			`UPDATE idempotency_keys SET status_code = $3, content_type = $4, response_body = $5
+					WHERE user_id = $1 AND key = $2`,
+			userID,
+			key,
+			rec.status,
+			rec.Header().Get("Content-Type"),
+			rec.body.Bytes(),
+		)
+		if err == nil {
+			return nil
+		}
+		if attempt < saveAttempts {
+			time.Sleep(time.Duration(attempt) * saveRetryDelay)
+		}
+	}
+	return err
+}
+
+// replay Ответ на повтор запроса с уже занятым ключом
+func replay(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int, key, hash string) {
+	var (
+		storedHash  string
+		status      sql.NullInt64
+		contentType string
+		body        []byte
+	)
+	err := db.QueryRowContext(
+		r.Context(),
+		`SELECT request_hash, status_code, content_type, response_body
+				FROM idempotency_keys WHERE user_id = $1 AND key = $2`,
+		userID,
+		key,
+	).Scan(&storedHash, &status, &contentType, &body)
+	if err != nil {
+		// Запись могла быть удалена после ошибки первого запроса: клиенту стоит повторить
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Request with this idempotency key is in progress", http.StatusConflict)
+			return
+		}
+		log.Printf("Idempotency lookup error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case storedHash != hash:
+		http.Error(w, "Idempotency key was used with a different request", http.StatusConflict)
+	case !status.Valid:
+		http.Error(w, "Request with this idempotency key is in progress", http.StatusConflict)
+	default:
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set(IdempotentReplayHeader, "true")
+		w.WriteHeader(int(status.Int64))
+		w.Write(body)
+	}
+}
+
+// requestHash Отпечаток запроса: метод, путь и тело
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder Передает ответ клиенту и запоминает его для сохранения
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+)
+
+// RequireRole Пропускает запрос, только если роль пользователя входит в список.
+// Должен подключаться после JWTAuthMiddleware
+func RequireRole(roles ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, _ := r.Context().Value("role").(string)
+			if !slices.Contains(roles, role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,15 +1,70 @@
 package models
 
 import (
+	"sort"
 	"time"
 )
 
+// Типы записей в истории транзакций
+const (
+	TransactionSent           = "sent"
+	TransactionReceived       = "received"
+	TransactionPurchased      = "purchased"
+	TransactionGrant          = "grant"           // Начисление администратором
+	TransactionDeduction      = "deduction"       // Списание администратором
+	TransactionAdjustment     = "adjustment"      // Корректировка баланса, amount со знаком
+	TransactionHold           = "hold"            // Удержание монет
+	TransactionHoldRelease    = "hold_released"   // Возврат удержанных монет, в том числе перед переводом получателю
+	TransactionReversalDebit  = "reversal_debit"  // Списание у получателя при отмене перевода
+	TransactionReversalCredit = "reversal_credit" // Возврат отправителю при отмене перевода
+	TransactionRefund         = "refund"          // Возврат монет за покупку
+)
+
+// TransactionSigns Знак, с которым сумма записи истории входит в баланс пользователя.
+// Сумма adjustment уже со знаком
+var TransactionSigns = map[string]int{
+	TransactionSent:           -1,
+	TransactionReceived:       1,
+	TransactionPurchased:      -1,
+	TransactionGrant:          1,
+	TransactionDeduction:      -1,
+	TransactionAdjustment:     1,
+	TransactionHold:           -1,
+	TransactionHoldRelease:    1,
+	TransactionReversalDebit:  -1,
+	TransactionReversalCredit: 1,
+	TransactionRefund:         1,
+}
+
+// SignedTypes Типы записей и их знаки в виде параллельных массивов, отсортированных по типу,
+// для передачи в запрос через unnest
+func SignedTypes() ([]string, []int64) {
+	types := make([]string, 0, len(TransactionSigns))
+	for t := range TransactionSigns {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	signs := make([]int64, 0, len(types))
+	for _, t := range types {
+		signs = append(signs, int64(TransactionSigns[t]))
+	}
+	return types, signs
+}
+
 type Transaction struct {
 	ID           int       `json:"id"`
 	UserID       int       `json:"user_id"`
-	Type         string    `json:"type"`                   // "sent", "received", "purchased"
-	Counterparty string    `json:"counterparty,omitempty"` // Имя контрагента (если применимо)
+	Type         string    `json:"type"`                   // см. константы Transaction*
+	Counterparty string    `json:"counterparty,omitempty"` // Имя контрагента или администратора (если применимо)
 	Merch        string    `json:"merch,omitempty"`        // Название товара (если применимо)
+	Quantity     int       `json:"quantity,omitempty"`     // Количество товара в покупке
+	OrderID      *int      `json:"order_id,omitempty"`     // Заказ, если покупка оформлена через корзину
 	Amount       int       `json:"amount"`
+	Reason       string    `json:"reason,omitempty"`      // Основание для административных операций
+	EntryID      *int64    `json:"entry_id,omitempty"`    // Запись журнала двойной записи
+	Message      string    `json:"message,omitempty"`     // Сообщение к переводу
+	Tags         []string  `json:"tags,omitempty"`        // Теги перевода
+	HoldID       *int      `json:"hold_id,omitempty"`     // Удержание для hold и hold_released
+	ReversesID   *int      `json:"reverses_id,omitempty"` // Исходная запись для reversal_debit, reversal_credit и refund
 	CreatedAt    time.Time `json:"created_at"`
 }
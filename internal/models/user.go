@@ -4,10 +4,30 @@ import (
 	"time"
 )
 
+// Роли пользователей
+const (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RoleAuditor = "auditor"
+)
+
+// InitialCoins Стартовый баланс нового пользователя
+const InitialCoins = 1000
+
 type User struct {
 	ID           int       `json:"id"`
 	Username     string    `json:"username"`
 	PasswordHash string    `json:"-"`
 	Coins        int       `json:"coins"`
+	Role         string    `json:"role"`
 	CreatedAt    time.Time `json:"created_at"`
 }
+
+// IsValidRole Проверка, что роль входит в список известных
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleAdmin, RoleAuditor:
+		return true
+	}
+	return false
+}
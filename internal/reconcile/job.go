@@ -0,0 +1,38 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Schedule Периодическая сверка в фоне до отмены ctx.
+// Отчет пишется в лог в формате JSON, если найдены расхождения
+func Schedule(ctx context.Context, s Service, interval time.Duration, fix bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := s.Run(ctx, fix)
+			if err != nil {
+				log.Printf("Reconciliation failed: %v", err)
+				continue
+			}
+			if len(report.Mismatches) == 0 {
+				continue
+			}
+
+			data, err := json.Marshal(report)
+			if err != nil {
+				log.Printf("Unable to encode reconciliation report: %v", err)
+				continue
+			}
+			log.Printf("Balance drift detected: %s", data)
+		}
+	}
+}
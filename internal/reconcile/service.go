@@ -0,0 +1,197 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"Avito-trainee/internal/ledger"
+	"Avito-trainee/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// Reason Основание корректирующих записей сверки
+const Reason = "reconciliation"
+
+// Mismatch Расхождение баланса пользователя:
+//
+//	Coins - текущий users.coins
+//	Expected - стартовый баланс плюс сумма истории transactions со знаками models.TransactionSigns
+//	Ledger - баланс счета пользователя по проводкам
+type Mismatch struct {
+	UserID       int    `json:"userId"`
+	Username     string `json:"username"`
+	Coins        int    `json:"coins"`
+	Expected     int    `json:"expected"`
+	Ledger       int    `json:"ledger"`
+	HistoryDrift int    `json:"historyDrift"` // Coins - Expected
+	LedgerDrift  int    `json:"ledgerDrift"`  // Coins - Ledger
+}
+
+// Report Результат сверки
+type Report struct {
+	CheckedAt  time.Time  `json:"checkedAt"`
+	Users      int        `json:"users"`
+	Mismatches []Mismatch `json:"mismatches"`
+	Fixed      bool       `json:"fixed"`
+}
+
+// Service Сверка users.coins с историей операций и журналом.
+// С fix=true расхождения фиксируются записями adjustment: баланс пользователя не меняется,
+// а история и журнал дополняются так, чтобы сойтись с ним, и расхождение остается задокументированным
+type Service interface {
+	Run(ctx context.Context, fix bool) (Report, error)
+}
+
+type service struct {
+	db *sql.DB
+}
+
+func NewReconcileService(db *sql.DB) Service {
+	return &service{db: db}
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (s *service) Run(ctx context.Context, fix bool) (Report, error) {
+	const op = "reconcile/service/Run"
+	report := Report{CheckedAt: time.Now().UTC(), Mismatches: []Mismatch{}, Fixed: fix}
+
+	var users int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+		return Report{}, fmt.Errorf("%v: unable to count users: %w", op, err)
+	}
+	report.Users = users
+
+	mismatches, err := findMismatches(ctx, s.db, 0)
+	if err != nil {
+		return Report{}, fmt.Errorf("%v: %w", op, err)
+	}
+
+	if !fix {
+		report.Mismatches = append(report.Mismatches, mismatches...)
+		return report, nil
+	}
+
+	// Каждый пользователь исправляется в своей транзакции по данным, перечитанным под блокировкой
+	for _, m := range mismatches {
+		fixed, err := s.fixUser(ctx, m.UserID)
+		if err != nil {
+			return Report{}, fmt.Errorf("%v: %w", op, err)
+		}
+		if fixed != nil {
+			report.Mismatches = append(report.Mismatches, *fixed)
+		}
+	}
+	return report, nil
+}
+
+func (s *service) fixUser(ctx context.Context, userID int) (*Mismatch, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return nil, fmt.Errorf("unable to lock user %v: %w", userID, err)
+	}
+
+	mismatches, err := findMismatches(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(mismatches) == 0 {
+		// Расхождение исчезло, пока шла сверка
+		return nil, nil
+	}
+	m := mismatches[0]
+
+	var entryID sql.NullInt64
+	if m.LedgerDrift != 0 {
+		id, err := ledger.Post(
+			ctx,
+			tx,
+			ledger.EntryAdjustment,
+			Reason,
+			ledger.User(m.UserID, m.LedgerDrift),
+			ledger.System(ledger.AccountMint, -m.LedgerDrift),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("ledger error: %w", err)
+		}
+		entryID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	if m.HistoryDrift != 0 {
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO transactions (user_id, type, amount, reason, entry_id) VALUES ($1, $2, $3, $4, $5)`,
+			m.UserID,
+			models.TransactionAdjustment,
+			m.HistoryDrift,
+			Reason,
+			entryID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("transaction error: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return &m, nil
+}
+
+// findMismatches Пересчет балансов; userID = 0 означает всех пользователей
+func findMismatches(ctx context.Context, q querier, userID int) ([]Mismatch, error) {
+	types, signs := models.SignedTypes()
+
+	rows, err := q.QueryContext(
+		ctx,
+		`WITH signs AS (
+					SELECT * FROM unnest($1::text[], $2::int[]) AS s(type, sign)
+				), history AS (
+					SELECT t.user_id, SUM(s.sign * t.amount) AS delta
+					FROM transactions t
+					JOIN signs s ON s.type = t.type
+					WHERE $4 = 0 OR t.user_id = $4
+					GROUP BY t.user_id
+				)
+				SELECT u.id, u.username, u.coins, $3 + COALESCE(h.delta, 0), COALESCE(lb.balance, 0)
+				FROM users u
+				LEFT JOIN history h ON h.user_id = u.id
+				LEFT JOIN ledger_balances lb ON lb.user_id = u.id
+				WHERE ($4 = 0 OR u.id = $4)
+				AND (u.coins <> $3 + COALESCE(h.delta, 0) OR u.coins <> COALESCE(lb.balance, 0))
+				ORDER BY u.id`,
+		pq.Array(types),
+		pq.Array(signs),
+		models.InitialCoins,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to recompute balances: %w", err)
+	}
+	defer rows.Close()
+
+	var mismatches []Mismatch
+	for rows.Next() {
+		var m Mismatch
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Coins, &m.Expected, &m.Ledger); err != nil {
+			return nil, fmt.Errorf("unable to read balances: %w", err)
+		}
+		m.HistoryDrift = m.Coins - m.Expected
+		m.LedgerDrift = m.Coins - m.Ledger
+		mismatches = append(mismatches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read balances: %w", err)
+	}
+	return mismatches, nil
+}
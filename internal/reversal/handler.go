@@ -0,0 +1,154 @@
+package reversal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type ReasonRequest struct {
+	Reason string `json:"reason"`
+}
+
+// MakeRequestReversalHandler Отправитель просит отменить перевод {id} (его запись sent)
+func MakeRequestReversalHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, req, ok := parseRequest(w, r)
+		if !ok {
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		request, err := s.RequestReversal(r.Context(), userID, id, req.Reason)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, request)
+	}
+}
+
+// MakeReturnHandler Получатель возвращает перевод {id} (его запись received)
+func MakeReturnHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, req, ok := parseRequest(w, r)
+		if !ok {
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		if err := s.ReturnToSender(r.Context(), userID, id, req.Reason); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Coins returned successfully"))
+	}
+}
+
+// MakeRefundHandler Администратор возвращает покупку {id} (запись purchased)
+func MakeRefundHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, req, ok := parseRequest(w, r)
+		if !ok {
+			return
+		}
+
+		adminID := r.Context().Value("userID").(int)
+		if err := s.Refund(r.Context(), adminID, id, req.Reason); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Purchase refunded successfully"))
+	}
+}
+
+// MakeListRequestsHandler ?status=pending|approved|rejected|returned, без параметра - все запросы
+func MakeListRequestsHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "", StatusPending, StatusApproved, StatusRejected, StatusReturned:
+		default:
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+
+		requests, err := s.ListRequests(r.Context(), status)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, requests)
+	}
+}
+
+func MakeApproveHandler(s Service) http.HandlerFunc {
+	return makeResolveHandler(s.Approve)
+}
+
+func MakeRejectHandler(s Service) http.HandlerFunc {
+	return makeResolveHandler(s.Reject)
+}
+
+func makeResolveHandler(resolve func(ctx context.Context, adminID, requestID int) (Request, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid request id", http.StatusBadRequest)
+			return
+		}
+
+		adminID := r.Context().Value("userID").(int)
+		request, err := resolve(r.Context(), adminID, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, request)
+	}
+}
+
+// parseRequest id записи из пути и основание из тела
+func parseRequest(w http.ResponseWriter, r *http.Request) (int, ReasonRequest, bool) {
+	var req ReasonRequest
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid transaction id", http.StatusBadRequest)
+		return 0, req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return 0, req, false
+	}
+	return id, req, true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrTransactionNotFound):
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+	case errors.Is(err, ErrRequestNotFound):
+		http.Error(w, "Reversal request not found", http.StatusNotFound)
+	case errors.Is(err, ErrAlreadyReversed),
+		errors.Is(err, ErrAlreadyRequested),
+		errors.Is(err, ErrNotPending),
+		errors.Is(err, ErrInsufficientFunds),
+		errors.Is(err, ErrItemNotInInventory):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrReasonRequired),
+		errors.Is(err, ErrReasonTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
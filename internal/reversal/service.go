@@ -0,0 +1,606 @@
+package reversal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"Avito-trainee/internal/ledger"
+	"Avito-trainee/internal/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrReasonRequired      = errors.New("reason is required")
+	ErrReasonTooLong       = errors.New("reason is too long")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrAlreadyReversed     = errors.New("transaction is already reversed")
+	ErrAlreadyRequested    = errors.New("reversal of this transaction is already requested")
+	ErrRequestNotFound     = errors.New("reversal request not found")
+	ErrNotPending          = errors.New("reversal request is already resolved")
+	ErrInsufficientFunds   = errors.New("recipient doesn't have enough coins")
+	ErrItemNotInInventory  = errors.New("purchased item is no longer in inventory")
+)
+
+// Статусы запроса на отмену перевода
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	StatusReturned = "returned" // Получатель сам вернул перевод, пока запрос ждал решения
+)
+
+const maxReasonLength = 255
+
+// Request Запрос отправителя на отмену перевода TransactionID (его запись sent)
+type Request struct {
+	ID            int        `json:"id"`
+	TransactionID int        `json:"transactionId"`
+	FromUser      string     `json:"fromUser"`
+	ToUser        string     `json:"toUser"`
+	Amount        int        `json:"amount"`
+	Reason        string     `json:"reason"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ResolvedAt    *time.Time `json:"resolvedAt,omitempty"`
+	ResolvedBy    string     `json:"resolvedBy,omitempty"`
+}
+
+// Service Отмена переводов и возврат покупок. Отмена перевода пишет получателю reversal_debit,
+// отправителю reversal_credit, возврат покупки - refund; каждая компенсирующая запись ссылается
+// на исходную через reverses_id, и одну запись можно отменить только один раз (reverses_id уникален)
+type Service interface {
+	// RequestReversal Отправитель просит отменить свой перевод; выполняется после Approve
+	RequestReversal(ctx context.Context, senderID, transactionID int, reason string) (Request, error)
+	ListRequests(ctx context.Context, status string) ([]Request, error)
+	Approve(ctx context.Context, adminID, requestID int) (Request, error)
+	Reject(ctx context.Context, adminID, requestID int) (Request, error)
+	// ReturnToSender Получатель возвращает полученный перевод transactionID (его запись received)
+	ReturnToSender(ctx context.Context, recipientID, transactionID int, reason string) error
+	// Refund Возврат монет за покупку transactionID и изъятие товара из инвентаря
+	Refund(ctx context.Context, adminID, transactionID int, reason string) error
+}
+
+type service struct {
+	db *sql.DB
+}
+
+func NewReversalService(db *sql.DB) Service {
+	return &service{db: db}
+}
+
+const selectRequest = `SELECT q.id, q.transaction_id, su.username, t.counterparty, t.amount, q.reason, q.status,
+		q.created_at, q.resolved_at, COALESCE(a.username, '')
+		FROM reversal_requests q
+		JOIN transactions t ON t.id = q.transaction_id
+		JOIN users su ON su.id = t.user_id
+		LEFT JOIN users a ON a.id = q.resolved_by`
+
+func (s *service) RequestReversal(ctx context.Context, senderID, transactionID int, reason string) (Request, error) {
+	const op = "reversal/service/RequestReversal"
+	reason, err := normalizeReason(reason)
+	if err != nil {
+		return Request{}, err
+	}
+
+	var reversed bool
+	err = s.db.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM transactions WHERE reverses_id = t.id)
+				FROM transactions t WHERE t.id = $1 AND t.user_id = $2 AND t.type = 'sent'`,
+		transactionID,
+		senderID,
+	).Scan(&reversed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Request{}, ErrTransactionNotFound
+		}
+		return Request{}, fmt.Errorf("%v: unable to get transaction: %w", op, err)
+	}
+	if reversed {
+		return Request{}, ErrAlreadyReversed
+	}
+
+	var id int
+	err = s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO reversal_requests (transaction_id, requester_id, reason) VALUES ($1, $2, $3) RETURNING id`,
+		transactionID,
+		senderID,
+		reason,
+	).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return Request{}, ErrAlreadyRequested
+		}
+		return Request{}, fmt.Errorf("%v: unable to create request: %w", op, err)
+	}
+
+	request, err := s.get(ctx, id)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return request, nil
+}
+
+// ListRequests Запросы с указанным статусом; пустой статус - все
+func (s *service) ListRequests(ctx context.Context, status string) ([]Request, error) {
+	const op = "reversal/service/ListRequests"
+	rows, err := s.db.QueryContext(ctx, selectRequest+` WHERE $1 = '' OR q.status = $1 ORDER BY q.id`, status)
+	if err != nil {
+		return nil, fmt.Errorf("%v: unable to get requests: %w", op, err)
+	}
+	defer rows.Close()
+
+	requests := []Request{}
+	for rows.Next() {
+		request, err := scanRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", op, err)
+		}
+		requests = append(requests, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%v: unable to read requests: %w", op, err)
+	}
+	return requests, nil
+}
+
+// Approve Выполнение отмены по запросу. Если у получателя уже не хватает монет,
+// запрос остается ожидающим, и администратор может его отклонить
+func (s *service) Approve(ctx context.Context, adminID, requestID int) (Request, error) {
+	const op = "reversal/service/Approve"
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	transactionID, reason, err := lockPendingRequest(ctx, tx, requestID)
+	if err != nil {
+		return Request{}, err
+	}
+
+	t, err := lockTransfer(ctx, tx, "s.id", transactionID)
+	if err != nil {
+		return Request{}, err
+	}
+	if err := reverseTransfer(ctx, tx, t, reason); err != nil {
+		return Request{}, err
+	}
+
+	if err := resolveRequests(ctx, tx, t.sentID, StatusApproved, adminID); err != nil {
+		return Request{}, fmt.Errorf("%v: %w", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return Request{}, fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+
+	request, err := s.get(ctx, requestID)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return request, nil
+}
+
+func (s *service) Reject(ctx context.Context, adminID, requestID int) (Request, error) {
+	const op = "reversal/service/Reject"
+	res, err := s.db.ExecContext(
+		ctx,
+		`UPDATE reversal_requests SET status = 'rejected', resolved_at = now(), resolved_by = $2
+				WHERE id = $1 AND status = 'pending'`,
+		requestID,
+		adminID,
+	)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: unable to update request: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Request{}, fmt.Errorf("%v: %w", op, err)
+	} else if n == 0 {
+		if _, err := s.get(ctx, requestID); err != nil {
+			return Request{}, err
+		}
+		return Request{}, ErrNotPending
+	}
+
+	request, err := s.get(ctx, requestID)
+	if err != nil {
+		return Request{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return request, nil
+}
+
+func (s *service) ReturnToSender(ctx context.Context, recipientID, transactionID int, reason string) error {
+	const op = "reversal/service/ReturnToSender"
+	reason, err := normalizeReason(reason)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	t, err := lockTransfer(ctx, tx, "r.id", transactionID)
+	if err != nil {
+		return err
+	}
+	// Чужой перевод неотличим от несуществующего
+	if t.recipientID != recipientID {
+		return ErrTransactionNotFound
+	}
+	if err := reverseTransfer(ctx, tx, t, reason); err != nil {
+		return err
+	}
+
+	if err := resolveRequests(ctx, tx, t.sentID, StatusReturned, recipientID); err != nil {
+		return fmt.Errorf("%v: %w", op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+	return nil
+}
+
+// Refund Возврат покупки. Порядок блокировок: запись покупки (FOR UPDATE), затем проверка отмены
+// в checkNotReversed, затем строка пользователя, товар каталога (только при ограниченном запасе)
+// и инвентарь. Пользователь, товар и инвентарь идут в том же порядке, что и в merch.BuyItem
+// и cart.Checkout; строку товара без запаса, которую покупка держит FOR SHARE, возврат не меняет
+func (s *service) Refund(ctx context.Context, adminID, transactionID int, reason string) error {
+	const op = "reversal/service/Refund"
+	reason, err := normalizeReason(reason)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var (
+		userID, amount, quantity int
+		item                     string
+	)
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT user_id, merch, amount, quantity
+				FROM transactions t WHERE t.id = $1 AND t.type = 'purchased'
+				FOR UPDATE`,
+		transactionID,
+	).Scan(&userID, &item, &amount, &quantity)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTransactionNotFound
+		}
+		return fmt.Errorf("%v: unable to get purchase: %w", op, err)
+	}
+	if err := checkNotReversed(ctx, tx, transactionID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET coins = coins + $1 WHERE id = $2", amount, userID)
+	if err != nil {
+		return fmt.Errorf("%v: credit error: %w", op, err)
+	}
+
+	// Товар без ограничения запаса (stock IS NULL) не меняется
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE merch_items SET stock = stock + $2 WHERE slug = $1 AND stock IS NOT NULL",
+		item,
+		quantity,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to restock item: %w", op, err)
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE inventory SET quantity = quantity - $3, updated_at = now()
+				WHERE user_id = $1 AND item_type = $2 AND quantity >= $3`,
+		userID,
+		item,
+		quantity,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to update inventory: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%v: %w", op, err)
+	} else if n == 0 {
+		return ErrItemNotInInventory
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM inventory WHERE user_id = $1 AND item_type = $2 AND quantity = 0",
+		userID,
+		item,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to update inventory: %w", op, err)
+	}
+
+	entryID, err := ledger.Post(
+		ctx,
+		tx,
+		ledger.EntryRefund,
+		reason,
+		ledger.System(ledger.AccountShopRevenue, -amount),
+		ledger.User(userID, amount),
+	)
+	if err != nil {
+		return fmt.Errorf("%v: ledger error: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO transactions (user_id, type, counterparty, merch, amount, quantity, reason, entry_id, reverses_id)
+				SELECT $1, $2, username, $3, $4, $5, $6, $8, $9 FROM users WHERE id = $7`,
+		userID,
+		models.TransactionRefund,
+		item,
+		amount,
+		quantity,
+		reason,
+		adminID,
+		entryID,
+		transactionID,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrAlreadyReversed
+		}
+		return fmt.Errorf("%v: transaction error: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%v: unable to commit transaction: %w", op, err)
+	}
+	return nil
+}
+
+// transfer Пара записей одного перевода: sent отправителя и received получателя
+type transfer struct {
+	sentID      int
+	receivedID  int
+	senderID    int
+	recipientID int
+	sender      string
+	recipient   string
+	amount      int
+}
+
+// lockTransfer Поиск и блокировка обеих записей перевода по id одной из них (by - "s.id" или "r.id").
+// Записи одного перевода созданы в одной транзакции, поэтому совпадают по created_at и entry_id.
+// Блокируются только записи transactions; проверка отмены идет после блокировки, а строки
+// пользователей блокирует уже reverseTransfer в порядке id
+func lockTransfer(ctx context.Context, tx *sql.Tx, by string, transactionID int) (transfer, error) {
+	var t transfer
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT s.id, r.id, s.user_id, r.user_id, su.username, ru.username, s.amount
+				FROM transactions s
+				JOIN users su ON su.id = s.user_id
+				JOIN users ru ON ru.username = s.counterparty
+				JOIN transactions r ON r.user_id = ru.id AND r.type = 'received' AND r.counterparty = su.username
+					AND r.amount = s.amount AND r.created_at = s.created_at
+					AND r.entry_id IS NOT DISTINCT FROM s.entry_id
+				WHERE s.type = 'sent' AND `+by+` = $1
+				LIMIT 1
+				FOR UPDATE OF s, r`,
+		transactionID,
+	).Scan(&t.sentID, &t.receivedID, &t.senderID, &t.recipientID, &t.sender, &t.recipient, &t.amount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return transfer{}, ErrTransactionNotFound
+		}
+		return transfer{}, fmt.Errorf("unable to get transfer: %w", err)
+	}
+	if err := checkNotReversed(ctx, tx, t.sentID, t.receivedID); err != nil {
+		return transfer{}, err
+	}
+	return t, nil
+}
+
+// checkNotReversed Проверка отдельным запросом после блокировки исходных записей: в READ COMMITTED
+// он видит отмену, зафиксированную транзакцией, которая держала блокировку, а подзапрос
+// в самом SELECT ... FOR UPDATE остался бы на снимке до ожидания. Одновременную отмену
+// без общей блокировки останавливает уникальный индекс по reverses_id
+func checkNotReversed(ctx context.Context, tx *sql.Tx, ids ...int) error {
+	originals := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		originals = append(originals, int64(id))
+	}
+	var reversed bool
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM transactions WHERE reverses_id = ANY($1))",
+		pq.Array(originals),
+	).Scan(&reversed)
+	if err != nil {
+		return fmt.Errorf("unable to check reversals: %w", err)
+	}
+	if reversed {
+		return ErrAlreadyReversed
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// reverseTransfer Компенсирующий перевод от получателя отправителю. Строки пользователей
+// блокируются в порядке id, как и при обычном переводе
+func reverseTransfer(ctx context.Context, tx *sql.Tx, t transfer, reason string) error {
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT id, coins FROM users WHERE id = ANY($1) ORDER BY id FOR UPDATE",
+		pq.Array([]int64{int64(t.senderID), int64(t.recipientID)}),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to lock users: %w", err)
+	}
+	balance := 0
+	for rows.Next() {
+		var id, coins int
+		if err := rows.Scan(&id, &coins); err != nil {
+			rows.Close()
+			return fmt.Errorf("unable to check balance: %w", err)
+		}
+		if id == t.recipientID {
+			balance = coins
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("unable to check balance: %w", err)
+	}
+	if balance < t.amount {
+		return ErrInsufficientFunds
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET coins = coins - $1 WHERE id = $2", t.amount, t.recipientID)
+	if err != nil {
+		return fmt.Errorf("debit error: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE users SET coins = coins + $1 WHERE id = $2", t.amount, t.senderID)
+	if err != nil {
+		return fmt.Errorf("credit error: %w", err)
+	}
+
+	entryID, err := ledger.Post(
+		ctx,
+		tx,
+		ledger.EntryReversal,
+		reason,
+		ledger.User(t.recipientID, -t.amount),
+		ledger.User(t.senderID, t.amount),
+	)
+	if err != nil {
+		return fmt.Errorf("ledger error: %w", err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO transactions (user_id, type, counterparty, amount, reason, entry_id, reverses_id)
+				VALUES ($1, 'reversal_debit', $2, $3, $4, $5, $6), ($7, 'reversal_credit', $8, $3, $4, $5, $9)`,
+		t.recipientID,
+		t.sender,
+		t.amount,
+		reason,
+		entryID,
+		t.receivedID,
+		t.senderID,
+		t.recipient,
+		t.sentID,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrAlreadyReversed
+		}
+		return fmt.Errorf("transaction error: %w", err)
+	}
+	return nil
+}
+
+// lockPendingRequest Блокировка запроса, ожидающего решения. Возвращает запись sent и основание
+func lockPendingRequest(ctx context.Context, tx *sql.Tx, requestID int) (int, string, error) {
+	var (
+		transactionID  int
+		reason, status string
+	)
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT transaction_id, reason, status FROM reversal_requests WHERE id = $1 FOR UPDATE",
+		requestID,
+	).Scan(&transactionID, &reason, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", ErrRequestNotFound
+		}
+		return 0, "", fmt.Errorf("unable to get request: %w", err)
+	}
+	if status != StatusPending {
+		return 0, "", ErrNotPending
+	}
+	return transactionID, reason, nil
+}
+
+// resolveRequests Закрытие ожидающего запроса на отмену перевода, если он есть
+func resolveRequests(ctx context.Context, tx *sql.Tx, sentID int, status string, resolvedBy int) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE reversal_requests SET status = $2, resolved_at = now(), resolved_by = $3
+				WHERE transaction_id = $1 AND status = 'pending'`,
+		sentID,
+		status,
+		resolvedBy,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to resolve requests: %w", err)
+	}
+	return nil
+}
+
+func normalizeReason(reason string) (string, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return "", ErrReasonRequired
+	}
+	if len([]rune(reason)) > maxReasonLength {
+		return "", ErrReasonTooLong
+	}
+	return reason, nil
+}
+
+func (s *service) get(ctx context.Context, id int) (Request, error) {
+	request, err := scanRequest(s.db.QueryRowContext(ctx, selectRequest+` WHERE q.id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Request{}, ErrRequestNotFound
+		}
+		return Request{}, err
+	}
+	return request, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRequest(row scanner) (Request, error) {
+	var (
+		request    Request
+		resolvedAt sql.NullTime
+	)
+	err := row.Scan(
+		&request.ID,
+		&request.TransactionID,
+		&request.FromUser,
+		&request.ToUser,
+		&request.Amount,
+		&request.Reason,
+		&request.Status,
+		&request.CreatedAt,
+		&resolvedAt,
+		&request.ResolvedBy,
+	)
+	if err != nil {
+		return Request{}, fmt.Errorf("unable to read request: %w", err)
+	}
+	if resolvedAt.Valid {
+		request.ResolvedAt = &resolvedAt.Time
+	}
+	return request, nil
+}
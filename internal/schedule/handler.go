@@ -0,0 +1,150 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Avito-trainee/internal/coin"
+)
+
+type CreateRequest struct {
+	ToUser  string    `json:"toUser"`
+	Amount  int       `json:"amount"`
+	Message string    `json:"message,omitempty"`
+	Repeat  string    `json:"repeat,omitempty"`
+	RunAt   time.Time `json:"runAt"`
+}
+
+type UpdateRequest struct {
+	Amount  *int       `json:"amount"`
+	Message *string    `json:"message"`
+	Repeat  *string    `json:"repeat"`
+	RunAt   *time.Time `json:"runAt"`
+	Active  *bool      `json:"active"`
+}
+
+func MakeCreateHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ToUser == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		transfer, err := s.Create(r.Context(), userID, NewTransfer{
+			ToUser:  req.ToUser,
+			Amount:  req.Amount,
+			Message: req.Message,
+			Repeat:  req.Repeat,
+			RunAt:   req.RunAt,
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, transfer)
+	}
+}
+
+func MakeListHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(int)
+		transfers, err := s.List(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, transfers)
+	}
+}
+
+func MakeUpdateHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid transfer id", http.StatusBadRequest)
+			return
+		}
+		var req UpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		transfer, err := s.Update(r.Context(), userID, id, Update{
+			Amount:  req.Amount,
+			Message: req.Message,
+			Repeat:  req.Repeat,
+			RunAt:   req.RunAt,
+			Active:  req.Active,
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, transfer)
+	}
+}
+
+func MakeDeleteHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid transfer id", http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		if err := s.Delete(r.Context(), userID, id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func MakeRunsHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid transfer id", http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value("userID").(int)
+		runs, err := s.Runs(r.Context(), userID, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, runs)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrTransferNotFound):
+		http.Error(w, "Scheduled transfer not found", http.StatusNotFound)
+	case errors.Is(err, ErrRecipientNotFound):
+		http.Error(w, "User not found", http.StatusBadRequest)
+	case errors.Is(err, ErrInvalidAmount),
+		errors.Is(err, ErrInvalidRepeat),
+		errors.Is(err, ErrRunAtInPast),
+		errors.Is(err, ErrSameUser),
+		errors.Is(err, coin.ErrMessageTooLong):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
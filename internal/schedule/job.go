@@ -0,0 +1,29 @@
+package schedule
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Start Выполнение наступивших переводов с периодом interval до отмены ctx.
+// Можно запускать на нескольких репликах одновременно
+func Start(ctx context.Context, s Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			executed, err := s.RunDue(ctx)
+			if err != nil {
+				log.Printf("Scheduled transfers failed: %v", err)
+			}
+			if executed > 0 {
+				log.Printf("Scheduled transfers executed: %d", executed)
+			}
+		}
+	}
+}
@@ -0,0 +1,563 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"Avito-trainee/internal/coin"
+	"Avito-trainee/internal/db"
+)
+
+var (
+	ErrInvalidAmount     = errors.New("amount must be positive")
+	ErrInvalidRepeat     = errors.New("repeat must be one of once, daily, weekly, monthly")
+	ErrRunAtInPast       = errors.New("runAt is in the past")
+	ErrSameUser          = errors.New("unable to schedule transfer to yourself")
+	ErrRecipientNotFound = errors.New("recipient not found")
+	ErrTransferNotFound  = errors.New("scheduled transfer not found")
+)
+
+// Периодичность перевода
+const (
+	RepeatOnce    = "once"
+	RepeatDaily   = "daily"
+	RepeatWeekly  = "weekly"
+	RepeatMonthly = "monthly"
+)
+
+// Результаты запуска
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+const (
+	// claimBatch Сколько переводов забирается за одну транзакцию
+	claimBatch = 100
+	// runsLimit Сколько последних запусков возвращает Runs
+	runsLimit = 50
+	// pastTolerance Допустимое расхождение часов клиента и сервера для runAt
+	pastTolerance = time.Minute
+)
+
+// Transfer Запланированный перевод владельца получателю ToUser
+type Transfer struct {
+	ID         int        `json:"id"`
+	ToUser     string     `json:"toUser"`
+	Amount     int        `json:"amount"`
+	Message    string     `json:"message,omitempty"`
+	Repeat     string     `json:"repeat"`
+	Active     bool       `json:"active"`
+	NextRunAt  time.Time  `json:"nextRunAt"`
+	LastRunAt  *time.Time `json:"lastRunAt,omitempty"`
+	LastStatus string     `json:"lastStatus,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// NewTransfer Параметры нового перевода; нулевой RunAt означает "как можно скорее"
+type NewTransfer struct {
+	ToUser  string
+	Amount  int
+	Message string
+	Repeat  string
+	RunAt   time.Time
+}
+
+// Update Изменение перевода; nil означает "оставить как есть"
+type Update struct {
+	Amount  *int
+	Message *string
+	Repeat  *string
+	RunAt   *time.Time
+	Active  *bool
+}
+
+// Run Запуск перевода
+type Run struct {
+	ID           int       `json:"id"`
+	ScheduledFor time.Time `json:"scheduledFor"`
+	ExecutedAt   time.Time `json:"executedAt"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Service Отложенные и повторяющиеся переводы. RunDue выполняет наступившие переводы через
+// coin.Service.SendCoin не более одного раза: перевод забирается с FOR UPDATE SKIP LOCKED,
+// и следующий запуск сдвигается в той же транзакции до выполнения перевода, поэтому несколько
+// реплик не выполнят его дважды. Если процесс упадет между сдвигом и переводом, запуск пропускается
+type Service interface {
+	Create(ctx context.Context, ownerID int, t NewTransfer) (Transfer, error)
+	List(ctx context.Context, ownerID int) ([]Transfer, error)
+	Update(ctx context.Context, ownerID, id int, u Update) (Transfer, error)
+	Delete(ctx context.Context, ownerID, id int) error
+	Runs(ctx context.Context, ownerID, id int) ([]Run, error)
+	RunDue(ctx context.Context) (int, error)
+}
+
+type service struct {
+	db    *sql.DB
+	coins coin.Service
+}
+
+func NewScheduleService(db *sql.DB, coins coin.Service) Service {
+	return &service{db: db, coins: coins}
+}
+
+const selectTransfer = `SELECT t.id, u.username, t.amount, t.message, t.repeat, t.active, t.next_run_at,
+		t.last_run_at, COALESCE(t.last_status, ''), COALESCE(t.last_error, ''), t.created_at
+		FROM scheduled_transfers t
+		JOIN users u ON u.id = t.recipient_id`
+
+func (s *service) Create(ctx context.Context, ownerID int, t NewTransfer) (Transfer, error) {
+	const op = "schedule/service/Create"
+	if t.Amount <= 0 {
+		return Transfer{}, ErrInvalidAmount
+	}
+	if t.Repeat == "" {
+		t.Repeat = RepeatOnce
+	}
+	if !isValidRepeat(t.Repeat) {
+		return Transfer{}, ErrInvalidRepeat
+	}
+	if t.RunAt.IsZero() {
+		t.RunAt = time.Now()
+	} else if t.RunAt.Before(time.Now().Add(-pastTolerance)) {
+		return Transfer{}, ErrRunAtInPast
+	}
+	memo, err := coin.Memo{Message: t.Message}.Normalize()
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	var recipientID int
+	err = s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", t.ToUser).Scan(&recipientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Transfer{}, ErrRecipientNotFound
+		}
+		return Transfer{}, fmt.Errorf("%v: unable to find user: %w", op, err)
+	}
+	if recipientID == ownerID {
+		return Transfer{}, ErrSameUser
+	}
+
+	var id int
+	err = s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO scheduled_transfers (owner_id, recipient_id, amount, message, repeat, next_run_at)
+				VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		ownerID,
+		recipientID,
+		t.Amount,
+		memo.Message,
+		t.Repeat,
+		t.RunAt,
+	).Scan(&id)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("%v: unable to create transfer: %w", op, err)
+	}
+
+	transfer, err := s.get(ctx, ownerID, id)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return transfer, nil
+}
+
+func (s *service) List(ctx context.Context, ownerID int) ([]Transfer, error) {
+	const op = "schedule/service/List"
+	rows, err := s.db.QueryContext(ctx, selectTransfer+` WHERE t.owner_id = $1 ORDER BY t.id`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("%v: unable to get transfers: %w", op, err)
+	}
+	defer rows.Close()
+
+	transfers := []Transfer{}
+	for rows.Next() {
+		transfer, err := scanTransfer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", op, err)
+		}
+		transfers = append(transfers, transfer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%v: unable to read transfers: %w", op, err)
+	}
+	return transfers, nil
+}
+
+func (s *service) Update(ctx context.Context, ownerID, id int, u Update) (Transfer, error) {
+	const op = "schedule/service/Update"
+	if u.Amount != nil && *u.Amount <= 0 {
+		return Transfer{}, ErrInvalidAmount
+	}
+	if u.Repeat != nil && !isValidRepeat(*u.Repeat) {
+		return Transfer{}, ErrInvalidRepeat
+	}
+	if u.RunAt != nil && u.RunAt.Before(time.Now().Add(-pastTolerance)) {
+		return Transfer{}, ErrRunAtInPast
+	}
+
+	var (
+		amount  sql.NullInt64
+		message sql.NullString
+		repeat  sql.NullString
+		runAt   sql.NullTime
+		active  sql.NullBool
+	)
+	if u.Amount != nil {
+		amount = sql.NullInt64{Int64: int64(*u.Amount), Valid: true}
+	}
+	if u.Message != nil {
+		memo, err := coin.Memo{Message: *u.Message}.Normalize()
+		if err != nil {
+			return Transfer{}, err
+		}
+		message = sql.NullString{String: memo.Message, Valid: true}
+	}
+	if u.Repeat != nil {
+		repeat = sql.NullString{String: *u.Repeat, Valid: true}
+	}
+	if u.RunAt != nil {
+		runAt = sql.NullTime{Time: *u.RunAt, Valid: true}
+	}
+	if u.Active != nil {
+		active = sql.NullBool{Bool: *u.Active, Valid: true}
+	}
+
+	res, err := s.db.ExecContext(
+		ctx,
+		`UPDATE scheduled_transfers SET
+					amount = COALESCE($3, amount),
+					message = COALESCE($4, message),
+					repeat = COALESCE($5, repeat),
+					next_run_at = COALESCE($6, next_run_at),
+					active = COALESCE($7, active)
+				WHERE id = $1 AND owner_id = $2`,
+		id,
+		ownerID,
+		amount,
+		message,
+		repeat,
+		runAt,
+		active,
+	)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("%v: unable to update transfer: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Transfer{}, fmt.Errorf("%v: %w", op, err)
+	} else if n == 0 {
+		return Transfer{}, ErrTransferNotFound
+	}
+
+	transfer, err := s.get(ctx, ownerID, id)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("%v: %w", op, err)
+	}
+	return transfer, nil
+}
+
+func (s *service) Delete(ctx context.Context, ownerID, id int) error {
+	const op = "schedule/service/Delete"
+	res, err := s.db.ExecContext(ctx, "DELETE FROM scheduled_transfers WHERE id = $1 AND owner_id = $2", id, ownerID)
+	if err != nil {
+		return fmt.Errorf("%v: unable to delete transfer: %w", op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%v: %w", op, err)
+	} else if n == 0 {
+		return ErrTransferNotFound
+	}
+	return nil
+}
+
+func (s *service) Runs(ctx context.Context, ownerID, id int) ([]Run, error) {
+	const op = "schedule/service/Runs"
+	if _, err := s.get(ctx, ownerID, id); err != nil {
+		if errors.Is(err, ErrTransferNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%v: %w", op, err)
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, scheduled_for, executed_at, status, COALESCE(error, '')
+				FROM scheduled_transfer_runs WHERE schedule_id = $1 ORDER BY id DESC LIMIT $2`,
+		id,
+		runsLimit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%v: unable to get runs: %w", op, err)
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		var run Run
+		if err := rows.Scan(&run.ID, &run.ScheduledFor, &run.ExecutedAt, &run.Status, &run.Error); err != nil {
+			return nil, fmt.Errorf("%v: unable to read runs: %w", op, err)
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%v: unable to read runs: %w", op, err)
+	}
+	return runs, nil
+}
+
+// due Наступивший перевод, уже сдвинутый на следующий запуск
+type due struct {
+	id           int
+	ownerID      int
+	toUser       string
+	amount       int
+	message      string
+	scheduledFor time.Time
+}
+
+// RunDue Выполнение всех наступивших переводов. Возвращает число выполненных запусков,
+// включая неудачные: их причина записывается в историю запусков и в last_error
+func (s *service) RunDue(ctx context.Context) (int, error) {
+	const op = "schedule/service/RunDue"
+	executed := 0
+	for {
+		batch, err := s.claimDue(ctx)
+		if err != nil {
+			return executed, fmt.Errorf("%v: %w", op, err)
+		}
+
+		for i, d := range batch {
+			if err := s.execute(ctx, d); err != nil {
+				// Остальные переводы пакета уже сдвинуты при выборке и повторно не выберутся,
+				// поэтому их запуски записываются как прерванные
+				s.recordInterrupted(ctx, batch[i+1:])
+				return executed, fmt.Errorf("%v: %w", op, err)
+			}
+			executed++
+		}
+
+		if len(batch) < claimBatch {
+			return executed, nil
+		}
+	}
+}
+
+// claimDue Забирает наступившие переводы и сдвигает их следующий запуск. Строки, которые
+// в это же время забирает другая реплика, пропускаются благодаря SKIP LOCKED
+func (s *service) claimDue(ctx context.Context) ([]due, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT t.id, t.owner_id, u.username, t.amount, t.message, t.repeat, t.next_run_at
+				FROM scheduled_transfers t
+				JOIN users u ON u.id = t.recipient_id
+				WHERE t.active AND t.next_run_at <= now()
+				ORDER BY t.next_run_at
+				LIMIT $1
+				FOR UPDATE OF t SKIP LOCKED`,
+		claimBatch,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get due transfers: %w", err)
+	}
+
+	var (
+		batch   []due
+		repeats []string
+	)
+	for rows.Next() {
+		var (
+			d      due
+			repeat string
+		)
+		if err := rows.Scan(&d.id, &d.ownerID, &d.toUser, &d.amount, &d.message, &repeat, &d.scheduledFor); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("unable to read due transfers: %w", err)
+		}
+		batch = append(batch, d)
+		repeats = append(repeats, repeat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read due transfers: %w", err)
+	}
+
+	now := time.Now()
+	for i, d := range batch {
+		next, active := nextRun(repeats[i], d.scheduledFor, now)
+		_, err := tx.ExecContext(
+			ctx,
+			`UPDATE scheduled_transfers SET next_run_at = $2, active = $3, last_run_at = now() WHERE id = $1`,
+			d.id,
+			next,
+			active,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to advance transfer %v: %w", d.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return batch, nil
+}
+
+// execute Перевод и запись результата запуска
+func (s *service) execute(ctx context.Context, d due) error {
+	status, reason := RunSucceeded, sql.NullString{}
+	sendErr := s.coins.SendCoin(ctx, d.ownerID, d.toUser, d.amount, coin.Memo{Message: d.message})
+	switch {
+	case sendErr == nil:
+	case ctx.Err() != nil:
+		// Перевод откатан остановкой сервиса, а запуск уже сдвинут при выборке, поэтому он
+		// записывается как неудачный, чтобы владелец увидел пропуск
+		status, reason = RunFailed, sql.NullString{String: "interrupted", Valid: true}
+		log.Printf("Scheduled transfer %v interrupted: %v", d.id, sendErr)
+	default:
+		status, reason = RunFailed, sql.NullString{String: failureReason(sendErr), Valid: true}
+		log.Printf("Scheduled transfer %v failed: %v", d.id, sendErr)
+	}
+
+	if err := s.recordRun(ctx, d, status, reason); err != nil {
+		return err
+	}
+	// После отмены остальные переводы пакета не выполняются
+	if ctx.Err() != nil && sendErr != nil {
+		return sendErr
+	}
+	return nil
+}
+
+// recordInterrupted Запись невыполненных переводов пакета как неудачных запусков
+func (s *service) recordInterrupted(ctx context.Context, batch []due) {
+	reason := sql.NullString{String: "interrupted", Valid: true}
+	for _, d := range batch {
+		if err := s.recordRun(ctx, d, RunFailed, reason); err != nil {
+			log.Printf("Scheduled transfer %v interrupted: %v", d.id, err)
+		}
+	}
+}
+
+// recordRun Запись результата запуска. Выполняется и при отмене ctx: перевод уже выполнен,
+// отклонен или прерван, а следующий запуск уже назначен
+func (s *service) recordRun(ctx context.Context, d due, status string, reason sql.NullString) error {
+	_, err := s.db.ExecContext(
+		context.WithoutCancel(ctx),
+		`WITH run AS (
+					INSERT INTO scheduled_transfer_runs (schedule_id, scheduled_for, status, error)
+					VALUES ($1, $2, $3, $4)
+				)
+				UPDATE scheduled_transfers SET last_status = $3, last_error = $4 WHERE id = $1`,
+		d.id,
+		d.scheduledFor,
+		status,
+		reason,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to record run of transfer %v: %w", d.id, err)
+	}
+	return nil
+}
+
+// failureReason Причина неудачи, которую можно показать владельцу перевода
+func failureReason(err error) string {
+	var policyErr *coin.PolicyError
+	switch {
+	case errors.As(err, &policyErr):
+		return policyErr.Message
+	case errors.Is(err, coin.ErrInsufficientFunds):
+		return "not enough coins"
+	case errors.Is(err, coin.ErrUserNotFound):
+		return "recipient not found"
+	case errors.Is(err, db.ErrBusy):
+		return "service is busy"
+	default:
+		return "internal error"
+	}
+}
+
+// nextRun Следующий запуск после now для повторяющегося перевода. Пропущенные, пока сервис
+// не работал, запуски не наверстываются. Разовый перевод после запуска выключается
+func nextRun(repeat string, from, now time.Time) (time.Time, bool) {
+	var step func(time.Time) time.Time
+	switch repeat {
+	case RepeatDaily:
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case RepeatWeekly:
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case RepeatMonthly:
+		step = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	default:
+		return from, false
+	}
+
+	next := step(from)
+	for !next.After(now) {
+		next = step(next)
+	}
+	return next, true
+}
+
+func isValidRepeat(repeat string) bool {
+	switch repeat {
+	case RepeatOnce, RepeatDaily, RepeatWeekly, RepeatMonthly:
+		return true
+	}
+	return false
+}
+
+func (s *service) get(ctx context.Context, ownerID, id int) (Transfer, error) {
+	row := s.db.QueryRowContext(ctx, selectTransfer+` WHERE t.id = $1 AND t.owner_id = $2`, id, ownerID)
+	transfer, err := scanTransfer(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Transfer{}, ErrTransferNotFound
+		}
+		return Transfer{}, err
+	}
+	return transfer, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTransfer(row scanner) (Transfer, error) {
+	var (
+		transfer  Transfer
+		lastRunAt sql.NullTime
+	)
+	err := row.Scan(
+		&transfer.ID,
+		&transfer.ToUser,
+		&transfer.Amount,
+		&transfer.Message,
+		&transfer.Repeat,
+		&transfer.Active,
+		&transfer.NextRunAt,
+		&lastRunAt,
+		&transfer.LastStatus,
+		&transfer.LastError,
+		&transfer.CreatedAt,
+	)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("unable to read transfer: %w", err)
+	}
+	if lastRunAt.Valid {
+		transfer.LastRunAt = &lastRunAt.Time
+	}
+	return transfer, nil
+}
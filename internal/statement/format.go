@@ -0,0 +1,130 @@
+package statement
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flushEvery Сколько операций буферизуется перед отправкой клиенту
+const flushEvery = 100
+
+// csvWriter Выписка в CSV: строка opening_balance, операции, строка closing_balance
+type csvWriter struct {
+	w     *csv.Writer
+	flush func()
+	n     int
+}
+
+func NewCSVWriter(w io.Writer, flush func()) Writer {
+	return &csvWriter{w: csv.NewWriter(w), flush: flush}
+}
+
+func (c *csvWriter) Begin(h Header) error {
+	c.w.Write([]string{"id", "created_at", "type", "counterparty", "merch", "quantity", "amount", "balance", "description"})
+	c.w.Write([]string{"", h.From.Format(time.RFC3339), "opening_balance", "", "", "", "", strconv.Itoa(h.OpeningBalance), ""})
+	return c.sync()
+}
+
+func (c *csvWriter) Entry(e Entry) error {
+	quantity := ""
+	if e.Quantity != 0 {
+		quantity = strconv.Itoa(e.Quantity)
+	}
+	err := c.w.Write([]string{
+		strconv.Itoa(e.ID),
+		e.CreatedAt.Format(time.RFC3339),
+		e.Type,
+		safeCell(e.Counterparty),
+		safeCell(e.Merch),
+		quantity,
+		strconv.Itoa(e.Amount),
+		strconv.Itoa(e.Balance),
+		safeCell(e.Description),
+	})
+	if err != nil {
+		return err
+	}
+	if c.n++; c.n%flushEvery == 0 {
+		return c.sync()
+	}
+	return nil
+}
+
+func (c *csvWriter) End(closingBalance int) error {
+	c.w.Write([]string{"", "", "closing_balance", "", "", "", "", strconv.Itoa(closingBalance), ""})
+	return c.sync()
+}
+
+// safeCell Текст пользователя, который таблица приняла бы за формулу, экранируется апострофом:
+// выписку открывают в Excel и подобных программах
+func safeCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (c *csvWriter) sync() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return err
+	}
+	c.flush()
+	return nil
+}
+
+// jsonWriter Выписка в JSON: поля заголовка, массив entries и closingBalance.
+// Документ собирается по частям, так что оборванная выгрузка дает невалидный JSON
+type jsonWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	flush func()
+	n     int
+}
+
+func NewJSONWriter(w io.Writer, flush func()) Writer {
+	return &jsonWriter{w: w, enc: json.NewEncoder(w), flush: flush}
+}
+
+func (j *jsonWriter) Begin(h Header) error {
+	header, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	// Заголовок без закрывающей скобки, дальше дописывается массив операций
+	if _, err := j.w.Write(header[:len(header)-1]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(j.w, `,"entries":[`); err != nil {
+		return err
+	}
+	j.flush()
+	return nil
+}
+
+func (j *jsonWriter) Entry(e Entry) error {
+	if j.n > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	if err := j.enc.Encode(e); err != nil {
+		return err
+	}
+	if j.n++; j.n%flushEvery == 0 {
+		j.flush()
+	}
+	return nil
+}
+
+func (j *jsonWriter) End(closingBalance int) error {
+	if _, err := io.WriteString(j.w, `],"closingBalance":`+strconv.Itoa(closingBalance)+"}\n"); err != nil {
+		return err
+	}
+	j.flush()
+	return nil
+}
@@ -0,0 +1,110 @@
+package statement
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"Avito-trainee/internal/models"
+)
+
+// writeTimeout Время на отправку очередной порции выписки; общий WriteTimeout сервера
+// обрывал бы длинные выгрузки
+const writeTimeout = 10 * time.Second
+
+// MakeStatementHandler GET /api/statement?from=&to=&format=csv|json&user=
+// from и to в формате RFC 3339 или YYYY-MM-DD; по умолчанию - с начала текущего месяца до текущего момента.
+// Выписку по другому пользователю (?user=) могут получить только admin и auditor
+func MakeStatementHandler(s Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		req := Request{
+			UserID:   r.Context().Value("userID").(int),
+			Username: query.Get("user"),
+			To:       time.Now().UTC(),
+		}
+		req.From = time.Date(req.To.Year(), req.To.Month(), 1, 0, 0, 0, 0, time.UTC)
+		for name, dst := range map[string]*time.Time{"from": &req.From, "to": &req.To} {
+			if value := query.Get(name); value != "" {
+				t, err := parseTime(value)
+				if err != nil {
+					http.Error(w, "Invalid "+name, http.StatusBadRequest)
+					return
+				}
+				*dst = t
+			}
+		}
+		if req.Username != "" {
+			role, _ := r.Context().Value("role").(string)
+			if !slices.Contains([]string{models.RoleAdmin, models.RoleAuditor}, role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		rc := http.NewResponseController(w)
+		flush := func() {
+			rc.Flush()
+			rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+		var (
+			out         Writer
+			contentType string
+			extension   string
+		)
+		switch format := query.Get("format"); format {
+		case "", "csv":
+			out, contentType, extension = NewCSVWriter(w, flush), "text/csv; charset=utf-8", "csv"
+		case "json":
+			out, contentType, extension = NewJSONWriter(w, flush), "application/json", "json"
+		default:
+			http.Error(w, "Invalid format", http.StatusBadRequest)
+			return
+		}
+
+		tracked := &startedWriter{Writer: out, begin: func(h Header) {
+			filename := "statement-" + h.From.Format("20060102") + "-" + h.To.Format("20060102") + "." + extension
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		}}
+		err := s.Write(r.Context(), req, tracked)
+		if err == nil {
+			return
+		}
+		// После начала выгрузки статус уже отправлен, остается только оборвать ответ
+		if tracked.started {
+			log.Printf("Statement interrupted: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+		switch {
+		case errors.Is(err, ErrInvalidPeriod):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrUserNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// startedWriter Запоминает, начата ли выгрузка, и выставляет заголовки ответа перед первой записью
+type startedWriter struct {
+	Writer
+	begin   func(h Header)
+	started bool
+}
+
+func (s *startedWriter) Begin(h Header) error {
+	s.begin(h)
+	s.started = true
+	return s.Writer.Begin(h)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
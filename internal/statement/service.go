@@ -0,0 +1,169 @@
+package statement
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"Avito-trainee/internal/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrInvalidPeriod = errors.New("from must be before to")
+)
+
+// Request Выписка по пользователю UserID или, если задан Username, по пользователю с этим именем
+// за период [From, To)
+type Request struct {
+	UserID   int
+	Username string
+	From     time.Time
+	To       time.Time
+}
+
+// Header Начало выписки
+type Header struct {
+	Username       string    `json:"username"`
+	From           time.Time `json:"from"`
+	To             time.Time `json:"to"`
+	OpeningBalance int       `json:"openingBalance"`
+}
+
+// Entry Операция выписки. Amount со знаком: положительный при увеличении баланса;
+// Balance - баланс после операции
+type Entry struct {
+	ID           int       `json:"id"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Type         string    `json:"type"`
+	Counterparty string    `json:"counterparty,omitempty"`
+	Merch        string    `json:"merch,omitempty"`
+	Quantity     int       `json:"quantity,omitempty"`
+	Amount       int       `json:"amount"`
+	Balance      int       `json:"balance"`
+	Description  string    `json:"description,omitempty"`
+}
+
+// Writer Формат выписки. Методы вызываются по порядку: Begin, Entry для каждой операции, End
+type Writer interface {
+	Begin(h Header) error
+	Entry(e Entry) error
+	End(closingBalance int) error
+}
+
+// Service Выписка по счету пользователя. Операции читаются из transactions и передаются
+// в Writer по одной, не накапливаясь в памяти
+type Service interface {
+	Write(ctx context.Context, req Request, out Writer) error
+}
+
+type service struct {
+	db *sql.DB
+}
+
+func NewStatementService(db *sql.DB) Service {
+	return &service{db: db}
+}
+
+// Write Входящий баланс - текущий users.coins за вычетом всех операций начиная с From, исходящий -
+// входящий плюс операции периода. Все читается в одном снимке (REPEATABLE READ), поэтому
+// операции, совершенные во время выгрузки, не нарушают сходимость балансов. Транзакция только
+// читает: без записи она не получает номер и не задерживает выдачу событий (events.After)
+func (s *service) Write(ctx context.Context, req Request, out Writer) error {
+	const op = "statement/service/Write"
+	if !req.From.Before(req.To) {
+		return ErrInvalidPeriod
+	}
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("%v: unable to start transaction: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var (
+		userID   int
+		username string
+		coins    int
+	)
+	if req.Username != "" {
+		err = tx.QueryRowContext(ctx, "SELECT id, username, coins FROM users WHERE username = $1", req.Username).
+			Scan(&userID, &username, &coins)
+	} else {
+		err = tx.QueryRowContext(ctx, "SELECT id, username, coins FROM users WHERE id = $1", req.UserID).
+			Scan(&userID, &username, &coins)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("%v: unable to find user: %w", op, err)
+	}
+
+	types, signs := models.SignedTypes()
+
+	var since int
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(SUM(s.sign * t.amount), 0)
+				FROM transactions t
+				JOIN unnest($2::text[], $3::int[]) AS s(type, sign) ON s.type = t.type
+				WHERE t.user_id = $1 AND t.created_at >= $4`,
+		userID,
+		pq.Array(types),
+		pq.Array(signs),
+		req.From,
+	).Scan(&since)
+	if err != nil {
+		return fmt.Errorf("%v: unable to compute opening balance: %w", op, err)
+	}
+	balance := coins - since
+
+	if err := out.Begin(Header{Username: username, From: req.From, To: req.To, OpeningBalance: balance}); err != nil {
+		return fmt.Errorf("%v: %w", op, err)
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT t.id, t.created_at, t.type, COALESCE(t.counterparty, ''), COALESCE(t.merch, ''), t.quantity,
+					COALESCE(s.sign, 0) * t.amount, COALESCE(t.reason, t.message, '')
+				FROM transactions t
+				LEFT JOIN unnest($2::text[], $3::int[]) AS s(type, sign) ON s.type = t.type
+				WHERE t.user_id = $1 AND t.created_at >= $4 AND t.created_at < $5
+				ORDER BY t.created_at, t.id`,
+		userID,
+		pq.Array(types),
+		pq.Array(signs),
+		req.From,
+		req.To,
+	)
+	if err != nil {
+		return fmt.Errorf("%v: unable to get transactions: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Entry
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Type, &e.Counterparty, &e.Merch, &e.Quantity, &e.Amount, &e.Description)
+		if err != nil {
+			return fmt.Errorf("%v: unable to read transactions: %w", op, err)
+		}
+		balance += e.Amount
+		e.Balance = balance
+		if err := out.Entry(e); err != nil {
+			return fmt.Errorf("%v: %w", op, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%v: unable to read transactions: %w", op, err)
+	}
+
+	if err := out.End(balance); err != nil {
+		return fmt.Errorf("%v: %w", op, err)
+	}
+	return nil
+}
@@ -26,11 +26,12 @@ func TestAuthIntegration(t *testing.T) {
 	defer dbConn.Close()
 
 	// Инициализация сервиса аутентификации
-	authService := auth.NewAuthService(dbConn, cfg.JWTSecret)
+	jwtManager := newJWTManager(cfg.JWTSecret)
+	authService := auth.NewAuthService(dbConn, jwtManager, auth.Options{AutoRegister: true})
 
 	// Создание роутера
 	r := chi.NewRouter()
-	r.Post("/api/auth", auth.MakeAuthHandler(authService))
+	r.Post("/api/auth", auth.MakeAuthHandler(authService, newLoginGuard(dbConn)))
 
 	// Создание тестового сервера
 	testServer := httptest.NewServer(r)
@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"Avito-trainee/internal/cart"
 	"Avito-trainee/internal/coin"
 	"Avito-trainee/internal/config"
 	middleware2 "Avito-trainee/internal/middleware"
@@ -12,6 +13,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"Avito-trainee/internal/auth"
 	"Avito-trainee/internal/db"
@@ -45,7 +47,8 @@ func TestBuyItemIntegration(t *testing.T) {
 	}
 
 	// Создание сервиса аутентификации
-	authService := auth.NewAuthService(dbConn, cfg.JWTSecret)
+	jwtManager := newJWTManager(cfg.JWTSecret)
+	authService := auth.NewAuthService(dbConn, jwtManager, auth.Options{AutoRegister: true})
 
 	// Регистрация пользователя
 	username := "testuser"
@@ -56,7 +59,7 @@ func TestBuyItemIntegration(t *testing.T) {
 	}
 
 	// Создание HTTP-сервера для тестирования
-	router := setupRouter(dbConn, cfg.JWTSecret)
+	router := setupRouter(dbConn, jwtManager)
 
 	// Тестовый запрос на покупку товара
 	item := "t-shirt"
@@ -65,7 +68,7 @@ func TestBuyItemIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create request: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
 
 	// Выполнение запроса
 	rr := httptest.NewRecorder()
@@ -100,7 +103,7 @@ func TestBuyItemIntegration(t *testing.T) {
 }
 
 // setupRouter создает роутер с необходимыми обработчиками
-func setupRouter(dbConn *sql.DB, jwtSecret string) *chi.Mux {
+func setupRouter(dbConn *sql.DB, jwtManager *auth.JWTManager) *chi.Mux {
 	r := chi.NewRouter()
 
 	// Middleware
@@ -110,19 +113,40 @@ func setupRouter(dbConn *sql.DB, jwtSecret string) *chi.Mux {
 	r.Use(middleware.Recoverer)
 
 	// Инициализация сервисов
-	authService := auth.NewAuthService(dbConn, jwtSecret)
+	authService := auth.NewAuthService(dbConn, jwtManager, auth.Options{AutoRegister: true})
 	merchService := merch.NewMerchService(dbConn)
 	coinService := coin.NewCoinService(dbConn)
+	cartService := cart.NewCartService(dbConn)
 
 	// Маршруты
 	r.Route("/api", func(r chi.Router) {
-		r.Post("/auth", auth.MakeAuthHandler(authService))
+		r.Post("/auth", auth.MakeAuthHandler(authService, newLoginGuard(dbConn)))
 	})
 	r.Group(func(r chi.Router) {
-		r.Use(middleware2.JWTAuthMiddleware(dbConn, jwtSecret))
+		r.Use(middleware2.JWTAuthMiddleware(dbConn, jwtManager))
 		r.Get("/api/buy/{item}", merch.MakeBuyHandler(merchService))
 		r.Post("/api/sendCoin", coin.MakeSendCoinHandler(coinService))
+		r.Get("/api/cart", cart.MakeGetCartHandler(cartService))
+		r.Post("/api/cart/items", cart.MakeAddItemHandler(cartService))
+		r.Delete("/api/cart/items/{item}", cart.MakeRemoveItemHandler(cartService))
+		r.Post("/api/checkout", cart.MakeCheckoutHandler(cartService))
 	})
 
 	return r
 }
+
+// newJWTManager создает менеджер токенов с симметричным ключом из конфигурации
+func newJWTManager(jwtSecret string) *auth.JWTManager {
+	return auth.NewJWTManager(auth.NewHMACKeySet(jwtSecret), auth.AccessTokenDuration)
+}
+
+// newLoginGuard создает защиту от перебора паролей с настройками по умолчанию
+func newLoginGuard(dbConn *sql.DB) auth.LoginGuard {
+	return auth.NewLoginGuard(dbConn, auth.LockoutOptions{
+		MaxAttempts:   5,
+		IPMaxAttempts: 50,
+		BaseDelay:     30 * time.Second,
+		MaxDelay:      time.Hour,
+		Window:        time.Hour,
+	})
+}
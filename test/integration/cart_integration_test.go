@@ -0,0 +1,96 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Avito-trainee/internal/auth"
+	"Avito-trainee/internal/cart"
+	"Avito-trainee/internal/config"
+	"Avito-trainee/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCartCheckoutIntegration(t *testing.T) {
+	// Загрузка конфигурации
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	// Инициализация подключения к базе данных
+	dbConn, err := db.NewPostgresConnection(cfg.DatabaseURL)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer dbConn.Close()
+
+	// Применение миграций
+	if err := db.RunMigrations(cfg.DatabaseURL); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	// Очистка базы данных перед тестом
+	_, err = dbConn.Exec(`TRUNCATE TABLE users, transactions, inventory, orders RESTART IDENTITY CASCADE`)
+	if err != nil {
+		t.Fatalf("failed to truncate tables: %v", err)
+	}
+
+	jwtManager := newJWTManager(cfg.JWTSecret)
+	authService := auth.NewAuthService(dbConn, jwtManager, auth.Options{AutoRegister: true})
+	token, err := authService.Authenticate(context.Background(), "cartuser", "password123")
+	require.NoError(t, err)
+
+	router := setupRouter(dbConn, jwtManager)
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		buf := bytes.NewBuffer(nil)
+		if body != nil {
+			require.NoError(t, json.NewEncoder(buf).Encode(body))
+		}
+		req, err := http.NewRequest(method, path, buf)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Наполнение корзины: 5 ручек и 2 кружки
+	rr := do("POST", "/api/cart/items", cart.AddItemRequest{Item: "pen", Quantity: 3})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = do("POST", "/api/cart/items", cart.AddItemRequest{Item: "pen", Quantity: 2})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = do("POST", "/api/cart/items", cart.AddItemRequest{Item: "cup", Quantity: 2})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var c cart.Cart
+	require.NoError(t, json.NewDecoder(do("GET", "/api/cart", nil).Body).Decode(&c))
+	assert.Len(t, c.Items, 2)
+	assert.Equal(t, 90, c.Total)
+
+	// Оформление заказа
+	rr = do("POST", "/api/checkout", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var order cart.Order
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&order))
+	assert.Equal(t, 90, order.Total)
+
+	var coins int
+	require.NoError(t, dbConn.QueryRow(`SELECT coins FROM users WHERE username = 'cartuser'`).Scan(&coins))
+	assert.Equal(t, 910, coins)
+
+	var pens, purchases int
+	require.NoError(t, dbConn.QueryRow(`SELECT quantity FROM inventory WHERE item_type = 'pen'`).Scan(&pens))
+	assert.Equal(t, 5, pens)
+	require.NoError(t, dbConn.QueryRow(`SELECT COUNT(*) FROM transactions WHERE order_id = $1`, order.ID).Scan(&purchases))
+	assert.Equal(t, 2, purchases, "one purchased row per order line")
+
+	// Корзина очищена, повторное оформление невозможно
+	rr = do("POST", "/api/checkout", nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
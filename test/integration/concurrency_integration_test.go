@@ -0,0 +1,94 @@
+package integration
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"Avito-trainee/internal/auth"
+	"Avito-trainee/internal/coin"
+	"Avito-trainee/internal/config"
+	"Avito-trainee/internal/db"
+	"Avito-trainee/internal/ledger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentTransfersIntegration(t *testing.T) {
+	// Загрузка конфигурации
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	// Инициализация подключения к базе данных
+	dbConn, err := db.NewPostgresConnection(cfg.DatabaseURL)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer dbConn.Close()
+
+	// Применение миграций
+	if err := db.RunMigrations(cfg.DatabaseURL); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	// Очистка базы данных перед тестом
+	_, err = dbConn.Exec(`TRUNCATE TABLE users, transactions RESTART IDENTITY CASCADE`)
+	if err != nil {
+		t.Fatalf("failed to truncate tables: %v", err)
+	}
+
+	// Регистрация двух пользователей
+	authService := auth.NewAuthService(dbConn, newJWTManager(cfg.JWTSecret), auth.Options{AutoRegister: true})
+	for _, username := range []string{"alice", "bob"} {
+		_, err := authService.Authenticate(context.Background(), username, "password123")
+		require.NoError(t, err)
+	}
+
+	// Встречные переводы A->B и B->A одновременно
+	const transfersPerSide = 100
+	coinService := coin.NewCoinService(dbConn)
+	ids := map[string]int{}
+	for _, username := range []string{"alice", "bob"} {
+		var id int
+		require.NoError(t, dbConn.QueryRow(`SELECT id FROM users WHERE username = $1`, username).Scan(&id))
+		ids[username] = id
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []error
+	)
+	send := func(from, to string) {
+		defer wg.Done()
+		if err := coinService.SendCoin(context.Background(), ids[from], to, 1, coin.Memo{}); err != nil {
+			mu.Lock()
+			failed = append(failed, err)
+			mu.Unlock()
+		}
+	}
+	for i := 0; i < transfersPerSide; i++ {
+		wg.Add(2)
+		go send("alice", "bob")
+		go send("bob", "alice")
+	}
+	wg.Wait()
+
+	// Все переводы выполнены, балансы вернулись к исходным и совпадают с журналом
+	assert.Empty(t, failed, "no transfer should fail with a deadlock or serialization error")
+	for username, id := range ids {
+		var coins int
+		require.NoError(t, dbConn.QueryRow(`SELECT coins FROM users WHERE id = $1`, id).Scan(&coins))
+		assert.Equal(t, 1000, coins, "balance of %s", username)
+
+		balance, err := ledger.Balance(context.Background(), dbConn, id)
+		require.NoError(t, err)
+		assert.Equal(t, coins, balance, "ledger balance of %s", username)
+	}
+
+	var rows int
+	require.NoError(t, dbConn.QueryRow(`SELECT COUNT(*) FROM transactions WHERE type = 'sent'`).Scan(&rows))
+	assert.Equal(t, 2*transfersPerSide, rows)
+}
@@ -27,18 +27,19 @@ func TestInfoIntegration(t *testing.T) {
 	defer dbConn.Close()
 
 	// Инициализация сервисов
-	authService := auth.NewAuthService(dbConn, cfg.JWTSecret)
+	jwtManager := newJWTManager(cfg.JWTSecret)
+	authService := auth.NewAuthService(dbConn, jwtManager, auth.Options{AutoRegister: true})
 	infoService := info.NewInfoService(dbConn)
 
 	// Создание роутера
 	r := chi.NewRouter()
 
 	r.Route("/api", func(r chi.Router) {
-		r.Post("/auth", auth.MakeAuthHandler(authService))
+		r.Post("/auth", auth.MakeAuthHandler(authService, newLoginGuard(dbConn)))
 	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.JWTAuthMiddleware(dbConn, cfg.JWTSecret)) // Middleware для проверки JWT
+		r.Use(middleware.JWTAuthMiddleware(dbConn, jwtManager)) // Middleware для проверки JWT
 		r.Get("/api/info", info.MakeInfoHandler(infoService))
 	})
 
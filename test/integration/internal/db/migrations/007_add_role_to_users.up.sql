@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS check_user_role;
+ALTER TABLE users ADD CONSTRAINT check_user_role CHECK (role IN ('user', 'admin', 'auditor'));
\ No newline at end of file
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS merch_items (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(50) NOT NULL UNIQUE,
+    title VARCHAR(100) NOT NULL,
+    price INTEGER NOT NULL CHECK (price > 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    description TEXT NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+INSERT INTO merch_items (slug, title, price) VALUES
+    ('t-shirt', 'T-shirt', 80),
+    ('cup', 'Cup', 20),
+    ('book', 'Book', 50),
+    ('pen', 'Pen', 10),
+    ('powerbank', 'Powerbank', 200),
+    ('hoody', 'Hoody', 300),
+    ('umbrella', 'Umbrella', 200),
+    ('socks', 'Socks', 10),
+    ('wallet', 'Wallet', 50),
+    ('pink-hoody', 'Pink hoody', 500)
+ON CONFLICT (slug) DO NOTHING;
\ No newline at end of file
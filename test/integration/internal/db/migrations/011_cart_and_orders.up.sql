@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS cart_items (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    item_slug VARCHAR(50) NOT NULL REFERENCES merch_items(slug),
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, item_slug)
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    total INTEGER NOT NULL CHECK (total > 0),
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_user ON orders (user_id, id);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    item_slug VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    price INTEGER NOT NULL CHECK (price > 0),
+    PRIMARY KEY (order_id, item_slug)
+);
+
+-- Покупка через корзину пишет одну строку purchased на позицию заказа
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS order_id INTEGER REFERENCES orders(id);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS quantity INTEGER NOT NULL DEFAULT 1;
\ No newline at end of file
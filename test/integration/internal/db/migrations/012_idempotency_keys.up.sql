@@ -0,0 +1,15 @@
+-- Сохраненные ответы на запросы с заголовком Idempotency-Key.
+-- status_code IS NULL означает, что запрос с этим ключом еще выполняется
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INTEGER,
+    content_type VARCHAR(255) NOT NULL DEFAULT '',
+    response_body BYTEA,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
\ No newline at end of file
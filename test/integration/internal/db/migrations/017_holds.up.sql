@@ -0,0 +1,20 @@
+-- Удержания: монеты списываются с users.coins на системный счет escrow
+-- и затем переводятся получателю (captured) или возвращаются (released, expired)
+CREATE TABLE IF NOT EXISTS holds (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    reference VARCHAR(100) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'captured', 'released', 'expired')),
+    recipient_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    resolved_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_holds_user ON holds (user_id, id);
+CREATE INDEX IF NOT EXISTS idx_holds_expiry ON holds (expires_at) WHERE status = 'active';
+
+-- Записи hold и hold_released ссылаются на удержание
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS hold_id INTEGER REFERENCES holds(id) ON DELETE SET NULL;
\ No newline at end of file
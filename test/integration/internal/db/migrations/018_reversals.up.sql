@@ -0,0 +1,20 @@
+-- Компенсирующие записи (reversal_debit, reversal_credit, refund) ссылаются на исходную запись истории
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reverses_id INTEGER REFERENCES transactions(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_transactions_reverses ON transactions (reverses_id) WHERE reverses_id IS NOT NULL;
+
+-- Запросы отправителей на отмену перевода; выполняются после одобрения администратором
+CREATE TABLE IF NOT EXISTS reversal_requests (
+    id SERIAL PRIMARY KEY,
+    transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+    requester_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'rejected', 'returned')),
+    created_at timestamptz NOT NULL DEFAULT now(),
+    resolved_at timestamptz,
+    resolved_by INTEGER REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- По одному перевод может быть не больше одного запроса, ожидающего решения
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reversal_requests_pending ON reversal_requests (transaction_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_reversal_requests_status ON reversal_requests (status, id);
\ No newline at end of file
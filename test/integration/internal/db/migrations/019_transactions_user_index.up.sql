@@ -0,0 +1,2 @@
+-- История пользователя читается от новых записей к старым
+CREATE INDEX IF NOT EXISTS idx_transactions_user_id ON transactions (user_id, id);
\ No newline at end of file
@@ -0,0 +1,29 @@
+-- Пользователь может скрыть себя из рейтинга
+ALTER TABLE users ADD COLUMN IF NOT EXISTS leaderboard_opt_out BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Переводы между парами пользователей по дням (UTC) за вычетом отмен.
+-- Пары нужны, чтобы считать число разных получателей за произвольный период
+CREATE TABLE IF NOT EXISTS leaderboard_transfers (
+    day DATE NOT NULL,
+    sender_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL,
+    PRIMARY KEY (day, sender_id, recipient_id)
+);
+
+-- Траты на покупки по дням (UTC) за вычетом возвратов
+CREATE TABLE IF NOT EXISTS leaderboard_spending (
+    day DATE NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL,
+    PRIMARY KEY (day, user_id)
+);
+
+-- Последняя запись transactions, учтенная в агрегатах
+CREATE TABLE IF NOT EXISTS leaderboard_state (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    last_transaction_id INTEGER NOT NULL DEFAULT 0,
+    refreshed_at timestamptz
+);
+
+INSERT INTO leaderboard_state (id) VALUES (TRUE) ON CONFLICT DO NOTHING;
\ No newline at end of file
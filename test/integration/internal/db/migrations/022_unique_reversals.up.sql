@@ -0,0 +1,3 @@
+-- Одну запись истории можно отменить только один раз; уникальность защищает от одновременных отмен
+DROP INDEX IF EXISTS idx_transactions_reverses;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_reverses_unique ON transactions (reverses_id) WHERE reverses_id IS NOT NULL;
\ No newline at end of file
@@ -0,0 +1,6 @@
+-- Транзакция, записавшая событие. События отдаются по (tx_id, id) и только от транзакций старше
+-- самой старой незавершенной, поэтому событие, зафиксированное позже события с большим id, не пропускается
+ALTER TABLE events ADD COLUMN IF NOT EXISTS tx_id xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+DROP INDEX IF EXISTS idx_events_user;
+CREATE INDEX IF NOT EXISTS idx_events_user_position ON events (user_id, tx_id, id);
\ No newline at end of file
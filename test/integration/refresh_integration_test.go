@@ -0,0 +1,101 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Avito-trainee/internal/auth"
+	"Avito-trainee/internal/config"
+	"Avito-trainee/internal/db"
+	"Avito-trainee/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshAndLogoutIntegration(t *testing.T) {
+	// Загрузка конфигурации
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	// Подключение к базе данных
+	dbConn, err := db.NewPostgresConnection(cfg.DatabaseURL)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer dbConn.Close()
+
+	// Применение миграций
+	if err := db.RunMigrations(cfg.DatabaseURL); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	// Инициализация сервиса аутентификации
+	jwtManager := newJWTManager(cfg.JWTSecret)
+	authService := auth.NewAuthService(dbConn, jwtManager, auth.Options{AutoRegister: true})
+
+	// Создание роутера
+	r := chi.NewRouter()
+	r.Route("/api", func(r chi.Router) {
+		r.Post("/auth", auth.MakeAuthHandler(authService, newLoginGuard(dbConn)))
+		r.Post("/auth/refresh", auth.MakeRefreshHandler(authService))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.JWTAuthMiddleware(dbConn, jwtManager))
+		r.Post("/api/auth/logout", auth.MakeLogoutHandler(authService))
+	})
+
+	// Создание тестового сервера
+	testServer := httptest.NewServer(r)
+	defer testServer.Close()
+
+	// Первичный вход
+	authJSON, _ := json.Marshal(map[string]string{"username": "refreshuser", "password": "testpassword1"})
+	resp, err := http.Post(testServer.URL+"/api/auth", "application/json", bytes.NewBuffer(authJSON))
+	assert.NoError(t, err)
+	var login auth.Response
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&login))
+	resp.Body.Close()
+	assert.NotEmpty(t, login.RefreshToken)
+
+	// Обмен refresh-токена на новую пару
+	refreshJSON, _ := json.Marshal(auth.RefreshRequest{RefreshToken: login.RefreshToken})
+	resp, err = http.Post(testServer.URL+"/api/auth/refresh", "application/json", bytes.NewBuffer(refreshJSON))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var refreshed auth.Response
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&refreshed))
+	resp.Body.Close()
+
+	// Повторное использование старого refresh-токена должно отзывать всё семейство
+	resp, err = http.Post(testServer.URL+"/api/auth/refresh", "application/json", bytes.NewBuffer(refreshJSON))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	newRefreshJSON, _ := json.Marshal(auth.RefreshRequest{RefreshToken: refreshed.RefreshToken})
+	resp, err = http.Post(testServer.URL+"/api/auth/refresh", "application/json", bytes.NewBuffer(newRefreshJSON))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "token family should be revoked after reuse")
+	resp.Body.Close()
+
+	// После выхода access-токен отклоняется
+	req, _ := http.NewRequest("POST", testServer.URL+"/api/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshed.Token)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	req, _ = http.NewRequest("POST", testServer.URL+"/api/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshed.Token)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "revoked access token should be rejected")
+	resp.Body.Close()
+}
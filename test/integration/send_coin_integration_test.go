@@ -11,6 +11,7 @@ import (
 	"Avito-trainee/internal/auth"
 	"Avito-trainee/internal/config"
 	"Avito-trainee/internal/db"
+	"Avito-trainee/internal/ledger"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -40,7 +41,8 @@ func TestSendCoinIntegration(t *testing.T) {
 	}
 
 	// Создание сервиса аутентификации
-	authService := auth.NewAuthService(dbConn, cfg.JWTSecret)
+	jwtManager := newJWTManager(cfg.JWTSecret)
+	authService := auth.NewAuthService(dbConn, jwtManager, auth.Options{AutoRegister: true})
 
 	// Регистрация первого пользователя (отправитель)
 	username1 := "user1"
@@ -59,7 +61,7 @@ func TestSendCoinIntegration(t *testing.T) {
 	}
 
 	// Создание HTTP-сервера для тестирования
-	router := setupRouter(dbConn, cfg.JWTSecret)
+	router := setupRouter(dbConn, jwtManager)
 
 	// Тестовый запрос на отправку монет
 	reqBody := map[string]interface{}{
@@ -71,7 +73,7 @@ func TestSendCoinIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create request: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+token1)
+	req.Header.Set("Authorization", "Bearer "+token1.AccessToken)
 	req.Header.Set("Content-Type", "application/json")
 
 	// Выполнение запроса
@@ -104,4 +106,24 @@ func TestSendCoinIntegration(t *testing.T) {
 		t.Fatalf("failed to fetch transactions: %v", err)
 	}
 	assert.Equal(t, 1, transactionCount, "there should be one transaction for the transfer")
+
+	// Проверка журнала: sent и received ссылаются на одну запись, балансы совпадают с проводками
+	var entries int
+	err = dbConn.QueryRow(`SELECT COUNT(DISTINCT entry_id) FROM transactions WHERE type IN ('sent', 'received')`).Scan(&entries)
+	if err != nil {
+		t.Fatalf("failed to fetch journal entries: %v", err)
+	}
+	assert.Equal(t, 1, entries, "sent and received rows should share one journal entry")
+
+	for username, want := range map[string]int{username1: 500, username2: 1500} {
+		var userID int
+		if err := dbConn.QueryRow(`SELECT id FROM users WHERE username = $1`, username).Scan(&userID); err != nil {
+			t.Fatalf("failed to fetch user: %v", err)
+		}
+		balance, err := ledger.Balance(context.Background(), dbConn, userID)
+		if err != nil {
+			t.Fatalf("failed to fetch ledger balance: %v", err)
+		}
+		assert.Equal(t, want, balance, "ledger balance should match users.coins")
+	}
 }
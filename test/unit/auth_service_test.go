@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"testing"
+	"time"
 
 	"Avito-trainee/internal/auth"
 	"github.com/DATA-DOG/go-sqlmock"
@@ -21,19 +22,23 @@ func TestAuthenticate_SuccessfulLogin(t *testing.T) {
 	defer db.Close()
 
 	// Инициализируем сервис
-	service := auth.NewAuthService(db, "test-secret")
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
 
 	// Настройка mock-запросов
 	password := "password123"
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	mock.ExpectQuery(`SELECT password_hash FROM users WHERE username = \$1`).
+	mock.ExpectQuery(`SELECT id, password_hash, role FROM users WHERE username = \$1`).
 		WithArgs("user1").
-		WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(string(hashedPassword)))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password_hash", "role"}).AddRow(1, string(hashedPassword), "user"))
+	mock.ExpectExec(`INSERT INTO refresh_tokens \(user_id, token_hash, family_id, expires_at\)`).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Выполняем тест
-	token, err := service.Authenticate(context.Background(), "user1", "password123")
+	tokens, err := service.Authenticate(context.Background(), "user1", "password123")
 	assert.NoError(t, err)
-	assert.NotEmpty(t, token)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
 
 	// Проверяем, что все ожидания выполнены
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -50,14 +55,14 @@ func TestAuthenticate_InvalidCredentials(t *testing.T) {
 	defer db.Close()
 
 	// Инициализируем сервис
-	service := auth.NewAuthService(db, "test-secret")
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
 
 	// Настройка mock-запросов
 	password := "password123"
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	mock.ExpectQuery(`SELECT password_hash FROM users WHERE username = \$1`).
+	mock.ExpectQuery(`SELECT id, password_hash, role FROM users WHERE username = \$1`).
 		WithArgs("user1").
-		WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(string(hashedPassword)))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password_hash", "role"}).AddRow(1, string(hashedPassword), "user"))
 
 	// Выполняем тест
 	_, err = service.Authenticate(context.Background(), "user1", "wrongpassword")
@@ -79,20 +84,138 @@ func TestAuthenticate_NewUserRegistration(t *testing.T) {
 	defer db.Close()
 
 	// Инициализируем сервис
-	service := auth.NewAuthService(db, "test-secret")
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{AutoRegister: true})
 
 	// Настройка mock-запросов
-	mock.ExpectQuery(`SELECT password_hash FROM users WHERE username = \$1`).
+	mock.ExpectQuery(`SELECT id, password_hash, role FROM users WHERE username = \$1`).
 		WithArgs("newuser").
 		WillReturnError(sql.ErrNoRows)
-	mock.ExpectExec(`INSERT INTO users \(username, password_hash, coins\) VALUES \(\$1, \$2, \$3\)`).
+	mock.ExpectQuery(`INSERT INTO users \(username, password_hash, coins\) VALUES \(\$1, \$2, \$3\) ON CONFLICT \(username\) DO NOTHING RETURNING id`).
 		WithArgs("newuser", sqlmock.AnyArg(), 1000).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`INSERT INTO refresh_tokens \(user_id, token_hash, family_id, expires_at\)`).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Выполняем тест
-	token, err := service.Authenticate(context.Background(), "newuser", "password123")
+	tokens, err := service.Authenticate(context.Background(), "newuser", "password123")
 	assert.NoError(t, err)
-	assert.NotEmpty(t, token)
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAuthenticate_UnknownUserWithoutAutoRegister(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
+
+	// Настройка mock-запросов: пользователь не создается
+	mock.ExpectQuery(`SELECT id, password_hash, role FROM users WHERE username = \$1`).
+		WithArgs("typo-user").
+		WillReturnError(sql.ErrNoRows)
+
+	// Выполняем тест
+	_, err = service.Authenticate(context.Background(), "typo-user", "password123")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRegister_Success(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
+
+	// Настройка mock-запросов
+	mock.ExpectQuery(`INSERT INTO users \(username, password_hash, coins\) VALUES \(\$1, \$2, \$3\) ON CONFLICT \(username\) DO NOTHING RETURNING id`).
+		WithArgs("newuser", sqlmock.AnyArg(), 1000).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`INSERT INTO refresh_tokens \(user_id, token_hash, family_id, expires_at\)`).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// Выполняем тест
+	tokens, err := service.Register(context.Background(), "newuser", "password123")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRegister_UsernameTaken(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
+
+	// Настройка mock-запросов: ON CONFLICT DO NOTHING не возвращает строк
+	mock.ExpectQuery(`INSERT INTO users \(username, password_hash, coins\)`).
+		WithArgs("user1", sqlmock.AnyArg(), 1000).
+		WillReturnError(sql.ErrNoRows)
+
+	// Выполняем тест
+	_, err = service.Register(context.Background(), "user1", "password123")
+	assert.ErrorIs(t, err, auth.ErrUserExists)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRegister_PolicyViolations(t *testing.T) {
+	// Создаем mock базы данных: до базы дело доходить не должно
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
+
+	cases := []struct {
+		username string
+		password string
+		want     error
+	}{
+		{"ab", "password123", auth.ErrInvalidUsername},
+		{"user name", "password123", auth.ErrInvalidUsername},
+		{"user1", "short1", auth.ErrWeakPassword},
+		{"user1", "onlyletters", auth.ErrWeakPassword},
+		{"user1", "1234567890", auth.ErrWeakPassword},
+	}
+	for _, c := range cases {
+		_, err := service.Register(context.Background(), c.username, c.password)
+		assert.ErrorIs(t, err, c.want, "%s / %s", c.username, c.password)
+	}
 
 	// Проверяем, что все ожидания выполнены
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -109,10 +232,10 @@ func TestAuthenticate_DatabaseError(t *testing.T) {
 	defer db.Close()
 
 	// Инициализируем сервис
-	service := auth.NewAuthService(db, "test-secret")
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
 
 	// Настройка mock-запросов
-	mock.ExpectQuery(`SELECT password_hash FROM users WHERE username = \$1`).
+	mock.ExpectQuery(`SELECT id, password_hash, role FROM users WHERE username = \$1`).
 		WithArgs("user1").
 		WillReturnError(errors.New("database error"))
 
@@ -126,3 +249,135 @@ func TestAuthenticate_DatabaseError(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %v", err)
 	}
 }
+
+func TestRefresh_RotatesToken(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT rt.id, rt.user_id, rt.family_id, u.username, u.role, rt.expires_at, rt.revoked_at FROM refresh_tokens rt`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "username", "role", "expires_at", "revoked_at"}).
+			AddRow(7, 1, "family1", "user1", "user", time.Now().Add(time.Hour), nil))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = now\(\) WHERE id = \$1`).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO refresh_tokens \(user_id, token_hash, family_id, expires_at\)`).
+		WithArgs(1, sqlmock.AnyArg(), "family1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	tokens, err := service.Refresh(context.Background(), "refresh-token")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEqual(t, "refresh-token", tokens.RefreshToken)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRefresh_ReuseRevokesFamily(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
+
+	// Настройка mock-запросов: токен уже был ротирован ранее
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT rt.id, rt.user_id, rt.family_id, u.username, u.role, rt.expires_at, rt.revoked_at FROM refresh_tokens rt`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "username", "role", "expires_at", "revoked_at"}).
+			AddRow(7, 1, "family1", "user1", "user", time.Now().Add(time.Hour), time.Now().Add(-time.Minute)))
+	mock.ExpectExec(`UPDATE refresh_tokens SET revoked_at = now\(\) WHERE family_id = \$1 AND revoked_at IS NULL`).
+		WithArgs("family1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	_, err = service.Refresh(context.Background(), "refresh-token")
+	assert.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRefresh_UnknownToken(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT rt.id, rt.user_id, rt.family_id, u.username, u.role, rt.expires_at, rt.revoked_at FROM refresh_tokens rt`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	_, err = service.Refresh(context.Background(), "unknown")
+	assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+// newTestJWTManager создает менеджер токенов с тестовым симметричным ключом
+func newTestJWTManager() *auth.JWTManager {
+	return auth.NewJWTManager(auth.NewHMACKeySet("test-secret"), auth.AccessTokenDuration)
+}
+
+func TestSetRole(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := auth.NewAuthService(db, newTestJWTManager(), auth.Options{})
+
+	// Настройка mock-запросов
+	mock.ExpectExec(`UPDATE users SET role = \$1 WHERE username = \$2`).
+		WithArgs("admin", "user1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users SET role = \$1 WHERE username = \$2`).
+		WithArgs("auditor", "ghost").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Выполняем тест
+	assert.NoError(t, service.SetRole(context.Background(), "user1", "admin"))
+	assert.ErrorIs(t, service.SetRole(context.Background(), "ghost", "auditor"), auth.ErrUserNotFound)
+	assert.ErrorIs(t, service.SetRole(context.Background(), "user1", "superuser"), auth.ErrInvalidRole)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"Avito-trainee/internal/balance"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrant_Success(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := balance.NewBalanceService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, username, coins FROM users WHERE username = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "coins"}).
+			AddRow(2, "user2", 100).
+			AddRow(3, "user3", 0))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = ANY\(\$2\)`).
+		WithArgs(50, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	expectLedgerPost(mock, "grant", 10)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, counterparty, amount, reason, entry_id\)`).
+		WithArgs(sqlmock.AnyArg(), "grant", 50, "hackathon winners", 1, 10).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	err = service.Grant(context.Background(), 1, []string{"user2", " user3 ", "user2"}, 50, "hackathon winners")
+	assert.NoError(t, err)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGrant_UserNotFound(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := balance.NewBalanceService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, username, coins FROM users WHERE username = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "coins"}).AddRow(2, "user2", 100))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	err = service.Grant(context.Background(), 1, []string{"user2", "ghost"}, 50, "bonus")
+	var notFound *balance.UsersNotFoundError
+	if assert.True(t, errors.As(err, &notFound)) {
+		assert.Equal(t, []string{"ghost"}, notFound.Usernames)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeduct_InsufficientFunds(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := balance.NewBalanceService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, username, coins FROM users WHERE username = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "coins"}).
+			AddRow(2, "user2", 100).
+			AddRow(3, "user3", 10))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	err = service.Deduct(context.Background(), 1, []string{"user2", "user3"}, 50, "duplicate grant")
+	assert.ErrorIs(t, err, balance.ErrInsufficientFunds)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAdjust_WritesSignedDelta(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := balance.NewBalanceService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, username, coins FROM users WHERE username = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "coins"}).AddRow(2, "user2", 1000))
+	mock.ExpectExec(`UPDATE users SET coins = \$1 WHERE id = \$2`).
+		WithArgs(700, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLedgerPost(mock, "adjustment", 11)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, counterparty, amount, reason, entry_id\)`).
+		WithArgs(2, "adjustment", -300, "manual fix", 1, 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	err = service.Adjust(context.Background(), 1, []balance.Correction{{Username: "user2", Balance: 700}}, "manual fix")
+	assert.NoError(t, err)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestBalanceOperations_Validation(t *testing.T) {
+	// Создаем mock базы данных: до базы дело доходить не должно
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := balance.NewBalanceService(db)
+
+	// Выполняем тест
+	assert.ErrorIs(t, service.Grant(context.Background(), 1, []string{"user2"}, 0, "bonus"), balance.ErrInvalidAmount)
+	assert.ErrorIs(t, service.Grant(context.Background(), 1, []string{"user2"}, 10, "  "), balance.ErrReasonRequired)
+	assert.ErrorIs(t, service.Deduct(context.Background(), 1, nil, 10, "fine"), balance.ErrNoUsers)
+	assert.ErrorIs(t, service.Adjust(context.Background(), 1, []balance.Correction{{Username: "user2", Balance: -1}}, "fix"), balance.ErrInvalidBalance)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"Avito-trainee/internal/cart"
+	"Avito-trainee/internal/merch"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckout_Success(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := cart.NewCartService(db)
+
+	// Настройка mock-запросов: 5 ручек без ограничения и 1 худи из ограниченной партии
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(1000))
+	mock.ExpectQuery(`SELECT c.item_slug, c.quantity, m.price, m.active, m.stock IS NOT NULL FROM cart_items c`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"item_slug", "quantity", "price", "active", "limited"}).
+			AddRow("hoody", 1, 300, true, true).
+			AddRow("pen", 5, 10, true, false))
+	// Ручки без ограничения запаса блокируются FOR SHARE, худи - резервированием запаса
+	mock.ExpectQuery(`SELECT slug, price, active FROM merch_items WHERE slug = ANY\(\$1\) AND stock IS NULL ORDER BY slug FOR SHARE`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "price", "active"}).AddRow("pen", 10, true))
+	mock.ExpectQuery(`UPDATE merch_items SET stock = stock - \$2`).
+		WithArgs("hoody", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(300))
+	mock.ExpectExec(`UPDATE users SET coins = coins - \$1 WHERE id = \$2`).
+		WithArgs(350, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO orders \(user_id, total\) VALUES \(\$1, \$2\) RETURNING id`).
+		WithArgs(1, 350).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(`INSERT INTO order_items \(order_id, item_slug, quantity, price\)`).
+		WithArgs(7, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	expectLedgerPost(mock, "purchase", 9)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, merch, amount, quantity, order_id, entry_id\)`).
+		WithArgs(1, "purchased", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 7, 9).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO inventory \(user_id, item_type, quantity\)`).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM cart_items WHERE user_id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	order, err := service.Checkout(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, order.ID)
+	assert.Equal(t, 350, order.Total)
+	assert.Len(t, order.Items, 2)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCheckout_SoldOutRollsBack(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := cart.NewCartService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(1000))
+	mock.ExpectQuery(`SELECT c.item_slug, c.quantity, m.price, m.active, m.stock IS NOT NULL FROM cart_items c`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"item_slug", "quantity", "price", "active", "limited"}).
+			AddRow("hoody", 3, 300, true, true))
+	mock.ExpectQuery(`SELECT slug, price, active FROM merch_items WHERE slug = ANY\(\$1\) AND stock IS NULL ORDER BY slug FOR SHARE`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "price", "active"}))
+	mock.ExpectQuery(`UPDATE merch_items SET stock = stock - \$2`).
+		WithArgs("hoody", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"price"}))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	_, err = service.Checkout(context.Background(), 1)
+	assert.ErrorIs(t, err, merch.ErrSoldOut)
+	var unavailable *cart.UnavailableError
+	if assert.True(t, errors.As(err, &unavailable)) {
+		assert.Equal(t, "hoody", unavailable.Item)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCheckout_InsufficientCoins(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := cart.NewCartService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(40))
+	mock.ExpectQuery(`SELECT c.item_slug, c.quantity, m.price, m.active, m.stock IS NOT NULL FROM cart_items c`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"item_slug", "quantity", "price", "active", "limited"}).
+			AddRow("pen", 5, 10, true, false))
+	mock.ExpectQuery(`SELECT slug, price, active FROM merch_items WHERE slug = ANY\(\$1\) AND stock IS NULL ORDER BY slug FOR SHARE`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "price", "active"}).AddRow("pen", 10, true))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	_, err = service.Checkout(context.Background(), 1)
+	assert.ErrorIs(t, err, cart.ErrInsufficientCoins)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAddItem_QuantityLimit(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := cart.NewCartService(db)
+
+	// Настройка mock-запросов: в корзине уже 95 ручек
+	mock.ExpectExec(`INSERT INTO cart_items \(user_id, item_slug, quantity\)`).
+		WithArgs(1, "pen", 10, 100).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM cart_items WHERE user_id = \$1 AND item_slug = \$2\)`).
+		WithArgs(1, "pen").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	// Выполняем тест
+	_, err = service.AddItem(context.Background(), 1, "pen", 10)
+	assert.ErrorIs(t, err, cart.ErrQuantityTooLarge)
+	_, err = service.AddItem(context.Background(), 1, "pen", 0)
+	assert.ErrorIs(t, err, cart.ErrInvalidQuantity)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCheckout_UsesLockedPrice(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := cart.NewCartService(db)
+
+	// Цена ручек выросла до 12, пока заказ ждал блокировку: 5 ручек по старой цене хватило бы, по новой - нет
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(55))
+	mock.ExpectQuery(`SELECT c.item_slug, c.quantity, m.price, m.active, m.stock IS NOT NULL FROM cart_items c`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"item_slug", "quantity", "price", "active", "limited"}).
+			AddRow("pen", 5, 10, true, false))
+	mock.ExpectQuery(`SELECT slug, price, active FROM merch_items WHERE slug = ANY\(\$1\) AND stock IS NULL ORDER BY slug FOR SHARE`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "price", "active"}).AddRow("pen", 12, true))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	_, err = service.Checkout(context.Background(), 1)
+	assert.ErrorIs(t, err, cart.ErrInsufficientCoins)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
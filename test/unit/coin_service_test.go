@@ -3,10 +3,13 @@ package unit
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"strings"
 	"testing"
 
 	"Avito-trainee/internal/coin"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,22 +32,23 @@ func TestSendCoin_Success(t *testing.T) {
 	mock.ExpectQuery(`SELECT username FROM users WHERE id = \$1`).
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("user1"))
-	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
-		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(500))
+	mock.ExpectQuery(`SELECT id, coins FROM users WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "coins"}).AddRow(1, 500).AddRow(2, 1000))
 	mock.ExpectExec(`UPDATE users SET coins = coins \- \$1 WHERE id = \$2`).
 		WithArgs(100, 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
 		WithArgs(100, 2).
 		WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, counterparty, amount\) VALUES \(\$1, 'sent', \$2, \$3\), \(\$4, 'received', \$5, \$6\)`).
-		WithArgs(1, "user2", 100, 2, "user1", 100).
+	expectLedgerPost(mock, "transfer", 5)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, counterparty, amount, entry_id, message, tags\) VALUES \(\$1, 'sent', \$2, \$3, \$7, \$8, \$9\), \(\$4, 'received', \$5, \$6, \$7, \$8, \$9\)`).
+		WithArgs(1, "user2", 100, 2, "user1", 100, 5, "Thanks for the review!", "{\"help\",\"review\"}").
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
 	// Выполняем тест
-	err = service.SendCoin(context.Background(), 1, "user2", 100)
+	err = service.SendCoin(context.Background(), 1, "user2", 100, coin.Memo{Message: " Thanks\u202e for\n\n the review!\x00", Tags: []string{"#Help", "review", "help"}})
 	assert.NoError(t, err)
 
 	// Проверяем, что все ожидания выполнены
@@ -72,13 +76,13 @@ func TestSendCoin_InsufficientFunds(t *testing.T) {
 	mock.ExpectQuery(`SELECT username FROM users WHERE id = \$1`).
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("user1"))
-	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
-		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(50))
+	mock.ExpectQuery(`SELECT id, coins FROM users WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "coins"}).AddRow(1, 50).AddRow(2, 1000))
 	mock.ExpectRollback()
 
 	// Выполняем тест
-	err = service.SendCoin(context.Background(), 1, "user2", 100)
+	err = service.SendCoin(context.Background(), 1, "user2", 100, coin.Memo{})
 	assert.Error(t, err)
 	assert.Equal(t, coin.ErrInsufficientFunds, err)
 
@@ -110,7 +114,7 @@ func TestSendCoin_SameUser(t *testing.T) {
 	mock.ExpectRollback()
 
 	// Выполняем тест
-	err = service.SendCoin(context.Background(), 1, "user1", 100)
+	err = service.SendCoin(context.Background(), 1, "user1", 100, coin.Memo{})
 	assert.Error(t, err)
 	assert.Equal(t, coin.ErrSameUser, err)
 
@@ -139,9 +143,202 @@ func TestSendCoin_UserNotFound(t *testing.T) {
 	mock.ExpectRollback()
 
 	// Выполняем тест
-	err = service.SendCoin(context.Background(), 1, "nonexistent_user", 100)
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "user not found")
+	err = service.SendCoin(context.Background(), 1, "nonexistent_user", 100, coin.Memo{})
+	assert.ErrorIs(t, err, coin.ErrUserNotFound)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSendCoin_MemoValidation(t *testing.T) {
+	// Создаем mock базы данных: до базы дело доходить не должно
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := coin.NewCoinService(db)
+
+	// Выполняем тест
+	long := coin.Memo{Message: strings.Repeat("спасибо ", 30)}
+	assert.ErrorIs(t, service.SendCoin(context.Background(), 1, "user2", 10, long), coin.ErrMessageTooLong)
+	badTag := coin.Memo{Tags: []string{"<script>"}}
+	assert.ErrorIs(t, service.SendCoin(context.Background(), 1, "user2", 10, badTag), coin.ErrInvalidTag)
+	manyTags := coin.Memo{Tags: []string{"a", "b", "c", "d", "e", "f"}}
+	assert.ErrorIs(t, service.SendCoin(context.Background(), 1, "user2", 10, manyTags), coin.ErrTooManyTags)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSendCoinBatch_Success(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := coin.NewCoinService(db)
+
+	// Настройка mock-запросов: отправитель и получатели блокируются одним запросом
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, username, coins FROM users WHERE id = \$1 OR username = ANY\(\$2\) ORDER BY id FOR UPDATE`).
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "coins"}).
+			AddRow(1, "user1", 0).
+			AddRow(2, "lead", 500).
+			AddRow(3, "user3", 0))
+	mock.ExpectExec(`UPDATE users SET coins = coins - \$1 WHERE id = \$2`).
+		WithArgs(150, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users SET coins = users.coins \+ p.amount FROM unnest`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	expectLedgerPost(mock, "transfer", 6)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, counterparty, amount, entry_id, message, tags\)`).
+		WithArgs(2, sqlmock.AnyArg(), sqlmock.AnyArg(), 6, "Great sprint", sqlmock.AnyArg(), "lead").
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	err = service.SendCoinBatch(context.Background(), 2, []coin.Transfer{
+		{ToUser: "user3", Amount: 100},
+		{ToUser: "user1", Amount: 50},
+	}, coin.Memo{Message: "Great sprint"})
+	assert.NoError(t, err)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSendCoinBatch_RecipientNotFound(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := coin.NewCoinService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, username, coins FROM users WHERE id = \$1 OR username = ANY\(\$2\) ORDER BY id FOR UPDATE`).
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "coins"}).
+			AddRow(2, "lead", 500).
+			AddRow(3, "user3", 0))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	err = service.SendCoinBatch(context.Background(), 2, []coin.Transfer{
+		{ToUser: "user3", Amount: 100},
+		{ToUser: "ghost", Amount: 50},
+	}, coin.Memo{})
+	var notFound *coin.RecipientsNotFoundError
+	if assert.True(t, errors.As(err, &notFound)) {
+		assert.Equal(t, []string{"ghost"}, notFound.Usernames)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSendCoinBatch_InsufficientFunds(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := coin.NewCoinService(db)
+
+	// Настройка mock-запросов: по отдельности каждый перевод проходит, вместе - нет
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, username, coins FROM users WHERE id = \$1 OR username = ANY\(\$2\) ORDER BY id FOR UPDATE`).
+		WithArgs(2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "coins"}).
+			AddRow(1, "user1", 0).
+			AddRow(2, "lead", 120).
+			AddRow(3, "user3", 0))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	err = service.SendCoinBatch(context.Background(), 2, []coin.Transfer{
+		{ToUser: "user3", Amount: 100},
+		{ToUser: "user1", Amount: 50},
+	}, coin.Memo{})
+	assert.ErrorIs(t, err, coin.ErrInsufficientFunds)
+
+	// Валидация пакета до обращения к базе
+	err = service.SendCoinBatch(context.Background(), 2, []coin.Transfer{{ToUser: "user1", Amount: 1}, {ToUser: "user1", Amount: 2}}, coin.Memo{})
+	assert.ErrorIs(t, err, coin.ErrDuplicateRecipient)
+	err = service.SendCoinBatch(context.Background(), 2, []coin.Transfer{{ToUser: "user1", Amount: 0}}, coin.Memo{})
+	assert.ErrorIs(t, err, coin.ErrPolicyViolation)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSendCoin_RetriesDeadlock(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := coin.NewCoinService(db)
+
+	// Настройка mock-запросов: первая попытка обнаруживает взаимоблокировку, вторая проходит
+	expectLookup := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM users WHERE username = \$1`).
+			WithArgs("user2").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectQuery(`SELECT username FROM users WHERE id = \$1`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("user1"))
+	}
+	expectLookup()
+	mock.ExpectQuery(`SELECT id, coins FROM users WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WillReturnError(&pq.Error{Code: "40P01", Message: "deadlock detected"})
+	mock.ExpectRollback()
+	expectLookup()
+	mock.ExpectQuery(`SELECT id, coins FROM users WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "coins"}).AddRow(1, 500).AddRow(2, 1000))
+	mock.ExpectExec(`UPDATE users SET coins = coins \- \$1 WHERE id = \$2`).
+		WithArgs(100, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(100, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLedgerPost(mock, "transfer", 5)
+	mock.ExpectExec(`INSERT INTO transactions`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	err = service.SendCoin(context.Background(), 1, "user2", 100, coin.Memo{})
+	assert.NoError(t, err)
 
 	// Проверяем, что все ожидания выполнены
 	if err := mock.ExpectationsWereMet(); err != nil {
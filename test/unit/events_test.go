@@ -0,0 +1,172 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/events"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var eventColumns = []string{"id", "tx_id", "type", "payload"}
+
+func TestEventsHub_Publish(t *testing.T) {
+	hub := events.NewHub()
+	sub := hub.Subscribe(1)
+	other := hub.Subscribe(2)
+
+	// Сигналы одному пользователю схлопываются, другой пользователь их не получает
+	hub.Publish(1)
+	hub.Publish(1)
+	assert.Len(t, sub.C, 1)
+	assert.Len(t, other.C, 0)
+	<-sub.C
+
+	// После переподключения к базе будятся все
+	hub.WakeAll()
+	assert.Len(t, sub.C, 1)
+	assert.Len(t, other.C, 1)
+
+	// Отписка закрывает канал, повторная не падает
+	hub.Unsubscribe(sub)
+	hub.Unsubscribe(sub)
+	<-sub.C
+	_, ok := <-sub.C
+	assert.False(t, ok)
+
+	// После Close подписки закрыты, новые сразу получают закрытый канал
+	hub.Close()
+	<-other.C
+	_, ok = <-other.C
+	assert.False(t, ok)
+	_, ok = <-hub.Subscribe(3).C
+	assert.False(t, ok)
+}
+
+func TestEventsCursor(t *testing.T) {
+	cursor, err := events.ParseCursor("12-105")
+	assert.NoError(t, err)
+	assert.Equal(t, events.Cursor{TxID: 12, ID: 105}, cursor)
+	assert.Equal(t, "12-105", cursor.String())
+
+	for _, invalid := range []string{"105", "a-1", "1-", "-1-2"} {
+		_, err := events.ParseCursor(invalid)
+		assert.ErrorIs(t, err, events.ErrInvalidCursor, invalid)
+	}
+}
+
+func TestEventsHandler_Replay(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	hub := events.NewHub()
+	handler := events.MakeEventsHandler(hub, events.NewEventsService(db))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r.WithContext(context.WithValue(r.Context(), "userID", 1)))
+	}))
+	defer server.Close()
+	defer hub.Close()
+
+	const after = `SELECT id, tx_id, type, payload FROM events WHERE user_id = \$1 AND \(tx_id, id\) > \(\$2::xid8, \$3\) AND tx_id < pg_snapshot_xmin\(pg_current_snapshot\(\)\) ORDER BY tx_id, id LIMIT \$4`
+
+	// Событие 104 зафиксировано позже события 105, но идет раньше: его транзакция началась раньше
+	page := sqlmock.NewRows(eventColumns)
+	for i := 0; i < 499; i++ {
+		page.AddRow(200+i, 11, "balance.changed", []byte(`{}`))
+	}
+	page.AddRow(104, 12, "coins.received", []byte(`{"from":"user2","amount":10}`))
+	mock.ExpectQuery(after).
+		WithArgs(1, 10, 5, 500).
+		WillReturnRows(page)
+	// Полная страница: чтение продолжается с последнего отданного события, а не обрывается
+	mock.ExpectQuery(after).
+		WithArgs(1, 12, 104, 500).
+		WillReturnRows(sqlmock.NewRows(eventColumns).
+			AddRow(105, 13, "balance.changed", []byte(`{"balance":1010,"change":10}`)))
+
+	// Выполняем тест
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Last-Event-ID", "10-5")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("stream closed: %v", err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, "retry: 3000\n", readEvent())
+	for i := 0; i < 499; i++ {
+		readEvent()
+	}
+	assert.Equal(t, "id: 12-104\nevent: coins.received\ndata: {\"from\":\"user2\",\"amount\":10}\n", readEvent())
+	assert.Equal(t, "id: 13-105\nevent: balance.changed\ndata: {\"balance\":1010,\"change\":10}\n", readEvent())
+
+	// Уведомление будит поток, новые события читаются из таблицы после последнего отданного
+	mock.ExpectQuery(after).
+		WithArgs(1, 13, 105, 500).
+		WillReturnRows(sqlmock.NewRows(eventColumns).
+			AddRow(106, 14, "purchase.completed", []byte(`{"item":"cup"}`)))
+	hub.Publish(1)
+	assert.Equal(t, "id: 14-106\nevent: purchase.completed\ndata: {\"item\":\"cup\"}\n", readEvent())
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestEventsOldestTransaction(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := events.NewEventsService(db)
+	const oldest = `SELECT pid, EXTRACT\(EPOCH FROM now\(\) - xact_start\), left\(query, 200\) FROM pg_stat_activity WHERE backend_xid IS NOT NULL`
+
+	// Пишущая транзакция идет полторы минуты
+	mock.ExpectQuery(oldest).
+		WillReturnRows(sqlmock.NewRows([]string{"pid", "age", "query"}).AddRow(4242, 90.5, "UPDATE users SET coins = 0"))
+	tx, ok, err := service.OldestTransaction(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, events.Transaction{PID: 4242, Age: 90500 * time.Millisecond, Query: "UPDATE users SET coins = 0"}, tx)
+
+	// Пишущих транзакций нет
+	mock.ExpectQuery(oldest).
+		WillReturnRows(sqlmock.NewRows([]string{"pid", "age", "query"}))
+	_, ok, err = service.OldestTransaction(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/hold"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var holdColumns = []string{"id", "user", "amount", "reference", "status", "to_user", "created_at", "expires_at", "resolved_at"}
+
+func TestHoldPlace_Success(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := hold.NewHoldService(db, 24*time.Hour)
+	now := time.Now()
+
+	// Монеты списываются с баланса на счет escrow
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(500))
+	mock.ExpectExec(`UPDATE users SET coins = coins \- \$1 WHERE id = \$2`).
+		WithArgs(200, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO holds \(user_id, amount, reference, expires_at\)`).
+		WithArgs(1, 200, "auction-42", int64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	expectLedgerPost(mock, "hold", 11)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, amount, reason, entry_id, hold_id\)`).
+		WithArgs(1, "hold", 200, "auction-42", 11, 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`FROM holds h .+ WHERE h.id = \$1`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(holdColumns).AddRow(9, "user1", 200, "auction-42", "active", "", now, now.Add(time.Hour), nil))
+
+	// Выполняем тест
+	h, err := service.Place(context.Background(), 1, 200, "auction-42", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, hold.StatusActive, h.Status)
+	assert.Equal(t, 200, h.Amount)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHoldPlace_InsufficientFunds(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := hold.NewHoldService(db, 24*time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(100))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	_, err = service.Place(context.Background(), 1, 200, "", 0)
+	assert.ErrorIs(t, err, hold.ErrInsufficientFunds)
+
+	// Срок больше 30 дней отклоняется до обращения к базе
+	_, err = service.Place(context.Background(), 1, 200, "", 31*24*time.Hour)
+	assert.ErrorIs(t, err, hold.ErrInvalidTTL)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHoldCapture_Success(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := hold.NewHoldService(db, 24*time.Hour)
+	now := time.Now()
+
+	// Удержанные монеты переводятся получателю со счета escrow
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT h.user_id, u.username, .+ FOR UPDATE OF h`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "amount", "reference", "status", "expired"}).
+			AddRow(1, "user1", 200, "auction-42", "active", false))
+	mock.ExpectQuery(`SELECT id FROM users WHERE username = \$1`).
+		WithArgs("seller").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(200, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLedgerPost(mock, "hold_capture", 12)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, counterparty, amount, reason, message, entry_id, hold_id\)`).
+		WithArgs(1, 3, 200, "seller", "auction-42", 12, 9, "user1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE holds SET status = 'captured', recipient_id = \$2, resolved_at = now\(\) WHERE id = \$1`).
+		WithArgs(9, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`FROM holds h .+ WHERE h.id = \$1`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(holdColumns).AddRow(9, "user1", 200, "auction-42", "captured", "seller", now, now.Add(time.Hour), now))
+
+	// Выполняем тест
+	h, err := service.Capture(context.Background(), 9, "seller")
+	assert.NoError(t, err)
+	assert.Equal(t, hold.StatusCaptured, h.Status)
+	assert.Equal(t, "seller", h.ToUser)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHoldCapture_Expired(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := hold.NewHoldService(db, 24*time.Hour)
+
+	// Истекшее удержание нельзя перевести, только вернуть
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE OF h`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "amount", "reference", "status", "expired"}).
+			AddRow(1, "user1", 200, "", "active", true))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	_, err = service.Capture(context.Background(), 9, "seller")
+	assert.ErrorIs(t, err, hold.ErrHoldExpired)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHoldExpireDue_ReleasesToOwner(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := hold.NewHoldService(db, 24*time.Hour)
+
+	// Истекшее удержание возвращается владельцу со статусом expired
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE OF h SKIP LOCKED`).
+		WithArgs(100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username", "amount", "reference"}).
+			AddRow(9, 1, "user1", 200, "auction-42"))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(200, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLedgerPost(mock, "hold_release", 13)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, amount, reason, entry_id, hold_id\)`).
+		WithArgs(1, "hold_released", 200, "auction-42", 13, 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE holds SET status = \$2, resolved_at = now\(\) WHERE id = \$1`).
+		WithArgs(9, hold.StatusExpired).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	expired, err := service.ExpireDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
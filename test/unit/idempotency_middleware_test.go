@@ -0,0 +1,190 @@
+package unit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/middleware"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// newIdempotentRequest создает запрос аутентифицированного пользователя с ключом идемпотентности
+func newIdempotentRequest(body string) *http.Request {
+	req := httptest.NewRequest("POST", "/api/sendCoin", strings.NewReader(body))
+	req.Header.Set(middleware.IdempotencyKeyHeader, "key-1")
+	return req.WithContext(context.WithValue(req.Context(), "userID", 1))
+}
+
+func TestIdempotency_FirstRequestIsStored(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	calls := 0
+	handler := middleware.Idempotency(db, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Coins sent successfully"))
+	}))
+
+	// Настройка mock-запросов
+	mock.ExpectQuery(`INSERT INTO idempotency_keys \(user_id, key, request_hash, expires_at\)`).
+		WithArgs(1, "key-1", sqlmock.AnyArg(), float64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"claimed"}).AddRow(true))
+	mock.ExpectExec(`UPDATE idempotency_keys SET status_code = \$3, content_type = \$4, response_body = \$5`).
+		WithArgs(1, "key-1", http.StatusOK, "", []byte("Coins sent successfully")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Выполняем тест
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest(`{"toUser":"user2","amount":10}`))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, calls)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestIdempotency_KeepsKeyWhenSaveFails(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	handler := middleware.Idempotency(db, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Coins sent successfully"))
+	}))
+
+	// Ответ не сохранился после всех попыток: перевод уже выполнен, поэтому ключ не удаляется
+	// и повтор получит 409, а не второй перевод
+	mock.ExpectQuery(`INSERT INTO idempotency_keys \(user_id, key, request_hash, expires_at\)`).
+		WithArgs(1, "key-1", sqlmock.AnyArg(), float64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"claimed"}).AddRow(true))
+	for i := 0; i < 3; i++ {
+		mock.ExpectExec(`UPDATE idempotency_keys SET status_code = \$3`).
+			WithArgs(1, "key-1", http.StatusOK, "", []byte("Coins sent successfully")).
+			WillReturnError(errors.New("connection reset"))
+	}
+
+	// Выполняем тест
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest(`{"toUser":"user2","amount":10}`))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestIdempotency_SaveRetried(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	handler := middleware.Idempotency(db, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Coins sent successfully"))
+	}))
+
+	// Временная ошибка при сохранении ответа повторяется
+	mock.ExpectQuery(`INSERT INTO idempotency_keys \(user_id, key, request_hash, expires_at\)`).
+		WithArgs(1, "key-1", sqlmock.AnyArg(), float64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"claimed"}).AddRow(true))
+	mock.ExpectExec(`UPDATE idempotency_keys SET status_code = \$3`).
+		WithArgs(1, "key-1", http.StatusOK, "", []byte("Coins sent successfully")).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectExec(`UPDATE idempotency_keys SET status_code = \$3`).
+		WithArgs(1, "key-1", http.StatusOK, "", []byte("Coins sent successfully")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Выполняем тест
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest(`{"toUser":"user2","amount":10}`))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestIdempotency_ReplayAndMismatch(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	calls := 0
+	handler := middleware.Idempotency(db, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	// Хеш первого запроса узнаем, сохранив его через mock
+	var storedHash string
+	mock.ExpectQuery(`INSERT INTO idempotency_keys`).
+		WithArgs(1, "key-1", hashCapture{&storedHash}, float64(3600)).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT request_hash, status_code, content_type, response_body FROM idempotency_keys`).
+		WithArgs(1, "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+			AddRow("placeholder", http.StatusOK, "", []byte("Coins sent successfully")))
+
+	// Повтор с другим телом
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest(`{"toUser":"user2","amount":20}`))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// Повтор с тем же телом получает сохраненный ответ
+	mock.ExpectQuery(`INSERT INTO idempotency_keys`).
+		WithArgs(1, "key-1", sqlmock.AnyArg(), float64(3600)).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT request_hash, status_code, content_type, response_body FROM idempotency_keys`).
+		WithArgs(1, "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "response_body"}).
+			AddRow(storedHash, http.StatusOK, "", []byte("Coins sent successfully")))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newIdempotentRequest(`{"toUser":"user2","amount":20}`))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get(middleware.IdempotentReplayHeader))
+	assert.Equal(t, "Coins sent successfully", rr.Body.String())
+	assert.Equal(t, 0, calls, "handler must not run for retries")
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+// hashCapture Аргумент sqlmock, запоминающий переданный хеш запроса
+type hashCapture struct {
+	hash *string
+}
+
+func (c hashCapture) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*c.hash = s
+	return ok
+}
@@ -0,0 +1,115 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/info"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var purchaseColumns = []string{"id", "merch", "quantity", "amount", "order_id", "refunded", "created_at"}
+
+// expectInfoBase Баланс, инвентарь и история переводов без записей
+func expectInfoBase(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT coins, \(SELECT COALESCE\(SUM\(amount\), 0\) FROM holds`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins", "held"}).AddRow(700, 100))
+	mock.ExpectQuery(`SELECT item_type, quantity FROM inventory WHERE user_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"item_type", "quantity"}).AddRow("cup", 3))
+	mock.ExpectQuery(`type = 'received'`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "counterparty", "amount", "message", "tags"}))
+	mock.ExpectQuery(`type = 'sent'`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "counterparty", "amount", "message", "tags"}))
+	mock.ExpectQuery(`type IN \('grant', 'deduction'`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "amount", "reason"}))
+}
+
+func TestGetInfo_GroupedPurchases(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := info.NewInfoService(db)
+	first := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	second := first.Add(48 * time.Hour)
+
+	// Две покупки чашек (одна через корзину) и возвращенная футболка
+	expectInfoBase(mock)
+	mock.ExpectQuery(`WHERE t.user_id = \$1 AND t.type = 'purchased' ORDER BY t.id`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(purchaseColumns).
+			AddRow(5, "cup", 1, 20, nil, false, first).
+			AddRow(7, "t-shirt", 1, 80, nil, true, first.Add(time.Hour)).
+			AddRow(9, "cup", 2, 40, 3, false, second))
+
+	// Выполняем тест
+	response, err := service.GetInfo(context.Background(), 1, info.Options{GroupPurchases: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 700, response.Available)
+	assert.Equal(t, 100, response.Held)
+	assert.Nil(t, response.Purchases)
+	if assert.Len(t, response.PurchasesByItem, 2) {
+		cups := response.PurchasesByItem[0]
+		assert.Equal(t, "cup", cups.Item)
+		assert.Equal(t, 3, cups.Quantity)
+		assert.Equal(t, 60, cups.Spent)
+		assert.Equal(t, first, cups.FirstPurchasedAt)
+		assert.Equal(t, second, cups.LastPurchasedAt)
+		if assert.Len(t, cups.Purchases, 2) {
+			assert.Equal(t, 20, cups.Purchases[1].Price)
+			assert.Equal(t, 3, *cups.Purchases[1].OrderID)
+		}
+
+		// Возвращенная покупка остается в истории, но не учитывается в итогах
+		shirts := response.PurchasesByItem[1]
+		assert.True(t, shirts.Purchases[0].Refunded)
+		assert.Equal(t, 0, shirts.Quantity)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetInfo_Purchases(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := info.NewInfoService(db)
+	now := time.Now()
+
+	expectInfoBase(mock)
+	mock.ExpectQuery(`t.type = 'purchased'`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(purchaseColumns).AddRow(5, "cup", 1, 20, nil, false, now))
+
+	// Выполняем тест
+	response, err := service.GetInfo(context.Background(), 1, info.Options{})
+	assert.NoError(t, err)
+	assert.Nil(t, response.PurchasesByItem)
+	if assert.Len(t, response.Purchases, 1) {
+		assert.Equal(t, "cup", response.Purchases[0].Item)
+		assert.Equal(t, 20, response.Purchases[0].Price)
+		assert.Equal(t, now, response.Purchases[0].PurchasedAt)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
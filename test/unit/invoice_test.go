@@ -0,0 +1,176 @@
+package unit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/coin"
+	"Avito-trainee/internal/invoice"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubCoinService Перевод, который запоминает аргументы и возвращает заданную ошибку
+type stubCoinService struct {
+	err  error
+	from int
+	to   string
+	sent int
+	memo coin.Memo
+}
+
+func (s *stubCoinService) SendCoin(ctx context.Context, fromUserID int, toUsername string, amount int, memo coin.Memo) error {
+	s.from, s.to, s.sent, s.memo = fromUserID, toUsername, amount, memo
+	return s.err
+}
+
+func (s *stubCoinService) SendCoinTx(ctx context.Context, tx *sql.Tx, fromUserID int, toUsername string, amount int, memo coin.Memo) error {
+	return s.SendCoin(ctx, fromUserID, toUsername, amount, memo)
+}
+
+func (s *stubCoinService) SendCoinBatch(ctx context.Context, fromUserID int, transfers []coin.Transfer, memo coin.Memo) error {
+	return s.err
+}
+
+var requestColumns = []string{"id", "from", "to", "amount", "note", "status", "created_at", "expires_at", "resolved_at"}
+
+func TestInvoiceAccept_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	coins := &stubCoinService{}
+	service := invoice.NewInvoiceService(db, coins, time.Hour)
+	now := time.Now()
+
+	// Запрос переводится в accepted, и в той же транзакции выполняется перевод автору запроса
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE payment_requests p SET status = 'accepted'`).
+		WithArgs(7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "amount", "note"}).AddRow("user1", 50, "pizza"))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT p.id, r.username, u.username, .+ WHERE p.id = \$1`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(requestColumns).AddRow(7, "user1", "user2", 50, "pizza", "accepted", now, now.Add(time.Hour), now))
+
+	request, err := service.Accept(context.Background(), 2, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, invoice.StatusAccepted, request.Status)
+	assert.NotNil(t, request.ResolvedAt)
+	assert.Equal(t, 2, coins.from)
+	assert.Equal(t, "user1", coins.to)
+	assert.Equal(t, 50, coins.sent)
+	assert.Equal(t, "pizza", coins.memo.Message)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestInvoiceAccept_TransferFailedStaysPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	coins := &stubCoinService{err: coin.ErrInsufficientFunds}
+	service := invoice.NewInvoiceService(db, coins, time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE payment_requests p SET status = 'accepted'`).
+		WithArgs(7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "amount", "note"}).AddRow("user1", 50, ""))
+	// Перевод не прошел: транзакция откатывается вместе со сменой статуса, запрос остается pending
+	mock.ExpectRollback()
+
+	_, err = service.Accept(context.Background(), 2, 7)
+	assert.ErrorIs(t, err, coin.ErrInsufficientFunds)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestInvoiceAccept_Expired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	coins := &stubCoinService{}
+	service := invoice.NewInvoiceService(db, coins, time.Hour)
+
+	// Условный UPDATE ничего не изменил, причина уточняется отдельным запросом
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE payment_requests p SET status = 'accepted'`).
+		WithArgs(7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "amount", "note"}))
+	mock.ExpectRollback()
+	mock.ExpectQuery(`SELECT CASE WHEN status = 'pending' AND expires_at <= now\(\) THEN 'expired' ELSE status END FROM payment_requests WHERE id = \$1 AND payer_id = \$2`).
+		WithArgs(7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("expired"))
+
+	_, err = service.Accept(context.Background(), 2, 7)
+	assert.ErrorIs(t, err, invoice.ErrExpired)
+	assert.Empty(t, coins.to)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestInvoiceDecline_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := invoice.NewInvoiceService(db, &stubCoinService{}, time.Hour)
+
+	// Чужой запрос неотличим от несуществующего
+	mock.ExpectExec(`UPDATE payment_requests SET status = \$3`).
+		WithArgs(7, 3, invoice.StatusDeclined).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM payment_requests WHERE id = \$1 AND payer_id = \$2`).
+		WithArgs(7, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}))
+
+	_, err = service.Decline(context.Background(), 3, 7)
+	assert.ErrorIs(t, err, invoice.ErrRequestNotFound)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestInvoiceCreate_Validation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := invoice.NewInvoiceService(db, &stubCoinService{}, time.Hour)
+
+	// Неположительная сумма отклоняется без обращения к базе
+	_, err = service.Create(context.Background(), 1, "user2", 0, "")
+	assert.ErrorIs(t, err, invoice.ErrInvalidAmount)
+
+	// Запрос самому себе
+	mock.ExpectQuery(`SELECT id FROM users WHERE username = \$1`).
+		WithArgs("user1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	_, err = service.Create(context.Background(), 1, "user1", 10, "")
+	assert.ErrorIs(t, err, invoice.ErrSelfRequest)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package unit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/auth"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRotationKeys создает старый RSA-ключ и новый Ed25519-ключ
+func newRotationKeys(t *testing.T) (*auth.SigningKey, *auth.SigningKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	oldKey := &auth.SigningKey{ID: "2025-01", Method: jwt.SigningMethodRS256, Private: rsaKey, Public: &rsaKey.PublicKey}
+	newKey := &auth.SigningKey{ID: "2025-02", Method: jwt.SigningMethodEdDSA, Private: edPriv, Public: edPub}
+	return oldKey, newKey
+}
+
+func TestJWTManager_RotationKeepsOldTokensValid(t *testing.T) {
+	oldKey, newKey := newRotationKeys(t)
+
+	// Токен выдан до ротации
+	before, err := auth.NewKeySet(oldKey.ID, oldKey)
+	require.NoError(t, err)
+	token, err := auth.NewJWTManager(before, time.Minute).Generate("user1", "user")
+	require.NoError(t, err)
+
+	// После ротации новый ключ подписывает, а старый остается только для проверки
+	retired := &auth.SigningKey{ID: oldKey.ID, Method: oldKey.Method, Public: oldKey.Public}
+	after, err := auth.NewKeySet(newKey.ID, retired, newKey)
+	require.NoError(t, err)
+	manager := auth.NewJWTManager(after, time.Minute)
+
+	claims, err := manager.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", claims.Subject)
+	assert.Equal(t, "user", claims.Role)
+
+	fresh, err := manager.Generate("user2", "admin")
+	require.NoError(t, err)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(fresh, &jwt.StandardClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "2025-02", parsed.Header["kid"])
+	assert.Equal(t, "EdDSA", parsed.Method.Alg())
+}
+
+func TestJWTManager_RejectsAlgorithmMismatch(t *testing.T) {
+	oldKey, _ := newRotationKeys(t)
+	keys, err := auth.NewKeySet(oldKey.ID, oldKey)
+	require.NoError(t, err)
+	manager := auth.NewJWTManager(keys, time.Minute)
+
+	// Подделка: HS256, подписанный открытым ключом RSA как секретом
+	pubDER, err := x509.MarshalPKIXPublicKey(oldKey.Public)
+	require.NoError(t, err)
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Id:        "forged",
+		Subject:   "admin",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = oldKey.ID
+	signed, err := forged.SignedString(pubPEM)
+	require.NoError(t, err)
+
+	_, err = manager.Verify(signed)
+	assert.Error(t, err)
+}
+
+func TestJWTManager_RejectsUnknownKey(t *testing.T) {
+	oldKey, newKey := newRotationKeys(t)
+
+	foreign, err := auth.NewKeySet(newKey.ID, newKey)
+	require.NoError(t, err)
+	token, err := auth.NewJWTManager(foreign, time.Minute).Generate("user1", "user")
+	require.NoError(t, err)
+
+	keys, err := auth.NewKeySet(oldKey.ID, oldKey)
+	require.NoError(t, err)
+	_, err = auth.NewJWTManager(keys, time.Minute).Verify(token)
+	assert.Error(t, err)
+}
+
+func TestKeySet_JWKSPublishesOnlyPublicKeys(t *testing.T) {
+	oldKey, newKey := newRotationKeys(t)
+	keys, err := auth.NewKeySet(newKey.ID, oldKey, newKey)
+	require.NoError(t, err)
+
+	set := keys.JWKS()
+	require.Len(t, set.Keys, 2)
+	assert.Equal(t, "RSA", set.Keys[0].Kty)
+	assert.Equal(t, "RS256", set.Keys[0].Alg)
+	assert.NotEmpty(t, set.Keys[0].N)
+	assert.Equal(t, "OKP", set.Keys[1].Kty)
+	assert.Equal(t, "Ed25519", set.Keys[1].Crv)
+	assert.NotEmpty(t, set.Keys[1].X)
+
+	assert.Empty(t, auth.NewHMACKeySet("secret").JWKS().Keys, "symmetric keys must not be published")
+}
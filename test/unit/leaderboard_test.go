@@ -0,0 +1,143 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/leaderboard"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderboard_Get(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := leaderboard.NewLeaderboardService(db)
+	refreshedAt := time.Now()
+
+	mock.ExpectQuery(`SELECT refreshed_at FROM leaderboard_state`).
+		WillReturnRows(sqlmock.NewRows([]string{"refreshed_at"}).AddRow(refreshedAt))
+	// Число разных получателей считается по парам за весь период, скрытые пользователи отфильтровываются
+	mock.ExpectQuery(`SELECT RANK\(\) OVER .+ COUNT\(\*\) AS value .+ HAVING SUM\(amount\) > 0 .+ NOT u.leaderboard_opt_out .+ LIMIT \$3`).
+		WithArgs("2025-01-01", "2025-01-31", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"rank", "username", "value"}).
+			AddRow(1, "user1", 5).
+			AddRow(1, "user2", 5).
+			AddRow(3, "user3", 2))
+
+	// Выполняем тест
+	board, err := service.Get(context.Background(), leaderboard.Query{
+		Metric: leaderboard.MetricThanked,
+		From:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "thanked", board.Metric)
+	if assert.NotNil(t, board.RefreshedAt) {
+		assert.True(t, refreshedAt.Equal(*board.RefreshedAt))
+	}
+	if assert.Len(t, board.Entries, 3) {
+		assert.Equal(t, 1, board.Entries[1].Rank)
+		assert.Equal(t, 3, board.Entries[2].Rank)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLeaderboard_GetValidation(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := leaderboard.NewLeaderboardService(db)
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Некорректные параметры отклоняются до обращения к базе
+	_, err = service.Get(context.Background(), leaderboard.Query{Metric: "richest", From: day, To: day})
+	assert.ErrorIs(t, err, leaderboard.ErrInvalidMetric)
+
+	_, err = service.Get(context.Background(), leaderboard.Query{Metric: leaderboard.MetricSent, From: day, To: day.AddDate(0, 0, -1)})
+	assert.ErrorIs(t, err, leaderboard.ErrInvalidPeriod)
+
+	_, err = service.Get(context.Background(), leaderboard.Query{Metric: leaderboard.MetricSent, From: day, To: day, Limit: -1})
+	assert.ErrorIs(t, err, leaderboard.ErrInvalidLimit)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLeaderboard_Refresh(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := leaderboard.NewLeaderboardService(db)
+
+	// Полная пачка: очередь разбирается дальше, пока не останется меньше пачки
+	for _, count := range []int{10000, 40} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT TRUE FROM leaderboard_state FOR UPDATE SKIP LOCKED`).
+			WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+		// Учитываются только зафиксированные записи из очереди, и они сразу из нее удаляются
+		mock.ExpectQuery(`WITH batch AS \( DELETE FROM leaderboard_queue .+ INSERT INTO leaderboard_transfers .+ INSERT INTO leaderboard_spending .+ SELECT COUNT\(\*\) FROM batch`).
+			WithArgs(10000).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+		mock.ExpectExec(`UPDATE leaderboard_state SET refreshed_at = now\(\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	// Выполняем тест
+	n, err := service.Refresh(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 10040, n)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLeaderboard_RefreshConcurrent(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := leaderboard.NewLeaderboardService(db)
+
+	// Состояние заблокировано другой репликой: обновление пропускается
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT TRUE FROM leaderboard_state FOR UPDATE SKIP LOCKED`).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	n, err := service.Refresh(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
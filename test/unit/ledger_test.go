@@ -0,0 +1,80 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"Avito-trainee/internal/ledger"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectLedgerPost настраивает mock на запись в журнал с заданным id
+func expectLedgerPost(mock sqlmock.Sqlmock, entryType string, entryID int64) {
+	mock.ExpectQuery(`INSERT INTO journal_entries \(type, description\) VALUES \(\$1, \$2\) RETURNING id`).
+		WithArgs(entryType, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(entryID))
+	mock.ExpectExec(`INSERT INTO postings \(entry_id, account_id, amount\)`).
+		WithArgs(entryID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+}
+
+func TestLedgerPost_Success(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	expectLedgerPost(mock, ledger.EntryPurchase, 42)
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	entryID, err := ledger.Post(
+		context.Background(),
+		tx,
+		ledger.EntryPurchase,
+		"pen",
+		ledger.User(1, -10),
+		ledger.System(ledger.AccountShopRevenue, 10),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), entryID)
+	assert.NoError(t, tx.Commit())
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLedgerPost_Validation(t *testing.T) {
+	// Создаем mock базы данных: до запросов дело доходить не должно
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+
+	// Выполняем тест
+	_, err = ledger.Post(context.Background(), tx, ledger.EntryTransfer, "", ledger.User(1, -10), ledger.User(2, 5))
+	assert.ErrorIs(t, err, ledger.ErrUnbalanced)
+	_, err = ledger.Post(context.Background(), tx, ledger.EntryTransfer, "")
+	assert.ErrorIs(t, err, ledger.ErrNoPostings)
+	_, err = ledger.Post(context.Background(), tx, ledger.EntryTransfer, "", ledger.User(1, 0))
+	assert.ErrorIs(t, err, ledger.ErrZeroPosting)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
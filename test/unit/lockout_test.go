@@ -0,0 +1,114 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/auth"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLockoutOptions = auth.LockoutOptions{
+	MaxAttempts:   5,
+	IPMaxAttempts: 50,
+	BaseDelay:     30 * time.Second,
+	MaxDelay:      time.Hour,
+	Window:        time.Hour,
+}
+
+func TestLoginGuard_CheckLocked(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем защиту
+	guard := auth.NewLoginGuard(db, testLockoutOptions)
+
+	// Настройка mock-запросов
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(EXTRACT\(EPOCH FROM locked_until - now\(\)\)\), 0\) FROM login_attempts`).
+		WithArgs("user1", "10.0.0.1").
+		WillReturnRows(sqlmock.NewRows([]string{"seconds"}).AddRow(12.3))
+
+	// Выполняем тест
+	err = guard.Check(context.Background(), "user1", "10.0.0.1")
+	assert.ErrorIs(t, err, auth.ErrTooManyAttempts)
+	var lockout *auth.LockoutError
+	if assert.True(t, errors.As(err, &lockout)) {
+		assert.Equal(t, 13*time.Second, lockout.RetryAfter)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginGuard_FailLocksWithExponentialBackoff(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем защиту
+	guard := auth.NewLoginGuard(db, testLockoutOptions)
+
+	// Настройка mock-запросов: седьмая ошибка подряд для имени, четвертая блокировка
+	mock.ExpectQuery(`INSERT INTO login_attempts \(scope, key, failed_count, updated_at\)`).
+		WithArgs("user", "user1", float64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_count"}).AddRow(7))
+	mock.ExpectExec(`UPDATE login_attempts SET locked_until = now\(\) \+ make_interval\(secs => \$3\)`).
+		WithArgs("user", "user1", float64(120)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO login_attempts \(scope, key, failed_count, updated_at\)`).
+		WithArgs("ip", "10.0.0.1", float64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_count"}).AddRow(7))
+
+	// Выполняем тест
+	err = guard.Fail(context.Background(), "user1", "10.0.0.1")
+	var lockout *auth.LockoutError
+	if assert.True(t, errors.As(err, &lockout)) {
+		assert.Equal(t, 2*time.Minute, lockout.RetryAfter)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginGuard_FailBelowThreshold(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем защиту
+	guard := auth.NewLoginGuard(db, testLockoutOptions)
+
+	// Настройка mock-запросов
+	mock.ExpectQuery(`INSERT INTO login_attempts \(scope, key, failed_count, updated_at\)`).
+		WithArgs("user", "user1", float64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_count"}).AddRow(2))
+	mock.ExpectQuery(`INSERT INTO login_attempts \(scope, key, failed_count, updated_at\)`).
+		WithArgs("ip", "10.0.0.1", float64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_count"}).AddRow(2))
+
+	// Выполняем тест
+	err = guard.Fail(context.Background(), "user1", "10.0.0.1")
+	assert.NoError(t, err)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
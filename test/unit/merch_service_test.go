@@ -2,6 +2,7 @@ package unit
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"testing"
 
@@ -23,14 +24,18 @@ func TestBuyItem_Success(t *testing.T) {
 
 	// Настройка mock-запросов
 	mock.ExpectBegin()
-	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1`).
+	mock.ExpectQuery(`SELECT price, stock FROM merch_items WHERE slug = \$1 AND active AND stock IS NULL FOR SHARE`).
+		WithArgs("t-shirt").
+		WillReturnRows(sqlmock.NewRows([]string{"price", "stock"}).AddRow(80, nil))
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(1000))
 	mock.ExpectExec(`UPDATE users SET coins = coins - \$1 WHERE id = \$2`).
 		WithArgs(80, 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
-	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, merch, amount\) VALUES \(\$1, \$2, \$3, \$4\)`).
-		WithArgs(1, "purchased", "t-shirt", 80).
+	expectLedgerPost(mock, "purchase", 3)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, merch, amount, entry_id\) VALUES \(\$1, \$2, \$3, \$4, \$5\)`).
+		WithArgs(1, "purchased", "t-shirt", 80, 3).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectExec(`INSERT INTO inventory \(user_id, item_type, quantity\) VALUES \(\$1, \$2, 1\) ON CONFLICT \(user_id, item_type\) DO UPDATE SET quantity = inventory.quantity \+ 1`).
 		WithArgs(1, "t-shirt").
@@ -60,7 +65,10 @@ func TestBuyItem_InsufficientCoins(t *testing.T) {
 
 	// Настройка mock-запросов
 	mock.ExpectBegin()
-	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1`).
+	mock.ExpectQuery(`SELECT price, stock FROM merch_items WHERE slug = \$1 AND active AND stock IS NULL FOR SHARE`).
+		WithArgs("t-shirt").
+		WillReturnRows(sqlmock.NewRows([]string{"price", "stock"}).AddRow(80, nil))
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(50))
 	mock.ExpectRollback()
@@ -87,6 +95,16 @@ func TestBuyItem_ItemNotFound(t *testing.T) {
 	// Инициализируем сервис
 	service := merch.NewMerchService(db)
 
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT price, stock FROM merch_items WHERE slug = \$1 AND active AND stock IS NULL FOR SHARE`).
+		WithArgs("nonexistent-item").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT price, stock FROM merch_items WHERE slug = \$1 AND active`).
+		WithArgs("nonexistent-item").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
 	// Выполняем тест
 	err = service.BuyItem(context.Background(), 1, "nonexistent-item")
 	assert.Error(t, err)
@@ -111,7 +129,10 @@ func TestBuyItem_DatabaseError(t *testing.T) {
 
 	// Настройка mock-запросов
 	mock.ExpectBegin()
-	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1`).
+	mock.ExpectQuery(`SELECT price, stock FROM merch_items WHERE slug = \$1 AND active AND stock IS NULL FOR SHARE`).
+		WithArgs("t-shirt").
+		WillReturnRows(sqlmock.NewRows([]string{"price", "stock"}).AddRow(80, nil))
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
 		WithArgs(1).
 		WillReturnError(errors.New("database error"))
 	mock.ExpectRollback()
@@ -126,3 +147,210 @@ func TestBuyItem_DatabaseError(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %v", err)
 	}
 }
+
+func TestListItems_ActiveOnly(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := merch.NewMerchService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectQuery(`SELECT slug, title, price, active, description, stock FROM merch_items WHERE active OR \$1`).
+		WithArgs(false).
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "title", "price", "active", "description", "stock"}).
+			AddRow("pen", "Pen", 10, true, "", 0).
+			AddRow("cup", "Cup", 20, true, "Ceramic", nil))
+
+	// Выполняем тест
+	items, err := service.ListItems(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "cup", items[1].Slug)
+	assert.False(t, items[0].Available, "sold out item must not be available")
+	assert.True(t, items[1].Available)
+	assert.Nil(t, items[1].Stock)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateItem_Validation(t *testing.T) {
+	// Создаем mock базы данных: до базы дело доходить не должно
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := merch.NewMerchService(db)
+
+	// Выполняем тест
+	_, err = service.CreateItem(context.Background(), merch.Item{Slug: "Hoody XL", Title: "Hoody", Price: 300})
+	assert.ErrorIs(t, err, merch.ErrInvalidSlug)
+	_, err = service.CreateItem(context.Background(), merch.Item{Slug: "hoody-xl", Title: " ", Price: 300})
+	assert.ErrorIs(t, err, merch.ErrInvalidTitle)
+	_, err = service.CreateItem(context.Background(), merch.Item{Slug: "hoody-xl", Title: "Hoody XL", Price: 0})
+	assert.ErrorIs(t, err, merch.ErrInvalidPrice)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateItem_ChangesPrice(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := merch.NewMerchService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT slug, title, price, active, description, stock FROM merch_items WHERE slug = \$1 FOR UPDATE`).
+		WithArgs("hoody").
+		WillReturnRows(sqlmock.NewRows([]string{"slug", "title", "price", "active", "description", "stock"}).
+			AddRow("hoody", "Hoody", 300, true, "", nil))
+	mock.ExpectExec(`UPDATE merch_items SET title = \$1, price = \$2, active = \$3, description = \$4, stock = \$5, updated_at = now\(\) WHERE slug = \$6`).
+		WithArgs("Hoody", 350, true, "", nil, "hoody").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	price := 350
+	item, err := service.UpdateItem(context.Background(), "hoody", merch.ItemUpdate{Price: &price})
+	assert.NoError(t, err)
+	assert.Equal(t, 350, item.Price)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestBuyItem_SoldOut(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := merch.NewMerchService(db)
+
+	// Настройка mock-запросов: последний экземпляр купили параллельно
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT price, stock FROM merch_items WHERE slug = \$1 AND active AND stock IS NULL FOR SHARE`).
+		WithArgs("hoody").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT price, stock FROM merch_items WHERE slug = \$1 AND active`).
+		WithArgs("hoody").
+		WillReturnRows(sqlmock.NewRows([]string{"price", "stock"}).AddRow(300, 1))
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(1000))
+	mock.ExpectQuery(`UPDATE merch_items SET stock = stock - \$2 WHERE slug = \$1 AND active AND stock >= \$2 RETURNING price`).
+		WithArgs("hoody", 1).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	err = service.BuyItem(context.Background(), 1, "hoody")
+	assert.ErrorIs(t, err, merch.ErrSoldOut)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestBuyItem_LimitedStock(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := merch.NewMerchService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT price, stock FROM merch_items WHERE slug = \$1 AND active AND stock IS NULL FOR SHARE`).
+		WithArgs("hoody").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT price, stock FROM merch_items WHERE slug = \$1 AND active`).
+		WithArgs("hoody").
+		WillReturnRows(sqlmock.NewRows([]string{"price", "stock"}).AddRow(300, 5))
+	mock.ExpectQuery(`SELECT coins FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"coins"}).AddRow(1000))
+	mock.ExpectQuery(`UPDATE merch_items SET stock = stock - \$2`).
+		WithArgs("hoody", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(300))
+	mock.ExpectExec(`UPDATE users SET coins = coins - \$1 WHERE id = \$2`).
+		WithArgs(300, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLedgerPost(mock, "purchase", 4)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, merch, amount, entry_id\)`).
+		WithArgs(1, "purchased", "hoody", 300, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO inventory`).
+		WithArgs(1, "hoody").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	err = service.BuyItem(context.Background(), 1, "hoody")
+	assert.NoError(t, err)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRestock_UnlimitedItem(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := merch.NewMerchService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectQuery(`UPDATE merch_items SET stock = stock \+ \$2`).
+		WithArgs("pen", 10).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM merch_items WHERE slug = \$1\)`).
+		WithArgs("pen").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	// Выполняем тест
+	_, err = service.Restock(context.Background(), "pen", 10)
+	assert.ErrorIs(t, err, merch.ErrNotLimited)
+	_, err = service.Restock(context.Background(), "pen", 0)
+	assert.ErrorIs(t, err, merch.ErrInvalidQuantity)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
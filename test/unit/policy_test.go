@@ -0,0 +1,173 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"Avito-trainee/internal/coin"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectTransferLock Поиск участников перевода user1 -> user2 и блокировка их строк
+func expectTransferLock(mock sqlmock.Sqlmock, balance int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM users WHERE username = \$1`).
+		WithArgs("user2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery(`SELECT username FROM users WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("user1"))
+	mock.ExpectQuery(`SELECT id, coins FROM users WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "coins"}).AddRow(1, balance).AddRow(2, 1000))
+}
+
+func TestSendCoin_InvalidAmount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := coin.NewCoinService(db)
+
+	// Нулевая и отрицательная суммы отклоняются до обращения к базе с кодом политики
+	for _, amount := range []int{0, -100} {
+		err = service.SendCoin(context.Background(), 1, "user2", amount, coin.Memo{})
+		var policyErr *coin.PolicyError
+		assert.True(t, errors.As(err, &policyErr))
+		assert.Equal(t, coin.CodeBelowMinimum, policyErr.Code)
+	}
+
+	// Клиент получает машиночитаемый код и для одиночного, и для пакетного перевода
+	requests := map[string]http.HandlerFunc{
+		`{"ToUser":"user2","amount":0}`:                  coin.MakeSendCoinHandler(service),
+		`{"transfers":[{"toUser":"user2","amount":-5}]}`: coin.MakeSendCoinBatchHandler(service),
+	}
+	for body, handler := range requests {
+		req := httptest.NewRequest(http.MethodPost, "/api/sendCoin", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), "userID", 1))
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"), body)
+		assert.Contains(t, rr.Body.String(), `"code":"amount_below_minimum"`, body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSendCoin_PolicyAmountBounds(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	policy := coin.NewLimitsPolicy(coin.PolicyConfig{MinAmount: 10, MaxAmount: 500})
+	service := coin.NewCoinService(db, policy)
+
+	// Сумма меньше минимальной
+	expectTransferLock(mock, 1000)
+	mock.ExpectRollback()
+	err = service.SendCoin(context.Background(), 1, "user2", 5, coin.Memo{})
+	var policyErr *coin.PolicyError
+	assert.True(t, errors.As(err, &policyErr))
+	assert.Equal(t, coin.CodeBelowMinimum, policyErr.Code)
+	assert.ErrorIs(t, err, coin.ErrPolicyViolation)
+
+	// Сумма больше максимальной
+	expectTransferLock(mock, 1000)
+	mock.ExpectRollback()
+	err = service.SendCoin(context.Background(), 1, "user2", 600, coin.Memo{})
+	assert.True(t, errors.As(err, &policyErr))
+	assert.Equal(t, coin.CodeAboveMaximum, policyErr.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSendCoin_PolicyDailyLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	policy := coin.NewLimitsPolicy(coin.PolicyConfig{DailyLimit: 300, WeeklyLimit: 1000})
+	service := coin.NewCoinService(db, policy)
+
+	// За сутки уже отправлено 250, еще 100 превышают лимит 300
+	expectTransferLock(mock, 1000)
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(amount\) FILTER .+ FROM transactions WHERE user_id = \$1 AND type = 'sent'`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"daily", "weekly"}).AddRow(250, 400))
+	mock.ExpectRollback()
+
+	err = service.SendCoin(context.Background(), 1, "user2", 100, coin.Memo{})
+	var policyErr *coin.PolicyError
+	assert.True(t, errors.As(err, &policyErr))
+	assert.Equal(t, coin.CodeDailyLimit, policyErr.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSendCoin_PolicyRecipientDailyLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	policy := coin.NewLimitsPolicy(coin.PolicyConfig{RecipientDailyLimit: 100})
+	service := coin.NewCoinService(db, policy)
+
+	// Получателю user2 за сутки уже отправлено 80
+	expectTransferLock(mock, 1000)
+	mock.ExpectQuery(`SELECT counterparty, SUM\(amount\) FROM transactions`).
+		WithArgs(1, "{\"user2\"}").
+		WillReturnRows(sqlmock.NewRows([]string{"counterparty", "sum"}).AddRow("user2", 80))
+	mock.ExpectRollback()
+
+	err = service.SendCoin(context.Background(), 1, "user2", 30, coin.Memo{})
+	var policyErr *coin.PolicyError
+	assert.True(t, errors.As(err, &policyErr))
+	assert.Equal(t, coin.CodeRecipientDailyLimit, policyErr.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSendCoin_PolicyBlockedPair(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	policy := coin.NewLimitsPolicy(coin.PolicyConfig{BlockedPairs: [][2]string{{"*", "user2"}}})
+	service := coin.NewCoinService(db, policy)
+
+	expectTransferLock(mock, 1000)
+	mock.ExpectRollback()
+
+	err = service.SendCoin(context.Background(), 1, "user2", 10, coin.Memo{})
+	var policyErr *coin.PolicyError
+	assert.True(t, errors.As(err, &policyErr))
+	assert.Equal(t, coin.CodeBlockedPair, policyErr.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"Avito-trainee/internal/reconcile"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var reconcileColumns = []string{"id", "username", "coins", "expected", "ledger"}
+
+func TestReconcile_ReportsDrift(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := reconcile.NewReconcileService(db)
+
+	// Настройка mock-запросов: баланс user2 поправили вручную на 50 монет
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`WITH signs AS`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1000, 0).
+		WillReturnRows(sqlmock.NewRows(reconcileColumns).AddRow(2, "user2", 1050, 1000, 1000))
+
+	// Выполняем тест
+	report, err := service.Run(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Users)
+	assert.False(t, report.Fixed)
+	if assert.Len(t, report.Mismatches, 1) {
+		assert.Equal(t, 50, report.Mismatches[0].HistoryDrift)
+		assert.Equal(t, 50, report.Mismatches[0].LedgerDrift)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestReconcile_FixWritesAdjustments(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	// Инициализируем сервис
+	service := reconcile.NewReconcileService(db)
+
+	// Настройка mock-запросов
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery(`WITH signs AS`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1000, 0).
+		WillReturnRows(sqlmock.NewRows(reconcileColumns).AddRow(2, "user2", 950, 1000, 950))
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT 1 FROM users WHERE id = \$1 FOR UPDATE`).
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`WITH signs AS`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1000, 2).
+		WillReturnRows(sqlmock.NewRows(reconcileColumns).AddRow(2, "user2", 950, 1000, 950))
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, amount, reason, entry_id\)`).
+		WithArgs(2, "adjustment", -50, "reconciliation", nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	report, err := service.Run(context.Background(), true)
+	assert.NoError(t, err)
+	assert.True(t, report.Fixed)
+	if assert.Len(t, report.Mismatches, 1) {
+		assert.Equal(t, -50, report.Mismatches[0].HistoryDrift)
+		assert.Equal(t, 0, report.Mismatches[0].LedgerDrift)
+	}
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
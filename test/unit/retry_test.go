@@ -0,0 +1,34 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"Avito-trainee/internal/db"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry_GivesUpWithErrBusy(t *testing.T) {
+	attempts := 0
+	err := db.Retry(context.Background(), func() error {
+		attempts++
+		return &pq.Error{Code: "40001", Message: "could not serialize access"}
+	})
+	assert.ErrorIs(t, err, db.ErrBusy)
+	assert.Equal(t, 5, attempts)
+}
+
+func TestRetry_DoesNotRetryOtherErrors(t *testing.T) {
+	attempts := 0
+	errFailed := errors.New("failed")
+	err := db.Retry(context.Background(), func() error {
+		attempts++
+		return errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+	assert.Equal(t, 1, attempts)
+	assert.False(t, db.IsRetryable(&pq.Error{Code: "23505"}))
+	assert.True(t, db.IsRetryable(&pq.Error{Code: "40P01"}))
+}
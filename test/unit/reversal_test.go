@@ -0,0 +1,286 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"Avito-trainee/internal/reversal"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var transferColumns = []string{"sent_id", "received_id", "sender_id", "recipient_id", "sender", "recipient", "amount"}
+
+// expectNotReversed Проверка отмены отдельным запросом после блокировки исходных записей
+func expectNotReversed(mock sqlmock.Sqlmock, reversed bool) {
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM transactions WHERE reverses_id = ANY\(\$1\)\)`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(reversed))
+}
+
+func TestReturnToSender_Success(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := reversal.NewReversalService(db)
+
+	// user2 возвращает 100 монет, полученных от user1 по ошибке (запись received с id 21)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM transactions s .+ WHERE s.type = 'sent' AND r.id = \$1 LIMIT 1 FOR UPDATE OF s, r`).
+		WithArgs(21).
+		WillReturnRows(sqlmock.NewRows(transferColumns).AddRow(20, 21, 1, 2, "user1", "user2", 100))
+	expectNotReversed(mock, false)
+	mock.ExpectQuery(`SELECT id, coins FROM users WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "coins"}).AddRow(1, 400).AddRow(2, 600))
+	mock.ExpectExec(`UPDATE users SET coins = coins \- \$1 WHERE id = \$2`).
+		WithArgs(100, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(100, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLedgerPost(mock, "reversal", 30)
+	// Компенсирующие записи ссылаются на исходные записи received и sent
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, counterparty, amount, reason, entry_id, reverses_id\)`).
+		WithArgs(2, "user1", 100, "sent by mistake", 30, 21, 1, "user2", 20).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE reversal_requests SET status = \$2`).
+		WithArgs(20, reversal.StatusReturned, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	err = service.ReturnToSender(context.Background(), 2, 21, " sent by mistake ")
+	assert.NoError(t, err)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestReturnToSender_NotRecipient(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := reversal.NewReversalService(db)
+
+	// Вернуть перевод может только получатель
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE OF s, r`).
+		WithArgs(21).
+		WillReturnRows(sqlmock.NewRows(transferColumns).AddRow(20, 21, 1, 2, "user1", "user2", 100))
+	expectNotReversed(mock, false)
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	err = service.ReturnToSender(context.Background(), 3, 21, "not mine")
+	assert.ErrorIs(t, err, reversal.ErrTransactionNotFound)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestApproveReversal_RecipientSpentCoins(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := reversal.NewReversalService(db)
+
+	// Получатель уже потратил монеты: отмена не выполняется, запрос остается ожидающим
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT transaction_id, reason, status FROM reversal_requests WHERE id = \$1 FOR UPDATE`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"transaction_id", "reason", "status"}).AddRow(20, "wrong user", "pending"))
+	mock.ExpectQuery(`WHERE s.type = 'sent' AND s.id = \$1`).
+		WithArgs(20).
+		WillReturnRows(sqlmock.NewRows(transferColumns).AddRow(20, 21, 1, 2, "user1", "user2", 100))
+	expectNotReversed(mock, false)
+	mock.ExpectQuery(`SELECT id, coins FROM users WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "coins"}).AddRow(1, 400).AddRow(2, 30))
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	_, err = service.Approve(context.Background(), 9, 5)
+	assert.ErrorIs(t, err, reversal.ErrInsufficientFunds)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRequestReversal_AlreadyRequested(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := reversal.NewReversalService(db)
+
+	mock.ExpectQuery(`FROM transactions t WHERE t.id = \$1 AND t.user_id = \$2 AND t.type = 'sent'`).
+		WithArgs(20, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	// Второй ожидающий запрос на тот же перевод нарушает уникальный индекс
+	mock.ExpectQuery(`INSERT INTO reversal_requests`).
+		WithArgs(20, 1, "wrong user").
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	// Выполняем тест
+	_, err = service.RequestReversal(context.Background(), 1, 20, "wrong user")
+	assert.ErrorIs(t, err, reversal.ErrAlreadyRequested)
+
+	// Без основания запрос не создается
+	_, err = service.RequestReversal(context.Background(), 1, 20, "  ")
+	assert.ErrorIs(t, err, reversal.ErrReasonRequired)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRefund_Success(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := reversal.NewReversalService(db)
+
+	// Возврат двух футболок, купленных за 160 монет
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM transactions t WHERE t.id = \$1 AND t.type = 'purchased' FOR UPDATE`).
+		WithArgs(40).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "merch", "amount", "quantity"}).AddRow(1, "t-shirt", 160, 2))
+	expectNotReversed(mock, false)
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(160, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE merch_items SET stock = stock \+ \$2 WHERE slug = \$1 AND stock IS NOT NULL`).
+		WithArgs("t-shirt", 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE inventory SET quantity = quantity \- \$3`).
+		WithArgs(1, "t-shirt", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM inventory WHERE user_id = \$1 AND item_type = \$2 AND quantity = 0`).
+		WithArgs(1, "t-shirt").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLedgerPost(mock, "refund", 31)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, counterparty, merch, amount, quantity, reason, entry_id, reverses_id\)`).
+		WithArgs(1, "refund", "t-shirt", 160, 2, "defective", 9, 31, 40).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Выполняем тест
+	err = service.Refund(context.Background(), 9, 40, "defective")
+	assert.NoError(t, err)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestReturnToSender_ApprovedConcurrently(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := reversal.NewReversalService(db)
+
+	// Администратор одобрил отмену, пока возврат ждал блокировки: проверка после блокировки видит отмену
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE OF s, r`).
+		WithArgs(21).
+		WillReturnRows(sqlmock.NewRows(transferColumns).AddRow(20, 21, 1, 2, "user1", "user2", 100))
+	expectNotReversed(mock, true)
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	err = service.ReturnToSender(context.Background(), 2, 21, "sent by mistake")
+	assert.ErrorIs(t, err, reversal.ErrAlreadyReversed)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRefund_Duplicate(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := reversal.NewReversalService(db)
+
+	// Второй возврат той же покупки, зафиксированный одновременно, нарушает уникальный индекс по reverses_id
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM transactions t WHERE t.id = \$1 AND t.type = 'purchased' FOR UPDATE`).
+		WithArgs(40).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "merch", "amount", "quantity"}).AddRow(1, "t-shirt", 160, 2))
+	expectNotReversed(mock, false)
+	mock.ExpectExec(`UPDATE users SET coins = coins \+ \$1 WHERE id = \$2`).
+		WithArgs(160, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE merch_items SET stock = stock \+ \$2`).
+		WithArgs("t-shirt", 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE inventory SET quantity = quantity \- \$3`).
+		WithArgs(1, "t-shirt", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM inventory`).
+		WithArgs(1, "t-shirt").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectLedgerPost(mock, "refund", 31)
+	mock.ExpectExec(`INSERT INTO transactions \(user_id, type, counterparty, merch, amount, quantity, reason, entry_id, reverses_id\)`).
+		WithArgs(1, "refund", "t-shirt", 160, 2, "defective", 9, 31, 40).
+		WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+
+	// Выполняем тест
+	err = service.Refund(context.Background(), 9, 40, "defective")
+	assert.ErrorIs(t, err, reversal.ErrAlreadyReversed)
+
+	// Уже выполненный возврат отклоняется сразу после блокировки покупки
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM transactions t WHERE t.id = \$1 AND t.type = 'purchased' FOR UPDATE`).
+		WithArgs(40).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "merch", "amount", "quantity"}).AddRow(1, "t-shirt", 160, 2))
+	expectNotReversed(mock, true)
+	mock.ExpectRollback()
+
+	err = service.Refund(context.Background(), 9, 40, "defective")
+	assert.ErrorIs(t, err, reversal.ErrAlreadyReversed)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"Avito-trainee/internal/middleware"
+	"Avito-trainee/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	handler := middleware.RequireRole(models.RoleAdmin, models.RoleAuditor)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+
+	cases := []struct {
+		role string
+		want int
+	}{
+		{models.RoleAdmin, http.StatusOK},
+		{models.RoleAuditor, http.StatusOK},
+		{models.RoleUser, http.StatusForbidden},
+		{"", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/api/admin/reports", nil)
+		if c.role != "" {
+			req = req.WithContext(context.WithValue(req.Context(), "role", c.role))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, c.want, rr.Code, "role %q", c.role)
+	}
+}
@@ -0,0 +1,235 @@
+package unit
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/coin"
+	"Avito-trainee/internal/schedule"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var dueColumns = []string{"id", "owner_id", "username", "amount", "message", "repeat", "next_run_at"}
+
+// afterTime Время строго позже заданного
+type afterTime struct {
+	t time.Time
+}
+
+func (a afterTime) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.After(a.t)
+}
+
+func TestScheduleRunDue_AdvancesBeforeTransfer(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	coins := &stubCoinService{}
+	service := schedule.NewScheduleService(db, coins)
+	scheduledFor := time.Now().Add(-time.Minute)
+
+	// Наступивший еженедельный перевод забирается и сдвигается на неделю вперед в одной транзакции
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT t.id, t.owner_id, u.username, .+ FOR UPDATE OF t SKIP LOCKED`).
+		WithArgs(100).
+		WillReturnRows(sqlmock.NewRows(dueColumns).AddRow(3, 1, "mentee", 50, "спасибо", "weekly", scheduledFor))
+	mock.ExpectExec(`UPDATE scheduled_transfers SET next_run_at = \$2, active = \$3, last_run_at = now\(\) WHERE id = \$1`).
+		WithArgs(3, afterTime{t: scheduledFor.Add(6 * 24 * time.Hour)}, true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// После коммита выполняется перевод и записывается результат
+	mock.ExpectExec(`WITH run AS \( INSERT INTO scheduled_transfer_runs`).
+		WithArgs(3, scheduledFor, schedule.RunSucceeded, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Выполняем тест
+	executed, err := service.RunDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, executed)
+	assert.Equal(t, 1, coins.from)
+	assert.Equal(t, "mentee", coins.to)
+	assert.Equal(t, 50, coins.sent)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScheduleRunDue_RecordsFailure(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	coins := &stubCoinService{err: coin.ErrInsufficientFunds}
+	service := schedule.NewScheduleService(db, coins)
+	scheduledFor := time.Now().Add(-time.Minute)
+
+	// Разовый перевод выключается до выполнения
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE OF t SKIP LOCKED`).
+		WithArgs(100).
+		WillReturnRows(sqlmock.NewRows(dueColumns).AddRow(4, 1, "user2", 5000, "", "once", scheduledFor))
+	mock.ExpectExec(`UPDATE scheduled_transfers SET next_run_at = \$2, active = \$3`).
+		WithArgs(4, scheduledFor, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// Неудача записывается с понятной владельцу причиной
+	mock.ExpectExec(`WITH run AS \( INSERT INTO scheduled_transfer_runs`).
+		WithArgs(4, scheduledFor, schedule.RunFailed, "not enough coins").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Выполняем тест
+	executed, err := service.RunDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, executed)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+// cancelingCoinService Переводы, во время одного из которых останавливается сервис
+type cancelingCoinService struct {
+	stubCoinService
+	cancel   context.CancelFunc
+	cancelAt int
+	calls    int
+}
+
+func (s *cancelingCoinService) SendCoin(ctx context.Context, fromUserID int, toUsername string, amount int, memo coin.Memo) error {
+	s.calls++
+	if s.calls == s.cancelAt {
+		s.cancel()
+	}
+	return ctx.Err()
+}
+
+// expectClaimed Выборка и сдвиг ежедневных переводов с указанными id
+func expectClaimed(mock sqlmock.Sqlmock, scheduledFor time.Time, ids ...int) {
+	rows := sqlmock.NewRows(dueColumns)
+	for _, id := range ids {
+		rows.AddRow(id, 1, "user2", 10, "", "daily", scheduledFor)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE OF t SKIP LOCKED`).
+		WithArgs(100).
+		WillReturnRows(rows)
+	for _, id := range ids {
+		mock.ExpectExec(`UPDATE scheduled_transfers SET next_run_at = \$2, active = \$3`).
+			WithArgs(id, sqlmock.AnyArg(), true).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+}
+
+func TestScheduleRunDue_RecordsInterrupted(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	coins := &cancelingCoinService{cancel: cancel, cancelAt: 2}
+	service := schedule.NewScheduleService(db, coins)
+	scheduledFor := time.Now().Add(-time.Minute)
+
+	// Сервис останавливается во время второго перевода из трех
+	expectClaimed(mock, scheduledFor, 5, 6, 7)
+	mock.ExpectExec(`WITH run AS \( INSERT INTO scheduled_transfer_runs`).
+		WithArgs(5, scheduledFor, schedule.RunSucceeded, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Прерванный и не начатый переводы уже сдвинуты, поэтому их запуски записываются как неудачные
+	mock.ExpectExec(`WITH run AS \( INSERT INTO scheduled_transfer_runs`).
+		WithArgs(6, scheduledFor, schedule.RunFailed, "interrupted").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`WITH run AS \( INSERT INTO scheduled_transfer_runs`).
+		WithArgs(7, scheduledFor, schedule.RunFailed, "interrupted").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Выполняем тест
+	executed, err := service.RunDue(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, executed)
+	assert.Equal(t, 2, coins.calls)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScheduleRunDue_RecordFailureInterruptsBatch(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	coins := &stubCoinService{}
+	service := schedule.NewScheduleService(db, coins)
+	scheduledFor := time.Now().Add(-time.Minute)
+
+	// Результат первого перевода не записался: остальные не выполняются, но их запуски записываются
+	expectClaimed(mock, scheduledFor, 5, 6)
+	mock.ExpectExec(`WITH run AS \( INSERT INTO scheduled_transfer_runs`).
+		WithArgs(5, scheduledFor, schedule.RunSucceeded, nil).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectExec(`WITH run AS \( INSERT INTO scheduled_transfer_runs`).
+		WithArgs(6, scheduledFor, schedule.RunFailed, "interrupted").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Выполняем тест
+	executed, err := service.RunDue(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 0, executed)
+	assert.Equal(t, 10, coins.sent)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScheduleCreate_Validation(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := schedule.NewScheduleService(db, &stubCoinService{})
+
+	// Проверки выполняются до обращения к базе
+	_, err = service.Create(context.Background(), 1, schedule.NewTransfer{ToUser: "user2", Amount: -5})
+	assert.ErrorIs(t, err, schedule.ErrInvalidAmount)
+
+	_, err = service.Create(context.Background(), 1, schedule.NewTransfer{ToUser: "user2", Amount: 5, Repeat: "hourly"})
+	assert.ErrorIs(t, err, schedule.ErrInvalidRepeat)
+
+	_, err = service.Create(context.Background(), 1, schedule.NewTransfer{ToUser: "user2", Amount: 5, RunAt: time.Now().Add(-time.Hour)})
+	assert.ErrorIs(t, err, schedule.ErrRunAtInPast)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
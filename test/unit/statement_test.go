@@ -0,0 +1,161 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/statement"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var statementColumns = []string{"id", "created_at", "type", "counterparty", "merch", "quantity", "amount", "description"}
+
+func expectStatement(mock sqlmock.Sqlmock, from, to time.Time) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, username, coins FROM users WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "coins"}).AddRow(1, "user1", 900))
+	// Операции начиная с from: -130 за период и +30 после него, значит входящий баланс 900 + 100 = 1000
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(s.sign \* t.amount\), 0\) FROM transactions t .+ t.created_at >= \$4`).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), from).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(-100))
+	mock.ExpectQuery(`SELECT t.id, t.created_at, t.type, .+ ORDER BY t.created_at, t.id`).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), from, to).
+		WillReturnRows(sqlmock.NewRows(statementColumns).
+			AddRow(10, from.Add(time.Hour), "sent", "user2", "", 1, -100, "thanks").
+			AddRow(11, from.Add(2*time.Hour), "received", "user3", "", 1, 50, "").
+			AddRow(12, from.Add(3*time.Hour), "purchased", "", "cup", 1, -80, ""))
+	mock.ExpectRollback()
+}
+
+func TestStatement_CSV(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := statement.NewStatementService(db)
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	expectStatement(mock, from, to)
+
+	// Выполняем тест
+	var buf bytes.Buffer
+	flushes := 0
+	err = service.Write(context.Background(), statement.Request{UserID: 1, From: from, To: to},
+		statement.NewCSVWriter(&buf, func() { flushes++ }))
+	assert.NoError(t, err)
+	assert.Equal(t, "id,created_at,type,counterparty,merch,quantity,amount,balance,description\n"+
+		",2025-01-01T00:00:00Z,opening_balance,,,,,1000,\n"+
+		"10,2025-01-01T01:00:00Z,sent,user2,,1,-100,900,thanks\n"+
+		"11,2025-01-01T02:00:00Z,received,user3,,1,50,950,\n"+
+		"12,2025-01-01T03:00:00Z,purchased,,cup,1,-80,870,\n"+
+		",,closing_balance,,,,,870,\n", buf.String())
+	assert.Equal(t, 2, flushes)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStatement_CSVFormulas(t *testing.T) {
+	// Текст, начинающийся как формула, экранируется; обычный текст и числа не меняются
+	var buf bytes.Buffer
+	w := statement.NewCSVWriter(&buf, func() {})
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, e := range []statement.Entry{
+		{ID: 1, CreatedAt: created, Type: "received", Counterparty: "user2", Amount: 10, Balance: 10, Description: `=HYPERLINK("http://evil")`},
+		{ID: 2, CreatedAt: created, Type: "received", Counterparty: "@user3", Amount: 10, Balance: 20, Description: "+1"},
+		{ID: 3, CreatedAt: created, Type: "sent", Counterparty: "user2", Amount: -5, Balance: 15, Description: "-5 за обед"},
+	} {
+		assert.NoError(t, w.Entry(e))
+	}
+	assert.NoError(t, w.End(15))
+	assert.Equal(t, "1,2025-01-01T00:00:00Z,received,user2,,,10,10,\"'=HYPERLINK(\"\"http://evil\"\")\"\n"+
+		"2,2025-01-01T00:00:00Z,received,'@user3,,,10,20,'+1\n"+
+		"3,2025-01-01T00:00:00Z,sent,user2,,,-5,15,'-5 за обед\n"+
+		",,closing_balance,,,,,15,\n", buf.String())
+}
+
+func TestStatement_JSON(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := statement.NewStatementService(db)
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	expectStatement(mock, from, to)
+
+	// Выполняем тест
+	var buf bytes.Buffer
+	err = service.Write(context.Background(), statement.Request{UserID: 1, From: from, To: to},
+		statement.NewJSONWriter(&buf, func() {}))
+	assert.NoError(t, err)
+
+	// Собранный по частям документ должен разбираться как обычный JSON
+	var doc struct {
+		Username       string            `json:"username"`
+		OpeningBalance int               `json:"openingBalance"`
+		Entries        []statement.Entry `json:"entries"`
+		ClosingBalance int               `json:"closingBalance"`
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, "user1", doc.Username)
+	assert.Equal(t, 1000, doc.OpeningBalance)
+	if assert.Len(t, doc.Entries, 3) {
+		assert.Equal(t, 900, doc.Entries[0].Balance)
+		assert.Equal(t, "cup", doc.Entries[2].Merch)
+	}
+	assert.Equal(t, 870, doc.ClosingBalance)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStatement_Validation(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := statement.NewStatementService(db)
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, username, coins FROM users WHERE username = \$1`).
+		WithArgs("ghost").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "coins"}))
+	mock.ExpectRollback()
+
+	// Выполняем тест: до обращения к Writer ничего не пишется, и обработчик еще может вернуть 404
+	var buf bytes.Buffer
+	err = service.Write(context.Background(), statement.Request{UserID: 1, Username: "ghost", From: from, To: from.AddDate(0, 1, 0)},
+		statement.NewCSVWriter(&buf, func() {}))
+	assert.ErrorIs(t, err, statement.ErrUserNotFound)
+	assert.Empty(t, buf.String())
+
+	// Пустой период отклоняется без обращения к базе
+	err = service.Write(context.Background(), statement.Request{UserID: 1, From: from, To: from},
+		statement.NewCSVWriter(&buf, func() {}))
+	assert.ErrorIs(t, err, statement.ErrInvalidPeriod)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package unit
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"Avito-trainee/internal/info"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var historyColumns = []string{"id", "type", "amount", "counterparty", "merch", "quantity", "order_id", "reason", "message", "tags", "hold_id", "reverses_id", "balance_after", "created_at"}
+
+func TestListTransactions_Pagination(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := info.NewInfoService(db)
+	now := time.Now()
+	cursor := base64.RawURLEncoding.EncodeToString([]byte("40"))
+
+	// Запрашивается на одну запись больше лимита, чтобы узнать о следующей странице.
+	// Фильтры и LIMIT применяются до окна; баланс начинается с суммы записей новее страницы,
+	// окно идет только по записям между первой и последней записью страницы
+	mock.ExpectQuery(`WITH signs AS .+ page AS .+ WHERE t\.user_id = \$1 AND \(\$4 = 0 OR t\.id < \$4\) .+ LIMIT \$10 \), anchor AS .+ t\.id > \(SELECT max\(id\) FROM page\) .+ span AS .+ t\.id BETWEEN \(SELECT min\(id\) FROM page\) AND \(SELECT max\(id\) FROM page\) \) .+ FROM page p JOIN span b ON b\.id = p\.id ORDER BY p\.id DESC`).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), 40, "{\"sent\"}", "user2", "", nil, nil, 3).
+		WillReturnRows(sqlmock.NewRows(historyColumns).
+			AddRow(35, "sent", 100, "user2", "", 1, nil, "", "", "{}", nil, nil, 700, now).
+			AddRow(31, "sent", 50, "user2", "", 1, nil, "", "thanks", "{help}", nil, nil, 800, now).
+			AddRow(12, "sent", 10, "user2", "", 1, nil, "", "", "{}", nil, nil, 950, now))
+
+	// Выполняем тест
+	page, err := service.ListTransactions(context.Background(), 1, info.TransactionFilter{
+		Types:        []string{"sent"},
+		Counterparty: "user2",
+		Cursor:       cursor,
+		Limit:        2,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Items, 2) {
+		assert.Equal(t, 35, page.Items[0].ID)
+		assert.Equal(t, 700, page.Items[0].BalanceAfter)
+		assert.Equal(t, []string{"help"}, page.Items[1].Tags)
+	}
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString([]byte("31")), page.NextCursor)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestListTransactions_Validation(t *testing.T) {
+	// Создаем mock базы данных
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := info.NewInfoService(db)
+	from := time.Now()
+	to := from.Add(-time.Hour)
+
+	// Некорректные фильтры отклоняются до обращения к базе
+	_, err = service.ListTransactions(context.Background(), 1, info.TransactionFilter{Types: []string{"stolen"}})
+	assert.ErrorIs(t, err, info.ErrInvalidType)
+
+	_, err = service.ListTransactions(context.Background(), 1, info.TransactionFilter{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, info.ErrInvalidCursor)
+
+	_, err = service.ListTransactions(context.Background(), 1, info.TransactionFilter{From: &from, To: &to})
+	assert.ErrorIs(t, err, info.ErrInvalidPeriod)
+
+	// Проверяем, что все ожидания выполнены
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}